@@ -0,0 +1,488 @@
+package sealfile
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/crdzbird/sealfile/internal/api"
+)
+
+const (
+	defaultAPIConcurrency = 4
+	defaultAPIBatchBytes  = 64 << 20
+)
+
+// ErrResponseTooLarge is reported for the operations of a batch unseal beyond APIOptions.MaxResponseBytes
+var ErrResponseTooLarge = errors.New("batch response too large")
+
+// APIOptions configures the HTTP API
+type APIOptions struct {
+	Middleware       func(http.Handler) http.Handler // Wraps every endpoint, such as BearerAuth; nil serves without authentication
+	MaxConcurrency   int                             // Upper bound of the concurrency of batch requests, 4 when zero
+	MaxBatchBytes    int64                           // Largest body of a batch request, 64 MiB when zero
+	MaxFileBytes     int64                           // Largest file sealed by PUT, DefaultMaxUploadSize when zero and unlimited when negative
+	MaxResponseBytes int64                           // Largest total of data returned by a batch unseal, 64 MiB when zero
+}
+
+// APIError is an error reported by the HTTP API. It matches the package error of its kind,
+// so errors.Is(err, ErrNotFound) works the same for local and remote stores.
+type APIError struct {
+	Status  int    // HTTP status, zero for errors of batch items
+	Kind    string // See ErrorKind
+	Message string
+}
+
+// Error returns the message reported by the server
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the package error of the kind, nil when there is none
+func (e *APIError) Unwrap() error {
+	return errorOfKind(e.Kind)
+}
+
+// apiServer serves the HTTP API of a FileManager
+type apiServer struct {
+	fm      *FileManager
+	options APIOptions
+}
+
+// APIHandler returns an http.Handler exposing the operations of Store as a JSON API.
+// Paths are passed as they are to the FileManager, so serve it with Config.RootDir set.
+// It fails when Config.RootDir is empty and neither a Middleware nor an Authorizer
+// guards the API, since any client could then reach every file of the host.
+// Package client implements Store against it.
+func (fm *FileManager) APIHandler(options APIOptions) (http.Handler, error) {
+	if fm.current().config.RootDir == "" && options.Middleware == nil && fm.authorizer.Load() == nil {
+		return nil, fmt.Errorf("APIHandler without Config.RootDir needs a Middleware or an Authorizer")
+	}
+	if options.MaxConcurrency <= 0 {
+		options.MaxConcurrency = defaultAPIConcurrency
+	}
+	if options.MaxBatchBytes <= 0 {
+		options.MaxBatchBytes = defaultAPIBatchBytes
+	}
+	if options.MaxResponseBytes <= 0 {
+		options.MaxResponseBytes = defaultAPIBatchBytes
+	}
+	if options.MaxFileBytes == 0 {
+		options.MaxFileBytes = DefaultMaxUploadSize
+	}
+	s := &apiServer{fm: fm, options: options}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT "+api.PathFile, s.saveFile)
+	mux.HandleFunc("GET "+api.PathFile, s.openFile)
+	mux.HandleFunc("DELETE "+api.PathFile, s.deleteFile)
+	mux.HandleFunc("GET "+api.PathFiles, s.listFiles)
+	mux.HandleFunc("POST "+api.PathCopy, s.copyFile)
+	mux.HandleFunc("POST "+api.PathMove, s.moveFile)
+	mux.HandleFunc("POST "+api.PathBatchSeal, s.batch((*FileManager).CreateMultipleEncryptedFiles))
+	mux.HandleFunc("POST "+api.PathBatchUnseal, s.batch(s.unsealWithinLimit))
+	mux.HandleFunc("POST "+api.PathBatchDelete, s.batch((*FileManager).DeleteMultipleFiles))
+	mux.HandleFunc("POST "+api.PathBatchCopy, s.batchCopy)
+
+	if options.Middleware != nil {
+		return options.Middleware(mux), nil
+	}
+	return mux, nil
+}
+
+// BearerAuth returns middleware admitting requests whose Authorization header carries one of tokens
+func BearerAuth(tokens ...string) func(http.Handler) http.Handler {
+	// Hashing gives every comparison the same length
+	hashes := make([][32]byte, len(tokens))
+	for i, token := range tokens {
+		hashes[i] = sha256.Sum256([]byte(token))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok {
+				hash := sha256.Sum256([]byte(token))
+				for _, known := range hashes {
+					if subtle.ConstantTimeCompare(hash[:], known[:]) == 1 {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="sealfile"`)
+			writeAPIError(w, &APIError{Status: http.StatusUnauthorized, Kind: "unauthorized", Message: "missing or invalid token"})
+		})
+	}
+}
+
+// saveFile seals the request body
+func (s *apiServer) saveFile(w http.ResponseWriter, r *http.Request) {
+	path, filename, err := fileParams(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	var metadata map[string]string
+	if header := r.Header.Get(api.MetadataHeader); header != "" {
+		if err := json.Unmarshal([]byte(header), &metadata); err != nil {
+			writeAPIError(w, invalidRequest("invalid %s header: %v", api.MetadataHeader, err))
+			return
+		}
+	}
+
+	body := r.Body
+	if s.options.MaxFileBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, s.options.MaxFileBytes)
+	}
+	if err := s.fm.WithContext(r.Context()).SaveStream(body, path, filename, metadata); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openFile writes the decrypted content of a file
+func (s *apiServer) openFile(w http.ResponseWriter, r *http.Request) {
+	path, filename, err := fileParams(r)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	reader, metadata, err := s.fm.WithContext(r.Context()).OpenStream(path, filename)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	defer func() { _ = reader.Close() }()
+
+	if len(metadata) > 0 {
+		encoded, _ := json.Marshal(metadata)
+		w.Header().Set(api.MetadataHeader, string(encoded))
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Headers are sent by now, a failure can only cut the response short
+	_, _ = io.Copy(w, reader)
+}
+
+// deleteFile deletes a file
+func (s *apiServer) deleteFile(w http.ResponseWriter, r *http.Request) {
+	path, filename, err := fileParams(r)
+	if err == nil {
+		err = s.fm.WithContext(r.Context()).DeleteFile(path, filename)
+	}
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listFiles lists the files of a directory
+func (s *apiServer) listFiles(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeAPIError(w, invalidRequest("missing path"))
+		return
+	}
+
+	entries, err := s.fm.WithContext(r.Context()).ListFiles(path)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	listed := make([]api.Entry, len(entries))
+	for i, entry := range entries {
+		listed[i] = api.Entry{
+			Name:     entry.Name,
+			Size:     entry.Size,
+			ModTime:  entry.ModTime,
+			Format:   string(entry.Format),
+			KeyCheck: entry.KeyCheck,
+		}
+	}
+	writeJSON(w, listed)
+}
+
+// copyFile copies a file
+func (s *apiServer) copyFile(w http.ResponseWriter, r *http.Request) {
+	var op api.Copy
+	if err := s.decode(w, r, &op); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	c := fromAPICopy(op)
+	if err := s.fm.WithContext(r.Context()).CopyFileToNewLocation(c.SourcePath, c.SourceFilename, c.DestPath, c.DestFilename, c.Options); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moveFile moves a file
+func (s *apiServer) moveFile(w http.ResponseWriter, r *http.Request) {
+	var op api.Copy
+	if err := s.decode(w, r, &op); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	c := fromAPICopy(op)
+	if err := s.fm.WithContext(r.Context()).MoveFile(c.SourcePath, c.SourceFilename, c.DestPath, c.DestFilename, c.Options); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// batch serves a batch of file operations with one of the batch methods of FileManager
+func (s *apiServer) batch(run func(*FileManager, []FileOperation, int) []FileOperation) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var batch api.Batch
+		if err := s.decode(w, r, &batch); err != nil {
+			writeAPIError(w, err)
+			return
+		}
+
+		operations := make([]FileOperation, len(batch.Operations))
+		for i, op := range batch.Operations {
+			operations[i] = FileOperation{Path: op.Path, Filename: op.Filename, Data: op.Data}
+		}
+		results := run(s.fm.WithContext(r.Context()), operations, s.concurrency(batch.Concurrency))
+
+		response := api.Batch{Operations: make([]api.Operation, len(results))}
+		for i, result := range results {
+			response.Operations[i] = api.Operation{
+				Path:     result.Path,
+				Filename: result.Filename,
+				Error:    toAPIError(result.Error),
+				Attempts: result.Attempts,
+				Existed:  result.Existed,
+			}
+			// Only unsealing returns data, the request data is not echoed
+			if result.Error == nil && r.URL.Path == api.PathBatchUnseal {
+				response.Operations[i].Data = result.Data
+			}
+		}
+		writeJSON(w, response)
+	}
+}
+
+// unsealWithinLimit unseals a batch in rounds of its concurrency, so that no more than
+// MaxResponseBytes of data is held for the response. The operations whose data would
+// exceed it, and all after them, fail with ErrResponseTooLarge without being unsealed.
+func (s *apiServer) unsealWithinLimit(fm *FileManager, operations []FileOperation, concurrency int) []FileOperation {
+	results := make([]FileOperation, 0, len(operations))
+	var total int64
+	full := false
+	for start := 0; start < len(operations); start += concurrency {
+		round := operations[start:min(start+concurrency, len(operations))]
+		if !full {
+			round = fm.DecryptMultipleFiles(round, concurrency)
+		}
+		for _, result := range round {
+			if !full && result.Error == nil {
+				total += int64(len(result.Data))
+				full = total > s.options.MaxResponseBytes
+			}
+			if full {
+				result.Data = nil
+				result.Error = fmt.Errorf("%w: limit of %d bytes reached", ErrResponseTooLarge, s.options.MaxResponseBytes)
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// batchCopy serves a batch of copies
+func (s *apiServer) batchCopy(w http.ResponseWriter, r *http.Request) {
+	var batch api.CopyBatch
+	if err := s.decode(w, r, &batch); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	operations := make([]CopyOperation, len(batch.Operations))
+	for i, op := range batch.Operations {
+		operations[i] = fromAPICopy(op)
+	}
+	results := s.fm.WithContext(r.Context()).BatchCopyFiles(operations, s.concurrency(batch.Concurrency))
+
+	response := api.CopyBatchResult{Results: make([]api.CopyResult, len(results))}
+	for i, result := range results {
+		response.Results[i] = api.CopyResult{
+			Copy:        toAPICopy(operations[i]),
+			Success:     result.Success,
+			Error:       toAPIError(result.Error),
+			Attempts:    result.Attempts,
+			DestExisted: result.DestExisted,
+			Bytes:       result.Bytes,
+		}
+	}
+	writeJSON(w, response)
+}
+
+// decode reads a JSON request body of limited size
+func (s *apiServer) decode(w http.ResponseWriter, r *http.Request, v any) error {
+	body := http.MaxBytesReader(w, r.Body, s.options.MaxBatchBytes)
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &APIError{Status: http.StatusRequestEntityTooLarge, Kind: "invalid_request", Message: "request body too large"}
+		}
+		return invalidRequest("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// concurrency returns the concurrency of a batch request, capped by the options
+func (s *apiServer) concurrency(requested int) int {
+	if requested <= 0 || requested > s.options.MaxConcurrency {
+		return s.options.MaxConcurrency
+	}
+	return requested
+}
+
+// fileParams returns the path and filename query parameters
+func fileParams(r *http.Request) (string, string, error) {
+	query := r.URL.Query()
+	path, filename := query.Get("path"), query.Get("filename")
+	if path == "" || filename == "" {
+		return "", "", invalidRequest("missing path or filename")
+	}
+	return path, filename, nil
+}
+
+// invalidRequest reports a malformed request
+func invalidRequest(format string, args ...any) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Kind: "invalid_request", Message: fmt.Sprintf(format, args...)}
+}
+
+// toAPIError converts an error for the API, nil stays nil. The message is fixed per kind,
+// errors of the FileManager carry paths of the host that clients have no business seeing.
+func toAPIError(err error) *api.Error {
+	if err == nil {
+		return nil
+	}
+	kind := ErrorKind(err)
+	return &api.Error{Kind: kind, Message: apiMessage(kind)}
+}
+
+// apiStatus returns the HTTP status reported for an error kind
+func apiStatus(kind string) int {
+	switch kind {
+	case "not_found", "job_not_found":
+		return http.StatusNotFound
+	case "already_exists", "revision_mismatch", "aborted", "rolled_back", "job_locked":
+		return http.StatusConflict
+	case "path_escape", "tenant_mismatch", "permission_denied":
+		return http.StatusForbidden
+	case "wrong_key", "corrupted", "authentication_failed", "unsupported_format", "scan_rejected":
+		return http.StatusUnprocessableEntity
+	case "content_rejected":
+		return http.StatusUnsupportedMediaType
+	case "response_too_large":
+		return http.StatusRequestEntityTooLarge
+	case "invalid_tenant":
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// apiMessage returns the message reported for an error kind
+func apiMessage(kind string) string {
+	switch kind {
+	case "not_found":
+		return "file not found"
+	case "already_exists":
+		return "destination already exists"
+	case "revision_mismatch":
+		return "file changed since it was read"
+	case "wrong_key":
+		return "file sealed with another key"
+	case "corrupted":
+		return "sealed data corrupted"
+	case "authentication_failed":
+		return "sealed data does not authenticate"
+	case "unsupported_format":
+		return "file is not sealed"
+	case "path_escape":
+		return "path outside the store"
+	case "tenant_mismatch":
+		return "file belongs to another tenant"
+	case "invalid_tenant":
+		return "invalid tenant"
+	case "permission_denied":
+		return "operation not permitted"
+	case "content_rejected":
+		return "file content not accepted"
+	case "scan_rejected":
+		return "file rejected by scanner"
+	case "aborted":
+		return "batch aborted"
+	case "rolled_back":
+		return "batch rolled back"
+	case "job_not_found":
+		return "job not found"
+	case "job_locked":
+		return "job is locked by another process"
+	case "response_too_large":
+		return "batch response too large"
+	default:
+		return "operation failed"
+	}
+}
+
+// writeAPIError reports a failed request with the fixed message of its kind, see toAPIError
+func writeAPIError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &apiErr):
+	case errors.As(err, &tooLarge):
+		apiErr = &APIError{Status: http.StatusRequestEntityTooLarge, Kind: "invalid_request", Message: fmt.Sprintf("request exceeds %d bytes", tooLarge.Limit)}
+	default:
+		kind := ErrorKind(err)
+		apiErr = &APIError{Status: apiStatus(kind), Kind: kind, Message: apiMessage(kind)}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	_ = json.NewEncoder(w).Encode(api.Error{Kind: apiErr.Kind, Message: apiErr.Message})
+}
+
+// writeJSON writes a successful JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// fromAPICopy converts a copy of the API
+func fromAPICopy(op api.Copy) CopyOperation {
+	return CopyOperation{
+		SourcePath:     op.SourcePath,
+		SourceFilename: op.SourceFilename,
+		DestPath:       op.DestPath,
+		DestFilename:   op.DestFilename,
+		Options:        CopyOptions(op.Options),
+	}
+}
+
+// toAPICopy converts a copy for the API
+func toAPICopy(op CopyOperation) api.Copy {
+	return api.Copy{
+		SourcePath:     op.SourcePath,
+		SourceFilename: op.SourceFilename,
+		DestPath:       op.DestPath,
+		DestFilename:   op.DestFilename,
+		Options:        api.CopyOptions(op.Options),
+	}
+}
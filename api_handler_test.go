@@ -0,0 +1,116 @@
+package sealfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/crdzbird/sealfile/internal/api"
+)
+
+func TestAPIHandlerRefusesUnconfinedUnguardedStore(t *testing.T) {
+	fm := newTestFileManager(t, func(config *Config) { config.RootDir = "" })
+	if handler, err := fm.APIHandler(APIOptions{}); err == nil || handler != nil {
+		t.Fatal("APIHandler served every file of the host without authentication")
+	}
+
+	// Guarded by middleware it is up to the caller
+	if _, err := fm.APIHandler(APIOptions{Middleware: BearerAuth("token")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// apiHandlerForTest returns the API handler of fm
+func apiHandlerForTest(t *testing.T, fm *FileManager, options APIOptions) http.Handler {
+	t.Helper()
+	handler, err := fm.APIHandler(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestAPISaveFileLimit(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	handler := apiHandlerForTest(t, fm, APIOptions{MaxFileBytes: 100})
+
+	for _, test := range []struct {
+		size   int
+		status int
+	}{{100, http.StatusNoContent}, {101, http.StatusRequestEntityTooLarge}} {
+		w := httptest.NewRecorder()
+		target := api.PathFile + "?path=files&filename=" + strconv.Itoa(test.size)
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, target, bytes.NewReader(make([]byte, test.size))))
+		if w.Code != test.status {
+			t.Fatalf("%d bytes answered %d: %s", test.size, w.Code, w.Body)
+		}
+	}
+	if exists(rootPath(fm, "files/101")) || !exists(rootPath(fm, "files/100")) {
+		t.Fatal("the limit was not applied to the stored files")
+	}
+}
+
+func TestAPIErrorsHideServerPaths(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	handler := apiHandlerForTest(t, fm, APIOptions{})
+	root := fm.current().config.RootDir
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, api.PathFile+"?path=files&filename=missing", nil))
+	var reported api.Error
+	if err := json.Unmarshal(w.Body.Bytes(), &reported); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound || reported.Kind != "not_found" || reported.Message != "file not found" {
+		t.Fatalf("missing file answered %d: %s", w.Code, w.Body)
+	}
+
+	request, err := json.Marshal(api.Batch{Operations: []api.Operation{{Path: "files", Filename: "missing"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, api.PathBatchUnseal, bytes.NewReader(request)))
+	if strings.Contains(w.Body.String(), root) || !strings.Contains(w.Body.String(), `"kind":"not_found"`) {
+		t.Fatalf("batch item reported %s", w.Body)
+	}
+}
+
+func TestAPIBatchUnsealResponseLimit(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := fm.SaveDataAsSecureFile(bytes.Repeat([]byte(name), 100), "files", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := apiHandlerForTest(t, fm, APIOptions{MaxResponseBytes: 250})
+
+	request, err := json.Marshal(api.Batch{Concurrency: 1, Operations: []api.Operation{
+		{Path: "files", Filename: "a"}, {Path: "files", Filename: "b"}, {Path: "files", Filename: "c"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, api.PathBatchUnseal, bytes.NewReader(request)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("batch unseal answered %d: %s", w.Code, w.Body)
+	}
+
+	var response api.Batch
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	for i, op := range response.Operations[:2] {
+		if op.Error != nil || len(op.Data) != 100 {
+			t.Fatalf("operation %d within the limit: error %v, %d bytes", i, op.Error, len(op.Data))
+		}
+	}
+	if last := response.Operations[2]; last.Error == nil || last.Error.Kind != "response_too_large" || last.Data != nil {
+		t.Fatalf("operation beyond the limit: error %v, %d bytes", last.Error, len(last.Data))
+	}
+}
@@ -0,0 +1,209 @@
+package sealfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AccessOp names an operation checked by an Authorizer
+type AccessOp string
+
+const (
+	AccessSave   AccessOp = "save"   // Seal a file, also the destination of a copy or move
+	AccessLoad   AccessOp = "load"   // Decrypt a file
+	AccessDelete AccessOp = "delete" // Delete a file, also the source of a move
+	AccessCopy   AccessOp = "copy"   // Copy or move a file away, decrypting copies need AccessLoad as well
+	AccessList   AccessOp = "list"   // List a directory
+)
+
+// Authorizer decides whether the principal of ctx may perform op on a file or directory.
+// Paths are slash-separated and relative to Config.RootDir, the root itself being ".";
+// without a root directory they are the cleaned paths given by the caller.
+// Any error denies the operation; errors not matching ErrPermissionDenied are marked as such.
+type Authorizer interface {
+	Authorize(ctx context.Context, op AccessOp, path string) error
+}
+
+// AuthorizerFunc adapts a function to an Authorizer
+type AuthorizerFunc func(ctx context.Context, op AccessOp, path string) error
+
+// Authorize calls f
+func (f AuthorizerFunc) Authorize(ctx context.Context, op AccessOp, path string) error {
+	return f(ctx, op, path)
+}
+
+// Principal is the caller an operation is authorized for
+type Principal struct {
+	ID    string
+	Roles []string
+}
+
+// HasRole reports whether the principal holds role
+func (p Principal) HasRole(role string) bool {
+	for _, held := range p.Roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+// principalKey is the context key of the principal
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying principal, see FileManager.WithContext
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal carried by ctx
+func PrincipalFrom(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// PolicyRule grants operations below a path prefix to principals holding one of its roles
+type PolicyRule struct {
+	Prefix     string     // Slash-separated directory or file the rule covers, every path when empty
+	Roles      []string   // Roles the rule grants to, every caller including anonymous ones when empty
+	Operations []AccessOp // Operations the rule grants, all when empty
+}
+
+// PathPolicy is an Authorizer granting an operation when one of its rules does.
+// Everything no rule grants is denied.
+type PathPolicy struct {
+	Rules []PolicyRule
+}
+
+// Authorize grants op on path when a rule covers the path, the operation and a role of the principal
+func (p *PathPolicy) Authorize(ctx context.Context, op AccessOp, path string) error {
+	principal, _ := PrincipalFrom(ctx)
+	for _, rule := range p.Rules {
+		if rule.covers(principal, op, path) {
+			return nil
+		}
+	}
+
+	name := principal.ID
+	if name == "" {
+		name = "anonymous"
+	}
+	return fmt.Errorf("%w: %s may not %s %s", ErrPermissionDenied, name, op, path)
+}
+
+// covers reports whether the rule grants op on target to principal
+func (r PolicyRule) covers(principal Principal, op AccessOp, target string) bool {
+	if !pathCovers(r.Prefix, target) {
+		return false
+	}
+	if len(r.Operations) > 0 && !containsOp(r.Operations, op) {
+		return false
+	}
+	if len(r.Roles) == 0 {
+		return true
+	}
+	for _, role := range r.Roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathCovers reports whether the slash-separated prefix covers target. Prefixes match
+// whole path segments, "docs" covers "docs/a" but not "docs2"; an empty prefix covers everything.
+func pathCovers(prefix, target string) bool {
+	prefix = strings.TrimSuffix(path.Clean("/"+prefix), "/")
+	if prefix == "" {
+		return true
+	}
+	target = strings.TrimSuffix(path.Clean("/"+target), "/")
+	return target == prefix || strings.HasPrefix(target, prefix+"/")
+}
+
+// containsOp reports whether ops lists op
+func containsOp(ops []AccessOp, op AccessOp) bool {
+	for _, candidate := range ops {
+		if candidate == op {
+			return true
+		}
+	}
+	return false
+}
+
+// SetAuthorizer installs an Authorizer consulted before every operation on a file or
+// directory, nil removes it. Operations are authorized for the principal of the context
+// given to WithContext. The authorizer is shared with FileManagers derived from fm.
+func (fm *FileManager) SetAuthorizer(authorizer Authorizer) {
+	if authorizer == nil {
+		fm.authorizer.Store(nil)
+		return
+	}
+	fm.authorizer.Store(&authorizer)
+}
+
+// WithContext returns a FileManager whose operations are authorized for the principal of ctx.
+// It is bound to the configuration active at the time of the call, derive one per request or task.
+func (fm *FileManager) WithContext(ctx context.Context) *FileManager {
+	scoped := fm.pinned()
+	scoped.ctx = ctx
+	return scoped
+}
+
+// Context returns the context operations are authorized for
+func (fm *FileManager) Context() context.Context {
+	if fm.ctx == nil {
+		return context.Background()
+	}
+	return fm.ctx
+}
+
+// internal returns a FileManager bound to the active snapshot that skips authorization,
+// for files the package manages itself such as partial uploads and journal payloads
+func (fm *FileManager) internal() *FileManager {
+	unchecked := fm.pinned()
+	unchecked.unchecked = true
+	return unchecked
+}
+
+// signed returns a FileManager bound to the active snapshot for a request carrying a valid
+// signed URL. The signer was authorized when signing, so authorization is skipped; unlike
+// internal, content checks and scans still apply.
+func (fm *FileManager) signed() *FileManager {
+	signed := fm.pinned()
+	signed.presigned = true
+	return signed
+}
+
+// authorize asks the authorizer whether op may be performed on the resolved path
+func (fm *FileManager) authorize(op AccessOp, fullPath string) error {
+	if fm.unchecked || fm.presigned {
+		return nil
+	}
+	authorizer := fm.authorizer.Load()
+	if authorizer == nil {
+		return nil
+	}
+
+	err := (*authorizer).Authorize(fm.Context(), op, fm.current().authorizedPath(fullPath))
+	if err != nil && !errors.Is(err, ErrPermissionDenied) {
+		return mark(ErrPermissionDenied, err)
+	}
+	return err
+}
+
+// authorizedPath returns the path shown to an Authorizer for a resolved path
+func (s *configSnapshot) authorizedPath(fullPath string) string {
+	if root := s.config.RootDir; root != "" {
+		if rootAbs, err := filepath.Abs(root); err == nil {
+			if rel, err := filepath.Rel(rootAbs, fullPath); err == nil {
+				return filepath.ToSlash(rel)
+			}
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(fullPath))
+}
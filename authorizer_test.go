@@ -0,0 +1,163 @@
+package sealfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPathPolicy(t *testing.T) {
+	policy := &PathPolicy{Rules: []PolicyRule{
+		{Prefix: "public", Operations: []AccessOp{AccessLoad, AccessList}},
+		{Prefix: "docs/", Roles: []string{"editor"}},
+		{Prefix: "reports", Roles: []string{"auditor"}, Operations: []AccessOp{AccessLoad}},
+		{Roles: []string{"admin"}},
+	}}
+	anonymous := Principal{}
+	editor := Principal{ID: "eve", Roles: []string{"editor"}}
+	auditor := Principal{ID: "ann", Roles: []string{"auditor"}}
+	admin := Principal{ID: "root", Roles: []string{"admin"}}
+
+	tests := []struct {
+		name      string
+		principal Principal
+		op        AccessOp
+		path      string
+		allowed   bool
+	}{
+		{"anonymous load of a public file", anonymous, AccessLoad, "public/a.txt", true},
+		{"anonymous list of the public directory", anonymous, AccessList, "public", true},
+		{"anonymous save of a public file", anonymous, AccessSave, "public/a.txt", false},
+		{"anonymous load elsewhere", anonymous, AccessLoad, "docs/a.txt", false},
+		{"editor save below the prefix", editor, AccessSave, "docs/sub/a.txt", true},
+		{"editor on the prefix itself", editor, AccessList, "docs", true},
+		{"prefix matches whole segments", editor, AccessSave, "docs2/a.txt", false},
+		{"cleaned path leaving the prefix", editor, AccessLoad, "docs/../reports/q3.pdf", false},
+		{"cleaned path within the prefix", editor, AccessLoad, "docs/./sub/../a.txt", true},
+		{"auditor load", auditor, AccessLoad, "reports/q3.pdf", true},
+		{"auditor delete", auditor, AccessDelete, "reports/q3.pdf", false},
+		{"role of another rule", auditor, AccessLoad, "docs/a.txt", false},
+		{"admin everywhere", admin, AccessDelete, "reports/q3.pdf", true},
+		{"admin at the root", admin, AccessList, ".", true},
+	}
+	for _, test := range tests {
+		err := policy.Authorize(WithPrincipal(context.Background(), test.principal), test.op, test.path)
+		if test.allowed && err != nil {
+			t.Errorf("%s: denied: %v", test.name, err)
+		}
+		if !test.allowed && !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s: got %v, want ErrPermissionDenied", test.name, err)
+		}
+	}
+
+	// Denials name the principal, a context without one is anonymous
+	err := policy.Authorize(context.Background(), AccessSave, "docs/a.txt")
+	if err == nil || !strings.Contains(err.Error(), "anonymous may not save docs/a.txt") {
+		t.Fatalf("got %v", err)
+	}
+}
+
+// recordingAuthorizer records every call and denies every operation while deny is set
+type recordingAuthorizer struct {
+	mu    sync.Mutex
+	calls []string
+	deny  bool
+}
+
+// Authorize records the call as "op path"
+func (a *recordingAuthorizer) Authorize(_ context.Context, op AccessOp, path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls = append(a.calls, fmt.Sprintf("%s %s", op, path))
+	if a.deny {
+		return errors.New("denied")
+	}
+	return nil
+}
+
+// take returns the recorded calls and forgets them
+func (a *recordingAuthorizer) take() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	calls := a.calls
+	a.calls = nil
+	return calls
+}
+
+func TestAuthorizerSeesEveryOperation(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	authorizer := &recordingAuthorizer{}
+	fm.SetAuthorizer(authorizer)
+
+	operations := []struct {
+		name string
+		run  func() error
+		want []string
+	}{
+		{"save", func() error {
+			_, err := fm.SaveDataAsSecureFile([]byte("content"), "files", "a.txt")
+			return err
+		}, []string{"save files/a.txt"}},
+		{"load", func() error {
+			_, err := fm.LoadSecureFileFromDisk("files", "a.txt")
+			return err
+		}, []string{"load files/a.txt"}},
+		{"copy", func() error {
+			return fm.CopyFileToNewLocation("files", "a.txt", "files", "b.txt", CopyOptions{})
+		}, []string{"save files/b.txt", "copy files/a.txt"}},
+		{"decrypting copy", func() error {
+			return fm.CopyFileToNewLocation("files", "a.txt", "files", "plain.txt", CopyOptions{DecryptBeforeCopy: true})
+		}, []string{"save files/plain.txt", "copy files/a.txt", "load files/a.txt"}},
+		{"move", func() error {
+			return fm.MoveFile("files", "b.txt", "files", "c.txt", CopyOptions{})
+		}, []string{"copy files/b.txt", "delete files/b.txt", "save files/c.txt"}},
+		{"list", func() error {
+			_, err := fm.ListFiles("files")
+			return err
+		}, []string{"list files"}},
+		{"delete", func() error {
+			return fm.DeleteFile("files", "c.txt")
+		}, []string{"delete files/c.txt"}},
+	}
+
+	for _, operation := range operations {
+		if err := operation.run(); err != nil {
+			t.Fatalf("%s: %v", operation.name, err)
+		}
+		calls := authorizer.take()
+		for _, want := range operation.want {
+			if !slices.Contains(calls, want) {
+				t.Errorf("%s: authorizer saw %v, missing %q", operation.name, calls, want)
+			}
+		}
+	}
+
+	// Denied, every operation fails and leaves the files as they are
+	before := treeForTest(t, fm.current().config.RootDir)
+	authorizer.deny = true
+	for _, operation := range operations {
+		if err := operation.run(); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("%s: got %v, want ErrPermissionDenied", operation.name, err)
+		}
+		if calls := authorizer.take(); len(calls) == 0 {
+			t.Errorf("%s: authorizer not asked", operation.name)
+		}
+	}
+	after := treeForTest(t, fm.current().config.RootDir)
+	if len(after) != len(before) {
+		t.Fatalf("denied operations changed the tree from %v to %v", before, after)
+	}
+	for path, content := range before {
+		if after[path] != content {
+			t.Fatalf("denied operations changed %s", path)
+		}
+	}
+	if _, err := os.Stat(rootPath(fm, "files/a.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,63 +1,306 @@
 package sealfile
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 )
 
+// FailurePolicy defines how a batch reacts when one of its items fails
+type FailurePolicy int
+
+const (
+	// ContinueOnError runs every item regardless of failures
+	ContinueOnError FailurePolicy = iota
+	// FailFast stops starting new items after the first failure
+	FailFast
+	// AllOrNothing stages every write and rolls the whole batch back if any item fails
+	AllOrNothing
+)
+
+var (
+	// ErrBatchAborted is reported for items skipped after an earlier failure
+	ErrBatchAborted = errors.New("batch aborted after an earlier failure")
+	// ErrBatchRolledBack is reported for items undone because another item failed
+	ErrBatchRolledBack = errors.New("batch rolled back after a failure")
+)
+
+// BatchOptions defines options for a batch processor
+type BatchOptions struct {
+	Concurrency   int
+	FailurePolicy FailurePolicy
+	Retry         *RetryPolicy // Retries failed items, each item runs once when nil
+	MemoryBudget  int64        // Bytes that sealing, loading and copying may hold in flight, unlimited when zero
+	DryRun        bool         // Validate mutating operations without writing or deleting anything
+}
+
 // BatchProcessor processes multiple files concurrently
 type BatchProcessor struct {
 	fm          *FileManager
 	concurrency int
+	policy      FailurePolicy
+	retry       *RetryPolicy
+	budget      *memoryBudget
+	dryRun      bool
 }
 
 // NewBatchProcessor creates a new batch processor
 func NewBatchProcessor(fm *FileManager, concurrency int) *BatchProcessor {
+	return NewBatchProcessorWithOptions(fm, BatchOptions{Concurrency: concurrency})
+}
+
+// NewBatchProcessorWithOptions creates a new batch processor with the given options
+func NewBatchProcessorWithOptions(fm *FileManager, options BatchOptions) *BatchProcessor {
+	concurrency := options.Concurrency
 	if concurrency <= 0 {
 		concurrency = 5 // Default concurrency
 	}
 	return &BatchProcessor{
 		fm:          fm,
 		concurrency: concurrency,
+		policy:      options.FailurePolicy,
+		retry:       options.Retry,
+		budget:      newMemoryBudget(options.MemoryBudget),
+		dryRun:      options.DryRun,
 	}
 }
 
-// ProcessFiles processes multiple files concurrently
-func (bp *BatchProcessor) ProcessFiles(files []*SecureFile, processor func(*SecureFile) error) []error {
+// run executes fn for every item index, honouring the concurrency limit, the retry policy
+// and the failure policy. Entries an item staged in tx are discarded before it is retried.
+// It returns the error and the number of attempts of every item.
+func (bp *BatchProcessor) run(count int, tx *transaction, fn func(index int) error) ([]error, []int) {
+	errs := make([]error, count)
+	attempts := make([]int, count)
 	semaphore := make(chan struct{}, bp.concurrency)
-	errChan := make(chan error, len(files))
 
-	for _, file := range files {
-		go func(f *SecureFile) {
+	var aborted atomic.Bool
+	var wg sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
 			semaphore <- struct{}{}        // Acquire semaphore
 			defer func() { <-semaphore }() // Release semaphore
-			if err := processor(f); err != nil {
-				errChan <- fmt.Errorf("failed to process file %s: %w", f.Filename, err)
+
+			if aborted.Load() {
+				errs[index] = ErrBatchAborted
 				return
 			}
-			errChan <- nil
-		}(file)
+			var err error
+			attempts[index], err = bp.retry.DoContext(bp.fm.Context(), func() error {
+				if tx != nil {
+					tx.discard(index)
+				}
+				return fn(index)
+			})
+			if err != nil {
+				errs[index] = err
+				if bp.policy != ContinueOnError {
+					aborted.Store(true)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	return errs, attempts
+}
+
+// itemManager returns fm bound to its active snapshot for running batch items. When the batch
+// retries items, Config.Retry is turned off for them, so failures are retried at one layer only.
+func (bp *BatchProcessor) itemManager(fm *FileManager) *FileManager {
+	snapshot := fm.current()
+	if bp.retry == nil || snapshot.config.Retry == nil {
+		return fm.withSnapshot(snapshot)
+	}
+	single := *snapshot
+	single.config = snapshot.config.clone()
+	single.config.Retry = nil
+	return fm.withSnapshot(&single)
+}
+
+// withItem runs fn on a copy of sf bound to itemManager and takes over the outcome
+func (bp *BatchProcessor) withItem(sf *SecureFile, fn func(*SecureFile) error) error {
+	if bp.retry == nil {
+		return fn(sf)
+	}
+	item := *sf
+	item.fm = bp.itemManager(sf.fm)
+	err := fn(&item)
+	item.fm = sf.fm
+	*sf = item
+	return err
+}
+
+// withBudget runs fn once cost bytes fit into the memory budget
+func (bp *BatchProcessor) withBudget(cost int64, fn func() error) error {
+	bp.budget.acquire(cost)
+	defer bp.budget.release(cost)
+	return fn()
+}
+
+// loadCost estimates the memory held while loading the given file of fm
+func (bp *BatchProcessor) loadCost(fm *FileManager, path, filename string) int64 {
+	if bp.budget == nil {
+		return 0
+	}
+	_, fullPath, err := fm.current().resolveFile(path, filename)
+	if err != nil {
+		return 0
+	}
+	return bp.budget.loadCost(fullPath)
+}
+
+// finish commits or rolls back a transactional batch and reports the outcome per item
+func (bp *BatchProcessor) finish(tx *transaction, errs []error) []error {
+	failed := false
+	for _, err := range errs {
+		if err != nil {
+			failed = true
+			break
+		}
 	}
 
-	// Collect errors
-	errors := make([]error, len(files))
-	for i := 0; i < len(files); i++ {
-		errors[i] = <-errChan
+	if !failed {
+		index, err := tx.commit()
+		if err == nil {
+			return errs
+		}
+		errs[index] = err
+	} else {
+		tx.rollback()
 	}
-	return errors
+
+	for i, err := range errs {
+		if err == nil {
+			errs[i] = ErrBatchRolledBack
+		}
+	}
+	return errs
+}
+
+// ProcessFiles processes multiple files concurrently.
+// An arbitrary processor cannot be undone, so AllOrNothing behaves like FailFast here,
+// and it cannot be validated either, so DryRun does not apply to it.
+func (bp *BatchProcessor) ProcessFiles(files []*SecureFile, processor func(*SecureFile) error) []error {
+	errs, _ := bp.run(len(files), nil, func(index int) error {
+		f := files[index]
+		if err := processor(f); err != nil {
+			return fmt.Errorf("failed to process file %s: %w", f.Filename, err)
+		}
+		return nil
+	})
+	return errs
 }
 
 // SaveAllFiles saves multiple files concurrently
 func (bp *BatchProcessor) SaveAllFiles(files []*SecureFile) []error {
-	return bp.ProcessFiles(files, func(sf *SecureFile) error {
-		return sf.SaveEncrypted()
+	if bp.dryRun {
+		errs, _ := bp.run(len(files), nil, func(index int) error {
+			sf := files[index]
+			if _, err := sf.checkSave(); err != nil {
+				return fmt.Errorf("failed to process file %s: %w", sf.Filename, err)
+			}
+			return nil
+		})
+		return errs
+	}
+
+	var tx *transaction
+	if bp.policy == AllOrNothing {
+		tx = newTransaction()
+	}
+
+	errs, _ := bp.run(len(files), tx, func(index int) error {
+		sf := files[index]
+		if err := bp.withItem(sf, func(sf *SecureFile) error { return bp.saveSealed(tx, index, sf) }); err != nil {
+			return fmt.Errorf("failed to process file %s: %w", sf.Filename, err)
+		}
+		return nil
 	})
+
+	if tx != nil {
+		errs = bp.finish(tx, errs)
+	}
+	return errs
+}
+
+// saveSealed seals a file to disk, or stages it when a transaction is given.
+// Files too large to be sealed as a whole within the memory budget are sealed as streams.
+func (bp *BatchProcessor) saveSealed(tx *transaction, index int, sf *SecureFile) error {
+	stream := sf.fm.current().sealsStream(sf) || bp.budget.exceeded(bp.budget.sealCost(len(sf.Data), false))
+	return bp.withBudget(bp.budget.sealCost(len(sf.Data), stream), func() error {
+		return bp.stageSealed(tx, index, sf, stream)
+	})
+}
+
+// stageSealed seals a file to disk, or stages it when a transaction is given.
+// With stream set, the file is sealed as a sealed stream.
+func (bp *BatchProcessor) stageSealed(tx *transaction, index int, sf *SecureFile, stream bool) error {
+	if tx == nil {
+		return newSealError("seal", sf.GetFullPath(), sf.saveEncrypted(stream))
+	}
+
+	snapshot := sf.fm.current()
+	_, target, err := sf.access(AccessSave, snapshot)
+	if err == nil {
+		err = sf.screen(snapshot, target)
+	}
+	if err != nil {
+		return newSealError("seal", sf.GetFullPath(), err)
+	}
+	if err := tx.stageWrite(index, target, true, sf.writeSealedAs(snapshot, stream || snapshot.sealsStream(sf))); err != nil {
+		return newSealError("seal", target, err)
+	}
+
+	// Thumbnails are committed and rolled back with their image
+	if !snapshot.wantsThumbnails(sf.Filename) {
+		return nil
+	}
+	return newSealError("thumbnail", target, sf.eachThumbnail(snapshot, sf.Data, func(thumbnail *SecureFile) error {
+		thumbnailPath := filepath.Join(filepath.Dir(target), thumbnail.Filename)
+		if err := tx.stageWrite(index, thumbnailPath, false, thumbnail.writeSealed(snapshot)); err != nil {
+			return fmt.Errorf("failed to save thumbnail: %w", err)
+		}
+		return nil
+	}))
 }
 
 // LoadAllFiles loads multiple files concurrently
 func (bp *BatchProcessor) LoadAllFiles(files []*SecureFile) []error {
-	return bp.ProcessFiles(files, func(sf *SecureFile) error {
-		return sf.LoadDecrypted()
-	})
+	load := func(sf *SecureFile) error {
+		return bp.withItem(sf, func(sf *SecureFile) error {
+			return bp.withBudget(bp.loadCost(sf.fm, sf.Path, sf.Filename), sf.LoadDecrypted)
+		})
+	}
+	if bp.policy != AllOrNothing {
+		return bp.ProcessFiles(files, load)
+	}
+
+	// Loading has no side effects on disk, so rolling back only restores the previous data
+	previous := make([][]byte, len(files))
+	for i, sf := range files {
+		previous[i] = sf.Data
+	}
+
+	errs := bp.ProcessFiles(files, load)
+	for _, err := range errs {
+		if err != nil {
+			for i, sf := range files {
+				sf.Data = previous[i]
+				if errs[i] == nil {
+					errs[i] = ErrBatchRolledBack
+				}
+			}
+			break
+		}
+	}
+	return errs
 }
 
 // DeleteFile deletes a single file
@@ -67,7 +310,188 @@ func DeleteFile(file *SecureFile) error {
 
 // DeleteAllFiles deletes multiple files concurrently
 func (bp *BatchProcessor) DeleteAllFiles(files []*SecureFile) []error {
-	return bp.ProcessFiles(files, func(sf *SecureFile) error {
-		return sf.Delete()
+	if bp.dryRun {
+		return bp.ProcessFiles(files, func(sf *SecureFile) error {
+			return sf.checkDelete()
+		})
+	}
+
+	if bp.policy != AllOrNothing {
+		return bp.ProcessFiles(files, func(sf *SecureFile) error {
+			return bp.withItem(sf, (*SecureFile).Delete)
+		})
+	}
+
+	tx := newTransaction()
+	errs, _ := bp.run(len(files), tx, func(index int) error {
+		sf := files[index]
+		snapshot := sf.fm.current()
+		_, target, err := sf.access(AccessDelete, snapshot)
+		if err == nil {
+			err = tx.stageDelete(index, target)
+		}
+		// Thumbnails go with their image, those that do not exist are left out
+		if err == nil && snapshot.wantsThumbnails(sf.Filename) {
+			for _, thumbnailPath := range snapshot.thumbnailPaths(target) {
+				if _, statErr := os.Lstat(thumbnailPath); statErr == nil {
+					if err = tx.stageDelete(index, thumbnailPath); err != nil {
+						break
+					}
+				}
+			}
+		}
+		err = newSealError("delete", sf.GetFullPath(), err)
+		if err != nil {
+			return fmt.Errorf("failed to process file %s: %w", sf.Filename, err)
+		}
+		return nil
+	})
+	return bp.finish(tx, errs)
+}
+
+// EncryptOperations encrypts and saves the data of every file operation
+func (bp *BatchProcessor) EncryptOperations(operations []FileOperation) []FileOperation {
+	fm := bp.itemManager(bp.fm)
+	results := make([]FileOperation, len(operations))
+	copy(results, operations)
+
+	var tx *transaction
+	if bp.policy == AllOrNothing && !bp.dryRun {
+		tx = newTransaction()
+	}
+
+	errs, attempts := bp.run(len(results), tx, func(index int) error {
+		op := &results[index]
+		sf := fm.NewSecureFile(op.Data, op.Path, op.Filename)
+
+		existed, err := sf.checkSave()
+		op.Existed = existed
+		if err == nil && !bp.dryRun {
+			err = bp.saveSealed(tx, index, sf)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encrypt and save file %s: %w", op.Filename, err)
+		}
+		return nil
+	})
+
+	if tx != nil {
+		errs = bp.finish(tx, errs)
+	}
+	for i := range results {
+		results[i].Error = errs[i]
+		results[i].Attempts = attempts[i]
+	}
+	return results
+}
+
+// DecryptOperations loads and decrypts the file of every file operation
+func (bp *BatchProcessor) DecryptOperations(operations []FileOperation) []FileOperation {
+	fm := bp.itemManager(bp.fm)
+	results := make([]FileOperation, len(operations))
+	copy(results, operations)
+
+	loaded := make([][]byte, len(results))
+	errs, attempts := bp.run(len(results), nil, func(index int) error {
+		op := &results[index]
+		return bp.withBudget(bp.loadCost(fm, op.Path, op.Filename), func() error {
+			sf, err := fm.LoadSecureFileFromDisk(op.Path, op.Filename)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt file %s: %w", op.Filename, err)
+			}
+			loaded[index] = sf.Data
+			return nil
+		})
+	})
+
+	failed := false
+	for _, err := range errs {
+		if err != nil {
+			failed = true
+			break
+		}
+	}
+
+	for i := range results {
+		results[i].Attempts = attempts[i]
+		switch {
+		case errs[i] != nil:
+			results[i].Error = errs[i]
+		case failed && bp.policy == AllOrNothing:
+			results[i].Error = ErrBatchRolledBack
+		default:
+			results[i].Data = loaded[i]
+			results[i].Error = nil
+		}
+	}
+	return results
+}
+
+// CopyFiles copies multiple files to new locations with optional decryption
+func (bp *BatchProcessor) CopyFiles(copyOperations []CopyOperation) []CopyResult {
+	fm := bp.itemManager(bp.fm)
+	var tx *transaction
+	if bp.policy == AllOrNothing && !bp.dryRun {
+		tx = newTransaction()
+	}
+
+	destExisted := make([]bool, len(copyOperations))
+	written := make([]int64, len(copyOperations))
+	errs, attempts := bp.run(len(copyOperations), tx, func(index int) error {
+		operation := copyOperations[index]
+
+		// The source is read into memory whole before it is written
+		cost := bp.loadCost(fm, operation.SourcePath, operation.SourceFilename)
+		return fm.copyError(operation, bp.withBudget(cost, func() error {
+			var err error
+			if tx == nil {
+				destExisted[index], written[index], err = fm.copyFile(operation, bp.dryRun)
+			} else {
+				destExisted[index], written[index], err = bp.stageCopy(fm, tx, index, operation)
+			}
+			return err
+		}))
+	})
+
+	if tx != nil {
+		errs = bp.finish(tx, errs)
+	}
+
+	results := make([]CopyResult, len(copyOperations))
+	for i, operation := range copyOperations {
+		results[i] = CopyResult{
+			SourcePath:     operation.SourcePath,
+			SourceFilename: operation.SourceFilename,
+			DestPath:       operation.DestPath,
+			DestFilename:   operation.DestFilename,
+			Success:        errs[i] == nil,
+			Error:          errs[i],
+			Attempts:       attempts[i],
+			DestExisted:    destExisted[i],
+			Bytes:          written[i],
+		}
+	}
+	return results
+}
+
+// stageCopy prepares a copy inside a transaction without touching the destination
+func (bp *BatchProcessor) stageCopy(fm *FileManager, tx *transaction, index int, operation CopyOperation) (bool, int64, error) {
+	options := operation.Options
+	destFullPath, destExists, err := fm.prepareCopyDestination(operation.DestPath, operation.DestFilename, options, false)
+	if err != nil {
+		return destExists, 0, err
+	}
+
+	data, err := fm.readCopySource(operation.SourcePath, operation.SourceFilename, options)
+	if err != nil {
+		return destExists, 0, err
+	}
+
+	err = tx.stageWrite(index, destFullPath, false, func(w io.Writer) error {
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("failed to write copied file: %w", err)
+		}
+		return nil
 	})
+	return destExists, int64(len(data)), err
 }
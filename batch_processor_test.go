@@ -0,0 +1,183 @@
+package sealfile
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngForTest encodes an image of the given size as PNG
+func pngForTest(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+// exists reports whether a file exists at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func TestAllOrNothingStagesThumbnails(t *testing.T) {
+	fm := newTestFileManager(t, func(config *Config) { config.ThumbnailSizes = []int{16} })
+	bp := NewBatchProcessorWithOptions(fm, BatchOptions{Concurrency: 1, FailurePolicy: AllOrNothing})
+	image := rootPath(fm, "images/photo.png")
+	thumbnail := filepath.Join(filepath.Dir(image), thumbnailName("photo.png", 16))
+
+	// The thumbnail is staged with the image and rolled back with it
+	tx := newTransaction()
+	if err := bp.stageSealed(tx, 0, fm.NewSecureFile(pngForTest(t, 64, 64), "images", "photo.png"), false); err != nil {
+		t.Fatal(err)
+	}
+	if exists(thumbnail) {
+		t.Fatal("thumbnail written before the commit")
+	}
+	tx.rollback()
+	if entries, _ := os.ReadDir(filepath.Dir(image)); len(entries) != 0 {
+		t.Fatalf("rolled back batch left %d files", len(entries))
+	}
+
+	errs := bp.SaveAllFiles([]*SecureFile{fm.NewSecureFile(pngForTest(t, 64, 64), "images", "photo.png")})
+	if errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if !exists(thumbnail) {
+		t.Fatal("committed batch has no thumbnail")
+	}
+	if _, err := fm.NewSecureFile(nil, "images", "photo.png").Thumbnail(16); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deletions keep the thumbnail when rolled back and remove it on commit
+	errs = bp.DeleteAllFiles([]*SecureFile{
+		fm.NewSecureFile(nil, "images", "photo.png"),
+		fm.NewSecureFile(nil, "images", "missing.png"),
+	})
+	if errs[0] == nil || errs[1] == nil {
+		t.Fatalf("got %v", errs)
+	}
+	if !exists(image) || !exists(thumbnail) {
+		t.Fatalf("rolled back deletion removed image %v, thumbnail %v", !exists(image), !exists(thumbnail))
+	}
+
+	if errs = bp.DeleteAllFiles([]*SecureFile{fm.NewSecureFile(nil, "images", "photo.png")}); errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if exists(image) || exists(thumbnail) {
+		t.Fatalf("deletion left image %v, thumbnail %v", exists(image), exists(thumbnail))
+	}
+}
+
+// treeForTest returns the content of every file below root by slash-separated path
+func treeForTest(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if entry.IsDir() {
+			tree[filepath.ToSlash(rel)+"/"] = ""
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		tree[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// kindsForTest returns the error kind of every item, empty for items that succeeded
+func kindsForTest(errs []error) []string {
+	kinds := make([]string, len(errs))
+	for i, err := range errs {
+		if err != nil {
+			kinds[i] = ErrorKind(err)
+		}
+	}
+	return kinds
+}
+
+func TestDryRunWritesNothingAndMatchesRealRun(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	if _, err := fm.SaveDataAsSecureFile([]byte("existing"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rootPath(fm, "files/corrupted.txt"), []byte("not sealed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saves := func() []*SecureFile {
+		return []*SecureFile{
+			fm.NewSecureFile([]byte("new"), "files", "b.txt"),
+			fm.NewSecureFile([]byte("escape"), "../outside", "c.txt"),
+		}
+	}
+	copies := []CopyOperation{
+		{SourcePath: "files", SourceFilename: "a.txt", DestPath: "copies", DestFilename: "a.txt", Options: CopyOptions{CreateDirectories: true}},
+		{SourcePath: "files", SourceFilename: "a.txt", DestPath: "files", DestFilename: "a.txt"},
+		{SourcePath: "files", SourceFilename: "corrupted.txt", DestPath: "copies", DestFilename: "plain.txt", Options: CopyOptions{DecryptBeforeCopy: true, CreateDirectories: true}},
+		{SourcePath: "files", SourceFilename: "missing.txt", DestPath: "copies", DestFilename: "missing.txt", Options: CopyOptions{CreateDirectories: true}},
+	}
+	deletes := func() []*SecureFile {
+		return []*SecureFile{
+			fm.NewSecureFile(nil, "files", "missing.txt"),
+			fm.NewSecureFile(nil, "files", "a.txt"),
+		}
+	}
+	copyErrs := func(results []CopyResult) []error {
+		errs := make([]error, len(results))
+		for i, result := range results {
+			errs[i] = result.Error
+		}
+		return errs
+	}
+
+	dry := NewBatchProcessorWithOptions(fm, BatchOptions{DryRun: true})
+	before := treeForTest(t, fm.current().config.RootDir)
+	dryKinds := [][]string{
+		kindsForTest(dry.SaveAllFiles(saves())),
+		kindsForTest(copyErrs(dry.CopyFiles(copies))),
+		kindsForTest(dry.DeleteAllFiles(deletes())),
+	}
+	dryBytes := dry.CopyFiles(copies[:1])[0].Bytes
+	after := treeForTest(t, fm.current().config.RootDir)
+	for path, content := range after {
+		if before[path] != content || len(after) != len(before) {
+			t.Fatalf("dry run changed the tree from %v to %v", before, after)
+		}
+	}
+
+	bp := NewBatchProcessor(fm, 0)
+	realCopies := bp.CopyFiles(copies)
+	realKinds := [][]string{
+		kindsForTest(bp.SaveAllFiles(saves())),
+		kindsForTest(copyErrs(realCopies)),
+		kindsForTest(bp.DeleteAllFiles(deletes())),
+	}
+	// The real copies ran before the real saves, their sources are unchanged
+	if realCopies[0].Bytes != dryBytes {
+		t.Fatalf("dry run reported %d bytes, the copy wrote %d", dryBytes, realCopies[0].Bytes)
+	}
+	for i := range dryKinds {
+		for j := range dryKinds[i] {
+			if dryKinds[i][j] != realKinds[i][j] {
+				t.Errorf("operation %d, item %d: dry run reported %q, the real run %q", i, j, dryKinds[i][j], realKinds[i][j])
+			}
+		}
+	}
+	if dryKinds[0][1] == "" || dryKinds[1][1] == "" || dryKinds[1][2] == "" || dryKinds[1][3] == "" || dryKinds[2][0] == "" {
+		t.Fatalf("dry run missed failures: %v", dryKinds)
+	}
+}
@@ -0,0 +1,161 @@
+package sealfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// defaultClamdChunkSize is the size of INSTREAM chunks when ClamdScanner.ChunkSize is zero
+const defaultClamdChunkSize = 64 << 10
+
+// ClamdScanner is a Scanner streaming files to a ClamAV daemon with the INSTREAM command.
+// Files larger than the StreamMaxLength of the daemon fail to scan and are not saved.
+type ClamdScanner struct {
+	Network   string        // "tcp" or "unix", "tcp" when empty
+	Address   string        // Such as "127.0.0.1:3310" or "/run/clamav/clamd.ctl"
+	Timeout   time.Duration // Limit for connecting and scanning a file, none when zero
+	ChunkSize int           // Bytes per INSTREAM chunk, 64 KiB when zero
+	Verdict   ScanVerdict   // Verdict for infected files, ScanReject when empty
+}
+
+// Scan streams r to the daemon and maps its reply onto a verdict
+func (c *ClamdScanner) Scan(ctx context.Context, name string, r io.Reader) (ScanResult, error) {
+	conn, closeConn, err := c.dial(ctx)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer closeConn()
+
+	readErr, sendErr := c.stream(conn, r)
+	if readErr != nil {
+		return ScanResult{}, readErr
+	}
+	// The daemon answers and closes the connection when the stream exceeds its limit
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		if sendErr != nil {
+			return ScanResult{}, fmt.Errorf("failed to send file to clamd: %w", sendErr)
+		}
+		return ScanResult{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+
+	reply = strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case reply == "OK":
+		if sendErr != nil {
+			return ScanResult{}, fmt.Errorf("failed to send file to clamd: %w", sendErr)
+		}
+		return ScanResult{Verdict: ScanAllow}, nil
+	case strings.HasSuffix(reply, " FOUND"):
+		verdict := c.Verdict
+		if verdict == "" {
+			verdict = ScanReject
+		}
+		return ScanResult{Verdict: verdict, Threat: strings.TrimSuffix(reply, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd failed to scan %s: %s", name, strings.TrimSuffix(reply, " ERROR"))
+	}
+}
+
+// Ping checks that the daemon is reachable and answers
+func (c *ClamdScanner) Ping(ctx context.Context) error {
+	conn, closeConn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConn()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("failed to ping clamd: %w", err)
+	}
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return fmt.Errorf("failed to ping clamd: %w", err)
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+	return nil
+}
+
+// dial connects to the daemon. The connection is closed by closeConn or once ctx is done,
+// so that cancelling a save stops its scan.
+func (c *ClamdScanner) dial(ctx context.Context) (_ net.Conn, closeConn func(), _ error) {
+	dialCtx := ctx
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		dialCtx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(dialCtx, network, c.Address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	if c.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(c.Timeout))
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	return conn, func() {
+		stop()
+		_ = conn.Close()
+	}, nil
+}
+
+// stream sends r as an INSTREAM command, chunks prefixed with their length and ended by an
+// empty one. It tells errors reading r apart from errors sending to the daemon.
+func (c *ClamdScanner) stream(conn net.Conn, r io.Reader) (readErr, sendErr error) {
+	chunkSize := c.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultClamdChunkSize
+	}
+
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return nil, err
+	}
+	chunk := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			if err := binary.Write(w, binary.BigEndian, uint32(n)); err != nil {
+				return nil, err
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return err, nil
+		}
+	}
+	if err := binary.Write(w, binary.BigEndian, uint32(0)); err != nil {
+		return nil, err
+	}
+	return nil, w.Flush()
+}
+
+// readClamdReply reads a reply terminated by a NUL byte or the end of the connection
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(errors.Is(err, io.EOF) && len(reply) > 0) {
+		return "", err
+	}
+	return string(bytes.TrimSpace(bytes.TrimRight(reply, "\x00"))), nil
+}
@@ -0,0 +1,166 @@
+package sealfile
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// eicar is the content the fake daemon reports as infected
+const eicar = "X5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"
+
+// fakeClamd answers zPING and zINSTREAM like a ClamAV daemon
+type fakeClamd struct {
+	maxStream  int  // StreamMaxLength, unlimited when zero
+	closeEarly bool // Close the connection after the command without answering
+	hang       bool // Read the stream but never answer
+}
+
+// start serves the fake daemon on a local port and returns a scanner connected to it
+func (f *fakeClamd) start(t *testing.T) *ClamdScanner {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return &ClamdScanner{Address: listener.Addr().String(), ChunkSize: 16}
+}
+
+// serve answers one command
+func (f *fakeClamd) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil || f.closeEarly {
+		return
+	}
+
+	switch command {
+	case "zPING\x00":
+		_, _ = conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var stream bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&stream, r, int64(size)); err != nil {
+				return
+			}
+			if f.maxStream > 0 && stream.Len() > f.maxStream {
+				_, _ = conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+				// Draining keeps the reply from being lost to a reset of the connection
+				_, _ = io.Copy(io.Discard, r)
+				return
+			}
+		}
+		switch {
+		case f.hang:
+			_, _ = io.Copy(io.Discard, r)
+		case strings.Contains(stream.String(), eicar):
+			_, _ = conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		default:
+			_, _ = conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		_, _ = conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamdScanner(t *testing.T) {
+	scanner := (&fakeClamd{}).start(t)
+	ctx := context.Background()
+
+	if err := scanner.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := scanner.Scan(ctx, "clean.txt", strings.NewReader(strings.Repeat("clean ", 100)))
+	if err != nil || result.Verdict != ScanAllow {
+		t.Fatalf("clean file: %+v, %v", result, err)
+	}
+
+	result, err = scanner.Scan(ctx, "eicar.txt", strings.NewReader("prefix "+eicar))
+	if err != nil || result.Verdict != ScanReject || result.Threat != "Eicar-Test-Signature" {
+		t.Fatalf("infected file: %+v, %v", result, err)
+	}
+
+	scanner.Verdict = ScanQuarantine
+	if result, _ = scanner.Scan(ctx, "eicar.txt", strings.NewReader(eicar)); result.Verdict != ScanQuarantine {
+		t.Fatalf("infected file with Verdict quarantine: %+v", result)
+	}
+}
+
+func TestClamdScannerSizeLimit(t *testing.T) {
+	scanner := (&fakeClamd{maxStream: 64}).start(t)
+
+	_, err := scanner.Scan(context.Background(), "large.bin", bytes.NewReader(make([]byte, 4096)))
+	if err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("got %v, want the size limit error of the daemon", err)
+	}
+}
+
+func TestClamdScannerEarlyClose(t *testing.T) {
+	scanner := (&fakeClamd{closeEarly: true}).start(t)
+
+	if _, err := scanner.Scan(context.Background(), "file.txt", strings.NewReader("data")); err == nil {
+		t.Fatal("scan without a reply succeeded")
+	}
+	if err := scanner.Ping(context.Background()); err == nil {
+		t.Fatal("ping without a reply succeeded")
+	}
+}
+
+func TestClamdScannerCancel(t *testing.T) {
+	scanner := (&fakeClamd{hang: true}).start(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := scanner.Scan(ctx, "file.txt", strings.NewReader("data"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("cancelled scan succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("scan did not stop when its context was cancelled")
+	}
+}
+
+func TestClamdScannerReadError(t *testing.T) {
+	scanner := (&fakeClamd{}).start(t)
+	failure := errors.New("source failed")
+
+	_, err := scanner.Scan(context.Background(), "file.txt", io.MultiReader(strings.NewReader("data"), iotest.ErrReader(failure)))
+	if !errors.Is(err, failure) {
+		t.Fatalf("got %v, want the error of the source", err)
+	}
+}
@@ -0,0 +1,302 @@
+// Package client implements sealfile.Store against the HTTP API served by
+// sealfile.FileManager.APIHandler, so that services can use a remote store
+// the same way as a local FileManager.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/crdzbird/sealfile"
+	"github.com/crdzbird/sealfile/internal/api"
+)
+
+// Options configures a Client
+type Options struct {
+	HTTPClient *http.Client // http.DefaultClient when nil
+	Token      string       // Sent as bearer token, see sealfile.BearerAuth
+}
+
+// Client is a sealfile.Store backed by a sealfile API server
+type Client struct {
+	base       *url.URL
+	httpClient *http.Client
+	token      string
+}
+
+var _ sealfile.Store = (*Client)(nil)
+
+// New creates a client for the API served at baseURL
+func New(baseURL string, options Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("invalid API URL %q", baseURL)
+	}
+	httpClient := options.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{base: base, httpClient: httpClient, token: options.Token}, nil
+}
+
+// SaveStream seals everything read from r into a file on the server
+func (c *Client) SaveStream(r io.Reader, path, filename string, metadata map[string]string) error {
+	header := http.Header{}
+	if len(metadata) > 0 {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			return fmt.Errorf("failed to encode metadata: %w", err)
+		}
+		header.Set(api.MetadataHeader, string(encoded))
+	}
+	resp, err := c.do(http.MethodPut, api.PathFile, fileQuery(path, filename), r, header)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// OpenStream opens a file on the server for reading its decrypted content and returns its metadata
+func (c *Client) OpenStream(path, filename string) (io.ReadCloser, map[string]string, error) {
+	resp, err := c.do(http.MethodGet, api.PathFile, fileQuery(path, filename), nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var metadata map[string]string
+	if header := resp.Header.Get(api.MetadataHeader); header != "" {
+		if err := json.Unmarshal([]byte(header), &metadata); err != nil {
+			_ = resp.Body.Close()
+			return nil, nil, fmt.Errorf("invalid metadata in response: %w", err)
+		}
+	}
+	return resp.Body, metadata, nil
+}
+
+// ListFiles lists the sealed files of a directory on the server
+func (c *Client) ListFiles(path string) ([]sealfile.FileEntry, error) {
+	var listed []api.Entry
+	if err := c.call(http.MethodGet, api.PathFiles, url.Values{"path": {path}}, nil, &listed); err != nil {
+		return nil, err
+	}
+
+	entries := make([]sealfile.FileEntry, len(listed))
+	for i, entry := range listed {
+		entries[i] = sealfile.FileEntry{
+			Name:     entry.Name,
+			Size:     entry.Size,
+			ModTime:  entry.ModTime,
+			Format:   sealfile.FileFormat(entry.Format),
+			KeyCheck: entry.KeyCheck,
+		}
+	}
+	return entries, nil
+}
+
+// DeleteFile deletes a file on the server
+func (c *Client) DeleteFile(path, filename string) error {
+	return c.call(http.MethodDelete, api.PathFile, fileQuery(path, filename), nil, nil)
+}
+
+// CopyFileToNewLocation copies a file on the server
+func (c *Client) CopyFileToNewLocation(sourcePath, sourceFilename, destPath, destFilename string, options sealfile.CopyOptions) error {
+	op := toAPICopy(sealfile.CopyOperation{
+		SourcePath:     sourcePath,
+		SourceFilename: sourceFilename,
+		DestPath:       destPath,
+		DestFilename:   destFilename,
+		Options:        options,
+	})
+	return c.call(http.MethodPost, api.PathCopy, nil, op, nil)
+}
+
+// MoveFile moves a file on the server
+func (c *Client) MoveFile(sourcePath, sourceFilename, destPath, destFilename string, options sealfile.CopyOptions) error {
+	op := toAPICopy(sealfile.CopyOperation{
+		SourcePath:     sourcePath,
+		SourceFilename: sourceFilename,
+		DestPath:       destPath,
+		DestFilename:   destFilename,
+		Options:        options,
+	})
+	return c.call(http.MethodPost, api.PathMove, nil, op, nil)
+}
+
+// CreateMultipleEncryptedFiles seals the data of every operation on the server
+func (c *Client) CreateMultipleEncryptedFiles(operations []sealfile.FileOperation, maxConcurrency int) []sealfile.FileOperation {
+	return c.batch(api.PathBatchSeal, operations, maxConcurrency, true)
+}
+
+// DecryptMultipleFiles decrypts the file of every operation on the server
+func (c *Client) DecryptMultipleFiles(operations []sealfile.FileOperation, maxConcurrency int) []sealfile.FileOperation {
+	return c.batch(api.PathBatchUnseal, operations, maxConcurrency, false)
+}
+
+// DeleteMultipleFiles deletes the file of every operation on the server
+func (c *Client) DeleteMultipleFiles(operations []sealfile.FileOperation, maxConcurrency int) []sealfile.FileOperation {
+	return c.batch(api.PathBatchDelete, operations, maxConcurrency, false)
+}
+
+// BatchCopyFiles copies multiple files on the server
+func (c *Client) BatchCopyFiles(copyOperations []sealfile.CopyOperation, maxConcurrency int) []sealfile.CopyResult {
+	request := api.CopyBatch{Concurrency: maxConcurrency, Operations: make([]api.Copy, len(copyOperations))}
+	for i, op := range copyOperations {
+		request.Operations[i] = toAPICopy(op)
+	}
+
+	results := make([]sealfile.CopyResult, len(copyOperations))
+	for i, op := range copyOperations {
+		results[i] = sealfile.CopyResult{
+			SourcePath:     op.SourcePath,
+			SourceFilename: op.SourceFilename,
+			DestPath:       op.DestPath,
+			DestFilename:   op.DestFilename,
+		}
+	}
+
+	var response api.CopyBatchResult
+	err := c.call(http.MethodPost, api.PathBatchCopy, nil, request, &response)
+	if err == nil && len(response.Results) != len(results) {
+		err = fmt.Errorf("server returned %d results for %d operations", len(response.Results), len(results))
+	}
+	for i := range results {
+		if err != nil {
+			// The request failed as a whole, so did every item
+			results[i].Error = err
+			continue
+		}
+		result := response.Results[i]
+		results[i].Success = result.Success
+		results[i].Error = fromAPIError(result.Error)
+		results[i].Attempts = result.Attempts
+		results[i].DestExisted = result.DestExisted
+		results[i].Bytes = result.Bytes
+	}
+	return results
+}
+
+// batch runs a seal, unseal or delete batch on the server
+func (c *Client) batch(path string, operations []sealfile.FileOperation, maxConcurrency int, sendData bool) []sealfile.FileOperation {
+	request := api.Batch{Concurrency: maxConcurrency, Operations: make([]api.Operation, len(operations))}
+	for i, op := range operations {
+		request.Operations[i] = api.Operation{Path: op.Path, Filename: op.Filename}
+		if sendData {
+			request.Operations[i].Data = op.Data
+		}
+	}
+
+	results := make([]sealfile.FileOperation, len(operations))
+	copy(results, operations)
+
+	var response api.Batch
+	err := c.call(http.MethodPost, path, nil, request, &response)
+	if err == nil && len(response.Operations) != len(results) {
+		err = fmt.Errorf("server returned %d results for %d operations", len(response.Operations), len(results))
+	}
+	for i := range results {
+		if err != nil {
+			results[i].Error = err
+			continue
+		}
+		result := response.Operations[i]
+		results[i].Error = fromAPIError(result.Error)
+		results[i].Attempts = result.Attempts
+		results[i].Existed = result.Existed
+		if path == api.PathBatchUnseal && result.Error == nil {
+			results[i].Data = result.Data
+		}
+	}
+	return results
+}
+
+// call sends a request with an optional JSON body and decodes an optional JSON response
+func (c *Client) call(method, path string, query url.Values, request, response any) error {
+	var body io.Reader
+	header := http.Header{}
+	if request != nil {
+		encoded, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(encoded)
+		header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.do(method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if response == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// do sends a request and turns error responses into *sealfile.APIError
+func (c *Client) do(method, path string, query url.Values, body io.Reader, header http.Header) (*http.Response, error) {
+	u := *c.base
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach sealfile API: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	apiErr := &sealfile.APIError{Status: resp.StatusCode, Kind: "other", Message: resp.Status}
+	var reported api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&reported); err == nil && reported.Message != "" {
+		apiErr.Kind = reported.Kind
+		apiErr.Message = reported.Message
+	}
+	return nil, apiErr
+}
+
+// fileQuery returns the query naming a file
+func fileQuery(path, filename string) url.Values {
+	return url.Values{"path": {path}, "filename": {filename}}
+}
+
+// fromAPIError converts the error of a batch item, nil stays nil
+func fromAPIError(err *api.Error) error {
+	if err == nil {
+		return nil
+	}
+	return &sealfile.APIError{Kind: err.Kind, Message: err.Message}
+}
+
+// toAPICopy converts a copy operation for the API
+func toAPICopy(op sealfile.CopyOperation) api.Copy {
+	return api.Copy{
+		SourcePath:     op.SourcePath,
+		SourceFilename: op.SourceFilename,
+		DestPath:       op.DestPath,
+		DestFilename:   op.DestFilename,
+		Options:        api.CopyOptions(op.Options),
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/crdzbird/sealfile"
+)
+
+// runSeal seals plain files, streaming them from disk or standard input
+func runSeal(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("seal", &options)
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	sources, targets, err := destinations(args)
+	if err != nil {
+		return err
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	files := make([]*sealfile.SecureFile, len(targets))
+	sourceOf := make(map[*sealfile.SecureFile]string, len(targets))
+	for i, target := range targets {
+		files[i] = fm.NewSecureFile(nil, filepath.Dir(target), filepath.Base(target))
+		sourceOf[files[i]] = sources[i]
+	}
+
+	errs := options.batchProcessor(fm).ProcessFiles(files, func(sf *sealfile.SecureFile) error {
+		source := sourceOf[sf]
+		if source == "-" {
+			return sf.SaveEncryptedFrom(os.Stdin)
+		}
+
+		file, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+		return sf.SaveEncryptedFrom(file)
+	})
+
+	p := newPrinter(&options, false)
+	for i := range files {
+		p.report(sources[i], targets[i], 0, errs[i])
+	}
+	return p.done()
+}
+
+// runUnseal decrypts one sealed file into a plain file or standard output
+func runUnseal(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("unseal", &options)
+	force := flags.Bool("force", false, "overwrite an existing destination")
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		flags.Usage()
+		return errUsage
+	}
+	source, dest := args[0], args[1]
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(&options, dest == "-")
+	written, err := unsealTo(fm, source, dest, *force)
+	p.report(source, dest, written, err)
+	return p.done()
+}
+
+// unsealTo decrypts source into dest, which is created with owner-only permissions
+func unsealTo(fm *sealfile.FileManager, source, dest string, force bool) (int64, error) {
+	reader, err := fm.NewSecureFile(nil, filepath.Dir(source), filepath.Base(source)).OpenDecrypted()
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = reader.Close() }()
+
+	if dest == "-" {
+		return io.Copy(os.Stdout, reader)
+	}
+
+	mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	file, err := os.OpenFile(dest, mode, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Never leave partial plaintext behind
+		_ = os.Remove(dest)
+		return 0, err
+	}
+	return written, nil
+}
+
+// runCat writes the decrypted content of sealed files to standard output
+func runCat(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("cat", &options)
+	args, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(&options, true)
+	for _, file := range args {
+		written, err := unsealTo(fm, file, "-", false)
+		p.report(file, "", written, err)
+	}
+	return p.done()
+}
+
+// listEntry is the JSON form of a listed file
+type listEntry struct {
+	Dir      string    `json:"dir"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Format   string    `json:"format"`
+	KeyCheck string    `json:"key_check,omitempty"`
+	KeyMatch bool      `json:"key_match"`
+}
+
+// runList lists directories and tells how their files are sealed
+func runList(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("ls", &options)
+	args, err := parseArgs(flags, args, 0)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+	encryptor, err := sealfile.NewEncryptor(fm.GetConfig().EncryptionKey)
+	if err != nil {
+		return err
+	}
+	keyCheck := encryptor.KeyCheck()
+
+	p := newPrinter(&options, false)
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, dir := range args {
+		entries, err := fm.ListFiles(dir)
+		if err != nil {
+			p.report(dir, "", 0, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			item := listEntry{
+				Dir:      dir,
+				Name:     entry.Name,
+				Size:     entry.Size,
+				ModTime:  entry.ModTime,
+				Format:   string(entry.Format),
+				KeyCheck: entry.KeyCheck,
+				KeyMatch: entry.KeyCheck != "" && entry.KeyCheck == keyCheck,
+			}
+			if options.json {
+				p.encode(item)
+				continue
+			}
+
+			key := "-"
+			switch {
+			case item.KeyMatch:
+				key = "current"
+			case item.KeyCheck != "":
+				key = "other"
+			}
+			fmt.Fprintf(table, "%s\t%s\t%d\t%s\t%s\n", item.Format, key, item.Size,
+				item.ModTime.Format(time.RFC3339), filepath.Join(dir, item.Name))
+		}
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	return p.done()
+}
+
+// runCopy copies sealed files with the batch processor
+func runCopy(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("cp", &options)
+	decrypt := flags.Bool("decrypt", false, "write decrypted copies")
+	force := flags.Bool("force", false, "overwrite existing destinations")
+	dryRun := flags.Bool("dry-run", false, "only check that the copies would succeed")
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	sources, targets, err := destinations(args)
+	if err != nil {
+		return err
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	operations := make([]sealfile.CopyOperation, len(sources))
+	for i := range sources {
+		operations[i] = sealfile.CopyOperation{
+			SourcePath:     filepath.Dir(sources[i]),
+			SourceFilename: filepath.Base(sources[i]),
+			DestPath:       filepath.Dir(targets[i]),
+			DestFilename:   filepath.Base(targets[i]),
+			Options: sealfile.CopyOptions{
+				DecryptBeforeCopy: *decrypt,
+				OverwriteExisting: *force,
+				CreateDirectories: true,
+			},
+		}
+	}
+
+	bp := sealfile.NewBatchProcessorWithOptions(fm, sealfile.BatchOptions{
+		Concurrency: options.concurrency,
+		DryRun:      *dryRun,
+	})
+
+	p := newPrinter(&options, false)
+	for i, copied := range bp.CopyFiles(operations) {
+		p.report(sources[i], targets[i], copied.Bytes, copied.Error)
+	}
+	return p.done()
+}
+
+// runMove moves sealed files
+func runMove(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("mv", &options)
+	force := flags.Bool("force", false, "overwrite existing destinations")
+	args, err := parseArgs(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	sources, targets, err := destinations(args)
+	if err != nil {
+		return err
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	files := secureFiles(fm, sources)
+	targetOf := make(map[*sealfile.SecureFile]string, len(files))
+	for i, sf := range files {
+		targetOf[sf] = targets[i]
+	}
+
+	copyOptions := sealfile.CopyOptions{OverwriteExisting: *force, CreateDirectories: true}
+	errs := options.batchProcessor(fm).ProcessFiles(files, func(sf *sealfile.SecureFile) error {
+		target := targetOf[sf]
+		return fm.MoveFile(sf.Path, sf.Filename, filepath.Dir(target), filepath.Base(target), copyOptions)
+	})
+
+	p := newPrinter(&options, false)
+	for i := range files {
+		p.report(sources[i], targets[i], 0, errs[i])
+	}
+	return p.done()
+}
+
+// runRemove deletes sealed files with the batch processor
+func runRemove(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("rm", &options)
+	dryRun := flags.Bool("dry-run", false, "only check that the files could be deleted")
+	args, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	bp := sealfile.NewBatchProcessorWithOptions(fm, sealfile.BatchOptions{
+		Concurrency: options.concurrency,
+		DryRun:      *dryRun,
+	})
+	errs := bp.DeleteAllFiles(secureFiles(fm, args))
+
+	p := newPrinter(&options, false)
+	for i, file := range args {
+		p.report(file, "", 0, errs[i])
+	}
+	return p.done()
+}
+
+// runVerify authenticates every chunk of sealed files, or checks the key against the store marker
+func runVerify(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("verify", &options)
+	args, err := parseArgs(flags, args, 0)
+	if err != nil {
+		return err
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(&options, false)
+	if len(args) == 0 {
+		p.report(fm.GetConfig().PublicDir, "", 0, fm.VerifyKey())
+		return p.done()
+	}
+
+	files := secureFiles(fm, args)
+	indexOf := make(map[*sealfile.SecureFile]int, len(files))
+	for i, sf := range files {
+		indexOf[sf] = i
+	}
+
+	sizes := make([]int64, len(files))
+	errs := options.batchProcessor(fm).ProcessFiles(files, func(sf *sealfile.SecureFile) error {
+		reader, err := sf.OpenDecrypted()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = reader.Close() }()
+
+		sizes[indexOf[sf]], err = io.Copy(io.Discard, reader)
+		return err
+	})
+
+	for i, file := range args {
+		p.report(file, "", sizes[i], errs[i])
+	}
+	return p.done()
+}
+
+// runRekey re-seals files with the current key
+func runRekey(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("rekey", &options)
+	args, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	errs := options.batchProcessor(fm).ProcessFiles(secureFiles(fm, args), func(sf *sealfile.SecureFile) error {
+		return fm.RekeyFile(sf.Path, sf.Filename)
+	})
+
+	p := newPrinter(&options, false)
+	for i, file := range args {
+		p.report(file, "", 0, errs[i])
+	}
+	return p.done()
+}
+
+// secureFiles returns a SecureFile for every path
+func secureFiles(fm *sealfile.FileManager, paths []string) []*sealfile.SecureFile {
+	files := make([]*sealfile.SecureFile, len(paths))
+	for i, path := range paths {
+		files[i] = fm.NewSecureFile(nil, filepath.Dir(path), filepath.Base(path))
+	}
+	return files
+}
+
+// destinations splits SOURCE... DEST arguments and names the target of every source.
+// DEST is a directory when there are several sources, when it exists as one or ends with a separator.
+func destinations(args []string) ([]string, []string, error) {
+	sources, dest := args[:len(args)-1], args[len(args)-1]
+
+	intoDir := len(sources) > 1 || strings.HasSuffix(dest, string(filepath.Separator)) || strings.HasSuffix(dest, "/")
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		intoDir = true
+	}
+
+	targets := make([]string, len(sources))
+	for i, source := range sources {
+		if !intoDir {
+			targets[i] = dest
+			continue
+		}
+		if source == "-" {
+			return nil, nil, fmt.Errorf("standard input needs a destination file name")
+		}
+		targets[i] = filepath.Join(dest, filepath.Base(source))
+	}
+	return sources, targets, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// storeForTest writes a configuration confining the store to a temporary directory and
+// key files for key and otherKey. It returns the directory holding them.
+func storeForTest(t *testing.T, key, otherKey string) string {
+	t.Helper()
+	for _, name := range []string{"ENCRYPTION_KEY", "KEY_FILE", "KEY_ENV", "PREVIOUS_KEY_FILES", "PREVIOUS_KEY_ENVS", "ROOT_DIR"} {
+		// Restored once the test is done
+		t.Setenv("SEALFILE_"+name, "")
+		_ = os.Unsetenv("SEALFILE_" + name)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"root_dir": "store", "public_dir": ".", "key_file": "key"}`,
+		"key":         key,
+		"other-key":   otherKey,
+		"plain.txt":   "plain content",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "store"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// runForTest runs a command in-process and returns what it printed to standard output
+func runForTest(t *testing.T, name string, args ...string) (string, error) {
+	t.Helper()
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = write
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(read)
+		output <- string(data)
+	}()
+
+	runErr := commands[name].run(args)
+	_ = write.Close()
+	return <-output, runErr
+}
+
+// listForTest lists a store directory as JSON entries
+func listForTest(t *testing.T, dir string, args ...string) []listEntry {
+	t.Helper()
+	out, err := runForTest(t, "ls", append(append([]string{"--json"}, args...), dir)...)
+	if err != nil {
+		t.Fatalf("ls: %v", err)
+	}
+	var entries []listEntry
+	decoder := json.NewDecoder(strings.NewReader(out))
+	for decoder.More() {
+		var entry listEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestSealOpenListRoundTrip(t *testing.T) {
+	dir := storeForTest(t, "first key", "second key")
+	config := []string{"--config", filepath.Join(dir, "config.json")}
+
+	if _, err := runForTest(t, "seal", append(config, filepath.Join(dir, "plain.txt"), "files/a.txt")...); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if sealed, _ := os.ReadFile(filepath.Join(dir, "store", "files", "a.txt")); strings.Contains(string(sealed), "plain content") {
+		t.Fatal("sealed file holds the plaintext")
+	}
+
+	out, err := runForTest(t, "cat", append(config, "files/a.txt")...)
+	if err != nil || out != "plain content" {
+		t.Fatalf("cat printed %q, %v", out, err)
+	}
+
+	unsealed := filepath.Join(dir, "unsealed.txt")
+	if _, err := runForTest(t, "unseal", append(config, "files/a.txt", unsealed)...); err != nil {
+		t.Fatalf("unseal: %v", err)
+	}
+	if data, _ := os.ReadFile(unsealed); string(data) != "plain content" {
+		t.Fatalf("unsealed %q", data)
+	}
+
+	entries := listForTest(t, "files", config...)
+	if len(entries) != 1 || entries[0].Name != "a.txt" || entries[0].Format != "stream" || !entries[0].KeyMatch {
+		t.Fatalf("listed %+v", entries)
+	}
+
+	// Moving onto an existing file needs --force
+	if _, err := runForTest(t, "seal", append(config, filepath.Join(dir, "plain.txt"), "files/b.txt")...); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	out, err = runForTest(t, "mv", append(config, "--json", "files/a.txt", "files/b.txt")...)
+	if !errors.Is(err, errFailed) || !strings.Contains(out, `"kind":"already_exists"`) {
+		t.Fatalf("mv printed %q, %v", out, err)
+	}
+	if _, err := runForTest(t, "mv", append(config, "--force", "files/a.txt", "files/b.txt")...); err != nil {
+		t.Fatalf("mv: %v", err)
+	}
+	if entries := listForTest(t, "files", config...); len(entries) != 1 || entries[0].Name != "b.txt" {
+		t.Fatalf("listed %+v after the move", entries)
+	}
+}
+
+func TestRekeyRotatesKey(t *testing.T) {
+	dir := storeForTest(t, "first key", "second key")
+	config := []string{"--config", filepath.Join(dir, "config.json")}
+	rotated := append(config, "--key-file", filepath.Join(dir, "other-key"), "--previous-key-file", filepath.Join(dir, "key"))
+	newKey := append(config, "--key-file", filepath.Join(dir, "other-key"))
+	oldKey := append(config, "--key-file", filepath.Join(dir, "key"))
+
+	if _, err := runForTest(t, "seal", append(config, filepath.Join(dir, "plain.txt"), "files/a.txt")...); err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if _, err := runForTest(t, "cat", append(newKey, "files/a.txt")...); !errors.Is(err, errFailed) {
+		t.Fatalf("cat with the new key before rekeying: %v", err)
+	}
+
+	if _, err := runForTest(t, "rekey", append(rotated, "files/a.txt")...); err != nil {
+		t.Fatalf("rekey: %v", err)
+	}
+	if entries := listForTest(t, "files", newKey...); len(entries) != 1 || !entries[0].KeyMatch {
+		t.Fatalf("listed %+v with the new key", entries)
+	}
+	out, err := runForTest(t, "cat", append(newKey, "files/a.txt")...)
+	if err != nil || out != "plain content" {
+		t.Fatalf("cat printed %q, %v", out, err)
+	}
+	if _, err := runForTest(t, "cat", append(oldKey, "files/a.txt")...); !errors.Is(err, errFailed) {
+		t.Fatalf("cat with the old key after rekeying: %v", err)
+	}
+
+	// Key flags of earlier commands do not carry over, the configured key is the old one
+	if _, err := runForTest(t, "cat", append(config, "files/a.txt")...); !errors.Is(err, errFailed) {
+		t.Fatalf("cat with the configured key after rekeying: %v", err)
+	}
+}
+
+func TestKeysStayOutOfEnvironment(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test editor is a shell script")
+	}
+	dir := storeForTest(t, "first key", "second key")
+	t.Setenv("TEST_KEY", "second key")
+	t.Setenv("SEALFILE_BASE_URL", "https://example.com")
+	config := []string{"--config", filepath.Join(dir, "config.json")}
+
+	// The editor records the environment it sees and replaces the content
+	editor := filepath.Join(dir, "editor.sh")
+	script := "#!/bin/sh\nenv > " + filepath.Join(dir, "editor-env") + "\nprintf edited > \"$1\"\n"
+	if err := os.WriteFile(editor, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", editor)
+
+	before := os.Environ()
+	if _, err := runForTest(t, "edit", append(config, "--key-env", "TEST_KEY", "files/a.txt")...); err != nil {
+		t.Fatalf("edit: %v", err)
+	}
+	if after := os.Environ(); !slices.Equal(before, after) {
+		t.Fatalf("environment changed from %v to %v", before, after)
+	}
+
+	env, err := os.ReadFile(filepath.Join(dir, "editor-env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, variable := range strings.Split(string(env), "\n") {
+		if strings.HasPrefix(variable, "SEALFILE_") || strings.HasPrefix(variable, "TEST_KEY=") {
+			t.Errorf("editor saw %s", variable)
+		}
+	}
+
+	out, err := runForTest(t, "cat", append(config, "--key-env", "TEST_KEY", "files/a.txt")...)
+	if err != nil || out != "edited" {
+		t.Fatalf("cat printed %q, %v", out, err)
+	}
+}
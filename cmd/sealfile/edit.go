@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/crdzbird/sealfile"
+)
+
+// errConflict reports that a sealed file changed while it was being edited
+var errConflict = errors.New("sealed file changed while editing")
+
+// runEdit decrypts a sealed file into a private temporary file, opens it in the
+// editor and re-seals it when the content changed
+func runEdit(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("edit", &options)
+	args, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	fm, err := options.fileManager()
+	if err != nil {
+		return err
+	}
+
+	p := newPrinter(&options, false)
+	status, err := editFile(fm, args[0], options.keyEnvs())
+	p.reportStatus(args[0], status, err)
+	return p.done()
+}
+
+// editFile runs an edit session and reports whether the file was "created", "saved" or "unchanged".
+// A sealed file that changed meanwhile is left alone and the edits are sealed next to it.
+// The editor does not see the SEALFILE_ variables nor the variables named by keyEnvs.
+func editFile(fm *sealfile.FileManager, path string, keyEnvs []string) (string, error) {
+	sf := fm.NewSecureFile(nil, filepath.Dir(path), filepath.Base(path))
+
+	// The revision identifies the sealed bytes that were decrypted, empty for a new file
+	revision, err := sf.LoadDecryptedRevision()
+	created := errors.Is(err, sealfile.ErrNotFound)
+	if err != nil && !created {
+		return "", err
+	}
+
+	session, err := newEditSession(fm, sf.Filename)
+	if err != nil {
+		return "", err
+	}
+	session.keyEnvs = keyEnvs
+	defer session.cleanup()
+	stop := session.handleSignals()
+	defer stop()
+
+	if err := os.WriteFile(session.path, sf.Data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := session.runEditor(); err != nil {
+		return "", err
+	}
+
+	edited, err := os.ReadFile(session.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read temporary file: %w", err)
+	}
+	if !created && bytes.Equal(edited, sf.Data) {
+		return "unchanged", nil
+	}
+
+	// Never overwrite changes made by someone else while the editor was open
+	err = sf.SaveEncryptedFromIfRevision(bytes.NewReader(edited), revision)
+	if errors.Is(err, sealfile.ErrRevisionMismatch) {
+		conflict := fm.NewSecureFile(nil, sf.Path, fmt.Sprintf("%s.conflict-%d", sf.Filename, time.Now().Unix()))
+		conflict.Metadata = sf.Metadata
+		if err := conflict.SaveEncryptedFromIfRevision(bytes.NewReader(edited), ""); err != nil {
+			return "", fmt.Errorf("%w and saving the edits failed: %v", errConflict, err)
+		}
+		return "", fmt.Errorf("%w, edits saved to %s", errConflict, conflict.GetFullPath())
+	}
+	if err != nil {
+		return "", err
+	}
+	if created {
+		return "created", nil
+	}
+	return "saved", nil
+}
+
+// editSession owns the private directory holding the plaintext while it is edited
+type editSession struct {
+	dir     string
+	path    string
+	once    sync.Once
+	editor  atomic.Pointer[os.Process]
+	editing atomic.Bool
+	keyEnvs []string // Variables holding keys, hidden from the editor
+}
+
+// newEditSession creates a directory only the current user can access under the temp
+// directory of the store, resolved inside its root directory like every other path
+func newEditSession(fm *sealfile.FileManager, filename string) (*editSession, error) {
+	tempDir, err := fm.TempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp(tempDir, "sealfile-edit-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	// Keep the name, editors pick the syntax from the extension
+	return &editSession{dir: dir, path: filepath.Join(dir, filename)}, nil
+}
+
+// cleanup removes the plaintext, it is safe to call more than once
+func (s *editSession) cleanup() {
+	s.once.Do(func() {
+		_ = os.RemoveAll(s.dir)
+	})
+}
+
+// handleSignals removes the plaintext before the process dies of a signal.
+// Interrupts are left to the editor while it runs, as terminals send them to both.
+func (s *editSession) handleSignals() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				if sig == os.Interrupt && s.editing.Load() {
+					continue
+				}
+				if editor := s.editor.Load(); editor != nil {
+					_ = editor.Kill()
+				}
+				s.cleanup()
+				fmt.Fprintf(os.Stderr, "sealfile edit: %v, temporary plaintext removed\n", sig)
+				code := 1
+				if number, ok := sig.(syscall.Signal); ok {
+					code = 128 + int(number)
+				}
+				os.Exit(code)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// runEditor opens the plaintext in $VISUAL or $EDITOR and waits for it to exit
+func (s *editSession) runEditor() error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+		if runtime.GOOS == "windows" {
+			editor = "notepad"
+		}
+	}
+
+	// Editors may be given with arguments, such as "code --wait"
+	fields := strings.Fields(editor)
+	cmd := exec.Command(fields[0], append(fields[1:], s.path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = editorEnv(os.Environ(), s.keyEnvs)
+
+	s.editing.Store(true)
+	defer s.editing.Store(false)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start editor %s: %w", fields[0], err)
+	}
+	s.editor.Store(cmd.Process)
+	defer s.editor.Store(nil)
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("editor %s failed, the sealed file was not changed: %w", fields[0], err)
+	}
+	return nil
+}
+
+// editorEnv returns the environment without the SEALFILE_ variables and the variables
+// named by keyEnvs, which carry keys that the editor and its plugins have no business seeing
+func editorEnv(environ, keyEnvs []string) []string {
+	env := make([]string, 0, len(environ))
+	for _, variable := range environ {
+		name, _, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(name, sealfile.EnvPrefix) && !slices.Contains(keyEnvs, name) {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
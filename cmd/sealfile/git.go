@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/crdzbird/sealfile"
+)
+
+// gitFilterName is the name of the filter and diff driver in .gitattributes
+const gitFilterName = "sealfile"
+
+// runGit runs one of the git filter commands
+func runGit(args []string) error {
+	if len(args) == 0 {
+		newFlagSet("git", &globalOptions{}).Usage()
+		return errUsage
+	}
+
+	switch args[0] {
+	case "clean":
+		return runGitClean(args[1:])
+	case "smudge":
+		return runGitSmudge(args[1:])
+	case "textconv":
+		return runGitTextconv(args[1:])
+	case "init":
+		return runGitInit(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "sealfile git: unknown command %q\n", args[0])
+		newFlagSet("git", &globalOptions{}).Usage()
+		return errUsage
+	}
+}
+
+// runGitClean seals the plaintext git passes on standard input. Sealing is deterministic,
+// so unchanged files produce the same blob. Without a key it fails, so that git never
+// stores plaintext.
+func runGitClean(args []string) error {
+	var options globalOptions
+	if _, err := parseArgs(newFlagSet("git", &options), args, 0); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	// Content that is still sealed, for example after a checkout without the key, stays as it
+	// is, but only when it opens with a configured key. Anything else, including files that
+	// merely start like a sealed stream, is sealed.
+	if !sealfile.IsSealedStream(data) || !opensWithKey(&options, data) {
+		config, err := options.loadConfig()
+		if err != nil {
+			return err
+		}
+		encryptor, err := sealfile.NewEncryptor(config.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		if data, err = encryptor.SealDeterministic(data); err != nil {
+			return err
+		}
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// runGitSmudge decrypts the sealed blob git passes on standard input.
+// Without a matching key the blob is checked out sealed.
+func runGitSmudge(args []string) error {
+	var options globalOptions
+	if _, err := parseArgs(newFlagSet("git", &options), args, 0); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	plain, err := openSealedBlob(&options, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sealfile git smudge: %v, checking out sealed content\n", err)
+		plain = data
+	}
+	_, err = os.Stdout.Write(plain)
+	return err
+}
+
+// runGitTextconv prints the plaintext of a sealed blob for git diff
+func runGitTextconv(args []string) error {
+	var options globalOptions
+	args, err := parseArgs(newFlagSet("git", &options), args, 1)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	plain, err := openSealedBlob(&options, data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sealfile git textconv: %v, showing sealed content\n", err)
+		plain = data
+	}
+	_, err = os.Stdout.Write(plain)
+	return err
+}
+
+// openSealedBlob decrypts data with the current or a previous key; data that is not sealed is returned as it is
+func openSealedBlob(options *globalOptions, data []byte) ([]byte, error) {
+	if !sealfile.IsSealedStream(data) {
+		return data, nil
+	}
+
+	config, err := options.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, key := range append([]string{config.EncryptionKey}, config.PreviousKeys...) {
+		encryptor, err := sealfile.NewEncryptor(key)
+		if err != nil {
+			return nil, err
+		}
+		reader, err := encryptor.NewOpenReader(bytes.NewReader(data))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return io.ReadAll(reader)
+	}
+	return nil, lastErr
+}
+
+// opensWithKey reports whether a sealed blob opens with the current or a previous key
+func opensWithKey(options *globalOptions, data []byte) bool {
+	_, err := openSealedBlob(options, data)
+	return err == nil
+}
+
+// runGitInit marks paths for sealing in .gitattributes and configures the filter in the repository
+func runGitInit(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("git", &options)
+	attributesOnly := flags.Bool("attributes-only", false, "only update .gitattributes, do not run git config")
+	patterns, err := parseArgs(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if options.promptKey {
+		return fmt.Errorf("git filters cannot prompt for the key, use --key-file, --key-env or --config")
+	}
+
+	p := newPrinter(&options, false)
+	status, err := addGitAttributes(".gitattributes", patterns)
+	p.reportStatus(".gitattributes", status, err)
+
+	if !*attributesOnly {
+		flagArgs, err := filterFlags(&options)
+		if err == nil {
+			err = configureGit(flagArgs)
+		}
+		p.reportStatus("git config", "configured", err)
+	}
+	return p.done()
+}
+
+// addGitAttributes appends a filter line for every pattern that does not have one yet
+func addGitAttributes(path string, patterns []string) (string, error) {
+	existing := make(map[string]bool)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		existing[strings.TrimSpace(scanner.Text())] = true
+	}
+
+	var lines []string
+	for _, pattern := range patterns {
+		line := fmt.Sprintf("%s filter=%s diff=%s", pattern, gitFilterName, gitFilterName)
+		if !existing[line] {
+			lines = append(lines, line)
+			existing[line] = true
+		}
+	}
+	if len(lines) == 0 {
+		return "unchanged", nil
+	}
+
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, strings.Join(lines, "\n")+"\n"...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", err
+	}
+	return "updated", nil
+}
+
+// filterFlags returns the flags that tell the filters where to find the key
+func filterFlags(options *globalOptions) (string, error) {
+	var args []string
+	for _, flag := range []struct {
+		name  string
+		value string
+		path  bool
+	}{
+		{"config", options.configPath, true},
+		{"key-file", options.keyFile, true},
+		{"key-env", options.keyEnv, false},
+	} {
+		if flag.value == "" {
+			continue
+		}
+		value := flag.value
+		if flag.path {
+			absolute, err := filepath.Abs(value)
+			if err != nil {
+				return "", err
+			}
+			value = absolute
+		}
+		args = append(args, "--"+flag.name+"="+shellQuote(value))
+	}
+	return strings.Join(args, " "), nil
+}
+
+// configureGit registers the filter and diff driver in the repository configuration
+func configureGit(flagArgs string) error {
+	command := func(name string) string {
+		return strings.TrimSpace("sealfile git " + name + " " + flagArgs)
+	}
+	for _, setting := range [][2]string{
+		{"filter." + gitFilterName + ".clean", command("clean")},
+		{"filter." + gitFilterName + ".smudge", command("smudge")},
+		{"filter." + gitFilterName + ".required", "true"},
+		{"diff." + gitFilterName + ".textconv", command("textconv")},
+	} {
+		output, err := exec.Command("git", "config", setting[0], setting[1]).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("git config %s failed: %v: %s", setting[0], err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes a value for the shell git runs filters with
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
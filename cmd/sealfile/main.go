@@ -0,0 +1,125 @@
+// Command sealfile seals, inspects and maintains files sealed with the sealfile package.
+//
+// Usage:
+//
+//	sealfile <command> [flags] [arguments]
+//
+// Run "sealfile help" for the list of commands.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// command is a subcommand of the tool
+type command struct {
+	usage string
+	short string
+	run   func(args []string) error
+}
+
+// commands lists every subcommand by name
+var commands map[string]command
+
+func init() {
+	// Assigned in init because the commands look up their own usage
+	commands = map[string]command{
+		"seal":   {"seal [flags] SOURCE... DEST", "seal plain files, SOURCE - reads standard input", runSeal},
+		"unseal": {"unseal [flags] SOURCE DEST", "decrypt a sealed file, DEST - writes standard output", runUnseal},
+		"cat":    {"cat [flags] FILE...", "print the decrypted content of sealed files", runCat},
+		"ls":     {"ls [flags] DIR...", "list files and how they are sealed", runList},
+		"edit":   {"edit [flags] FILE", "edit the plaintext of a sealed file with $EDITOR", runEdit},
+		"git":    {"git clean|smudge|textconv|init [flags] [FILE|PATTERN...]", "seal files in a git repository with clean and smudge filters", runGit},
+		"cp":     {"cp [flags] SOURCE... DEST", "copy sealed files, decrypted with --decrypt", runCopy},
+		"mv":     {"mv [flags] SOURCE... DEST", "move sealed files", runMove},
+		"rm":     {"rm [flags] FILE...", "delete sealed files", runRemove},
+		"verify": {"verify [flags] [FILE...]", "authenticate sealed files, or the store key without files", runVerify},
+		"rekey":  {"rekey [flags] FILE...", "re-seal files sealed with a previous key with the current key", runRekey},
+		"serve":  {"serve [flags]", "serve the store over the HTTP API used by package client", runServe},
+	}
+}
+
+// errUsage reports invalid arguments, the usage has already been printed
+var errUsage = errors.New("invalid usage")
+
+// errFailed reports that some files failed, the failures have already been printed
+var errFailed = errors.New("some operations failed")
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		printUsage()
+		return
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "sealfile: unknown command %q\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+
+	err := cmd.run(os.Args[2:])
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case errors.Is(err, errFailed):
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "sealfile %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// printUsage lists the commands
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: sealfile <command> [flags] [arguments]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].short)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun \"sealfile <command> -h\" for the flags of a command.")
+}
+
+// newFlagSet creates the flag set of a command with the flags every command shares
+func newFlagSet(name string, options *globalOptions) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: sealfile %s\n\n%s\n\nFlags:\n", commands[name].usage, commands[name].short)
+		flags.PrintDefaults()
+	}
+	options.register(flags)
+	return flags
+}
+
+// parseArgs parses the flags of a command and checks the number of remaining arguments
+func parseArgs(flags *flag.FlagSet, args []string, min int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, errUsage
+	}
+	if flags.NArg() < min {
+		flags.Usage()
+		return nil, errUsage
+	}
+	return flags.Args(), nil
+}
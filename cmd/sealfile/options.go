@@ -0,0 +1,177 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/crdzbird/sealfile"
+)
+
+// stringList is a flag that may be repeated
+type stringList []string
+
+// String returns the values separated by commas
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set appends a value
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// globalOptions holds the flags shared by every command
+type globalOptions struct {
+	configPath       string
+	keyFile          string
+	keyEnv           string
+	promptKey        bool
+	previousKeyFiles stringList
+	previousKeyEnvs  stringList
+	concurrency      int
+	json             bool
+}
+
+// register adds the shared flags to a flag set
+func (o *globalOptions) register(flags *flag.FlagSet) {
+	flags.StringVar(&o.configPath, "config", "", "JSON configuration `file`, see sealfile.LoadConfig")
+	flags.StringVar(&o.keyFile, "key-file", "", "read the key from `file`")
+	flags.StringVar(&o.keyEnv, "key-env", "", "read the key from the environment `variable`")
+	flags.BoolVar(&o.promptKey, "prompt-key", false, "prompt for the key without echo")
+	flags.Var(&o.previousKeyFiles, "previous-key-file", "read a previous key from `file`, may be repeated")
+	flags.Var(&o.previousKeyEnvs, "previous-key-env", "read a previous key from the environment `variable`, may be repeated")
+	flags.IntVar(&o.concurrency, "concurrency", 4, "number of files processed at once")
+	flags.BoolVar(&o.json, "json", false, "print results as JSON lines")
+}
+
+// fileManager builds the FileManager configured by the config file, the environment and the flags
+func (o *globalOptions) fileManager() (*sealfile.FileManager, error) {
+	config, err := o.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return sealfile.NewFileManager(config)
+}
+
+// loadConfig loads the configuration from the config file, the environment and the flags.
+// Key flags take precedence over everything else. Keys are read in-process and never put
+// into the environment, where editors and other child processes would inherit them.
+func (o *globalOptions) loadConfig() (*sealfile.Config, error) {
+	keySources := 0
+	for _, set := range []bool{o.keyFile != "", o.keyEnv != "", o.promptKey} {
+		if set {
+			keySources++
+		}
+	}
+	if keySources > 1 {
+		return nil, fmt.Errorf("only one of --key-file, --key-env and --prompt-key may be given")
+	}
+
+	// The key is read before the configuration, so that a prompt comes first
+	var key string
+	var err error
+	switch {
+	case o.keyFile != "":
+		key, err = readKeyFile(o.keyFile)
+	case o.keyEnv != "":
+		key, err = readKeyEnv(o.keyEnv)
+	case o.promptKey:
+		key, err = promptKey("Key: ")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var previousKeys []string
+	for _, path := range o.previousKeyFiles {
+		previous, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		previousKeys = append(previousKeys, previous)
+	}
+	for _, name := range o.previousKeyEnvs {
+		previous, err := readKeyEnv(name)
+		if err != nil {
+			return nil, err
+		}
+		previousKeys = append(previousKeys, previous)
+	}
+
+	return sealfile.LoadConfigWith(o.configPath, func(config *sealfile.Config) error {
+		if key != "" {
+			config.EncryptionKey = key
+		}
+		if previousKeys != nil {
+			config.PreviousKeys = previousKeys
+		}
+		return nil
+	})
+}
+
+// keyEnvs returns the environment variables the key flags read keys from
+func (o *globalOptions) keyEnvs() []string {
+	names := append([]string(nil), o.previousKeyEnvs...)
+	if o.keyEnv != "" {
+		names = append(names, o.keyEnv)
+	}
+	return names
+}
+
+// readKeyFile reads a key from a file, without the trailing line break
+func readKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := strings.TrimRight(string(data), "\r\n")
+	if key == "" {
+		return "", fmt.Errorf("key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// readKeyEnv reads a key from the named environment variable
+func readKeyEnv(name string) (string, error) {
+	key, ok := os.LookupEnv(name)
+	if !ok || key == "" {
+		return "", fmt.Errorf("key environment variable %s is not set", name)
+	}
+	return key, nil
+}
+
+// batchProcessor returns a batch processor using the configured concurrency
+func (o *globalOptions) batchProcessor(fm *sealfile.FileManager) *sealfile.BatchProcessor {
+	return sealfile.NewBatchProcessor(fm, o.concurrency)
+}
+
+// readLine reads one line byte by byte, so that nothing after it is consumed
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read key: %w", err)
+		}
+	}
+
+	key := strings.TrimRight(string(line), "\r")
+	if key == "" {
+		return "", fmt.Errorf("no key entered")
+	}
+	return key, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/crdzbird/sealfile"
+)
+
+// result is the outcome of a command on one file
+type result struct {
+	File   string `json:"file"`
+	Dest   string `json:"dest,omitempty"`
+	OK     bool   `json:"ok"`
+	Status string `json:"status,omitempty"`
+	Bytes  int64  `json:"bytes,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Kind   string `json:"kind,omitempty"` // Class of the error, see errorKind
+}
+
+// printer writes results as text or JSON lines and remembers failures
+type printer struct {
+	json   bool
+	quiet  bool // Print only failures as text
+	out    io.Writer
+	failed bool
+}
+
+// newPrinter creates a printer. Commands writing file content to standard output
+// print their results to standard error instead, and as text only their failures.
+func newPrinter(options *globalOptions, contentOnStdout bool) *printer {
+	p := &printer{json: options.json, out: os.Stdout}
+	if contentOnStdout {
+		p.out = os.Stderr
+		p.quiet = true
+	}
+	return p
+}
+
+// report prints the outcome of an operation on a file
+func (p *printer) report(file, dest string, bytes int64, err error) {
+	p.print(result{File: file, Dest: dest, Bytes: bytes}, err)
+}
+
+// reportStatus prints the outcome of an operation that ends in one of several states
+func (p *printer) reportStatus(file, status string, err error) {
+	p.print(result{File: file, Status: status}, err)
+}
+
+// print completes a result with the error and prints it
+func (p *printer) print(r result, err error) {
+	r.OK = err == nil
+	if err != nil {
+		p.failed = true
+		r.Error = err.Error()
+		r.Kind = errorKind(err)
+	}
+
+	if p.json {
+		p.encode(r)
+		return
+	}
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	case p.quiet:
+	case r.Dest != "":
+		fmt.Fprintf(p.out, "%s -> %s\n", r.File, r.Dest)
+	case r.Status != "":
+		fmt.Fprintf(p.out, "%s %s\n", r.Status, r.File)
+	default:
+		fmt.Fprintf(p.out, "ok %s\n", r.File)
+	}
+}
+
+// encode prints a value as a JSON line
+func (p *printer) encode(v interface{}) {
+	if err := json.NewEncoder(p.out).Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to encode output: %v\n", err)
+		p.failed = true
+	}
+}
+
+// done returns errFailed when any operation failed
+func (p *printer) done() error {
+	if p.failed {
+		return errFailed
+	}
+	return nil
+}
+
+// errorKind classifies an error for machine-readable output
+func errorKind(err error) string {
+	if errors.Is(err, errConflict) {
+		return "conflict"
+	}
+	return sealfile.ErrorKind(err)
+}
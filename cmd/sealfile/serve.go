@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/crdzbird/sealfile"
+)
+
+// runServe serves the HTTP API of the store until interrupted
+func runServe(args []string) error {
+	var options globalOptions
+	flags := newFlagSet("serve", &options)
+	addr := flags.String("addr", "127.0.0.1:8080", "listen on `address`")
+	tokenFile := flags.String("token-file", "", "accept the bearer tokens listed in `file`, one per line")
+	tokenEnv := flags.String("token-env", "", "accept the bearer token held by the environment `variable`")
+	noAuth := flags.Bool("no-auth", false, "serve without authentication")
+	maxFileBytes := flags.Int64("max-file-bytes", 0, "largest file accepted in `bytes`, 32 MiB when zero and unlimited when negative")
+	if _, err := parseArgs(flags, args, 0); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	tokens, err := readTokens(*tokenFile, *tokenEnv)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 && !*noAuth {
+		return fmt.Errorf("no tokens given, use --token-file or --token-env, or --no-auth to serve without authentication")
+	}
+
+	config, err := options.loadConfig()
+	if err != nil {
+		return err
+	}
+	// Clients must not reach files outside the store
+	if config.RootDir == "" {
+		config.RootDir = "."
+	}
+	fm, err := sealfile.NewFileManager(config)
+	if err != nil {
+		return err
+	}
+
+	apiOptions := sealfile.APIOptions{MaxConcurrency: options.concurrency, MaxFileBytes: *maxFileBytes}
+	if len(tokens) > 0 {
+		apiOptions.Middleware = sealfile.BearerAuth(tokens...)
+	}
+	handler, err := fm.APIHandler(apiOptions)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Finish running requests before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdown)
+	}()
+
+	fmt.Fprintf(os.Stderr, "sealfile serve: listening on %s, root %s\n", *addr, config.RootDir)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// readTokens reads the bearer tokens from a file and an environment variable
+func readTokens(file, env string) ([]string, error) {
+	var tokens []string
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tokens: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if token := strings.TrimSpace(scanner.Text()); token != "" && !strings.HasPrefix(token, "#") {
+				tokens = append(tokens, token)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read tokens: %w", err)
+		}
+	}
+	if env != "" {
+		token := os.Getenv(env)
+		if token == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package main
+
+import "fmt"
+
+// promptKey is not supported without a termios terminal
+func promptKey(prompt string) (string, error) {
+	return "", fmt.Errorf("--prompt-key is not supported on this platform, use --key-file or --key-env")
+}
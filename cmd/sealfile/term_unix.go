@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// promptKey asks for the key on the terminal with echo turned off
+func promptKey(prompt string) (string, error) {
+	fd := os.Stdin.Fd()
+
+	var state syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlGetTermios, uintptr(unsafe.Pointer(&state))); errno != 0 {
+		return "", fmt.Errorf("--prompt-key needs a terminal on standard input")
+	}
+
+	noEcho := state
+	noEcho.Lflag &^= syscall.ECHO
+	noEcho.Lflag |= syscall.ICANON | syscall.ISIG
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&noEcho))); errno != 0 {
+		return "", fmt.Errorf("failed to turn off echo: %w", errno)
+	}
+	defer func() {
+		_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, fd, ioctlSetTermios, uintptr(unsafe.Pointer(&state)))
+		fmt.Fprintln(os.Stderr)
+	}()
+
+	fmt.Fprint(os.Stderr, prompt)
+	return readLine(os.Stdin)
+}
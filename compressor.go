@@ -3,6 +3,8 @@ package sealfile
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -15,10 +17,25 @@ func NewCompressor() *Compressor {
 	return &Compressor{}
 }
 
+// gzipKeyCheckID identifies the gzip extra subfield holding the key check of whole-file sealed data
+var gzipKeyCheckID = [2]byte{'S', 'K'}
+
 // Compress compresses data using gzip
 func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	return c.compressWithKeyCheck(data, nil)
+}
+
+// compressWithKeyCheck compresses data using gzip and records keyCheck, when given, in the
+// extra field of the gzip header. Readers unaware of the field skip it.
+func (c *Compressor) compressWithKeyCheck(data, keyCheck []byte) ([]byte, error) {
 	var compressedData bytes.Buffer
 	gzw := gzip.NewWriter(&compressedData)
+	if keyCheck != nil {
+		subfield := make([]byte, 4, 4+len(keyCheck))
+		copy(subfield, gzipKeyCheckID[:])
+		binary.LittleEndian.PutUint16(subfield[2:], uint16(len(keyCheck)))
+		gzw.Extra = append(subfield, keyCheck...)
+	}
 
 	if _, err := gzw.Write(data); err != nil {
 		return nil, fmt.Errorf("failed to write data to gzip writer: %w", err)
@@ -36,7 +53,10 @@ func (c *Compressor) Decompress(data []byte) ([]byte, error) {
 	buffer := bytes.NewBuffer(data)
 	gzr, err := gzip.NewReader(buffer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		if errors.Is(err, gzip.ErrHeader) {
+			return nil, mark(ErrUnsupportedFormat, fmt.Errorf("failed to create gzip reader: %w", err))
+		}
+		return nil, mark(ErrCorrupted, fmt.Errorf("failed to create gzip reader: %w", err))
 	}
 	defer func() {
 		if cert := gzr.Close(); cert != nil && err == nil {
@@ -45,8 +65,28 @@ func (c *Compressor) Decompress(data []byte) ([]byte, error) {
 	}()
 	decompressed, err := io.ReadAll(gzr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read decompressed data: %w", err)
+		return nil, mark(ErrCorrupted, fmt.Errorf("failed to read decompressed data: %w", err))
 	}
 
 	return decompressed, nil
 }
+
+// gzipKeyCheck returns the key check recorded in the gzip header of data, nil when there is none
+func gzipKeyCheck(data []byte) []byte {
+	gzr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	extra := gzr.Extra
+	for len(extra) >= 4 {
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return nil
+		}
+		if [2]byte(extra[:2]) == gzipKeyCheckID {
+			return extra[4 : 4+size]
+		}
+		extra = extra[4+size:]
+	}
+	return nil
+}
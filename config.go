@@ -12,11 +12,23 @@ const _privateKey = "A7!xM3pL#9zQwR2@tF6vH8jK$1nB5cD0"
 
 // Config holds configuration for the file library
 type Config struct {
-	EncryptionKey string
-	BaseURL       string
-	PublicDir     string
-	TempDir       string
-	PathType      PathType
+	EncryptionKey      string
+	PreviousKeys       []string // Keys of earlier rotations, used to rekey existing files
+	BaseURL            string
+	PublicDir          string
+	TempDir            string
+	PathType           PathType
+	Retry              *RetryPolicy   // Retries transient storage errors, disabled when nil
+	JournalDir         string         // Directory of the batch job journal, jobs are not journaled when empty
+	RootDir            string         // Confines every path to this directory, relative paths are taken from it
+	TenantID           string         // Recorded in the metadata of sealed files and checked when loading them
+	SigningKeys        []string       // Keys signing URLs, the first signs and all verify; derived from the encryption keys when empty
+	RequireSignedURLs  bool           // The handler refuses URLs without a valid signature
+	ThumbnailSizes     []int          // Bounding boxes in pixels of the thumbnails sealed next to saved images, none when empty
+	StripImageMetadata bool           // Removes EXIF, XMP and textual metadata from JPEG and PNG images before sealing them
+	ContentPolicy      *ContentPolicy // Checks saved files against the type of their content, disabled when nil
+	QuarantineDir      string         // Receives files a Scanner quarantines, sealed; such files are refused when empty
+	SealStreams        bool           // Seals every file as a sealed stream with a key check, see Key Checks
 }
 
 // DefaultConfig returns a default configuration
@@ -29,3 +41,17 @@ func DefaultConfig() *Config {
 		PathType:      DirectoryPath,
 	}
 }
+
+// clone returns a deep copy of the configuration
+func (c *Config) clone() *Config {
+	clone := *c
+	clone.PreviousKeys = append([]string(nil), c.PreviousKeys...)
+	clone.SigningKeys = append([]string(nil), c.SigningKeys...)
+	clone.ThumbnailSizes = append([]int(nil), c.ThumbnailSizes...)
+	clone.ContentPolicy = c.ContentPolicy.clone()
+	if c.Retry != nil {
+		retry := *c.Retry
+		clone.Retry = &retry
+	}
+	return &clone
+}
@@ -0,0 +1,445 @@
+package sealfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of environment variables read by LoadConfig
+const EnvPrefix = "SEALFILE_"
+
+// fileConfig is the JSON representation of a Config. Pointer fields tell
+// unset values apart from empty ones, so that layers only override what they set.
+type fileConfig struct {
+	EncryptionKey    *string          `json:"encryption_key"`
+	KeyFile          *string          `json:"key_file"`
+	KeyEnv           *string          `json:"key_env"`
+	PreviousKeyFiles []string         `json:"previous_key_files"`
+	PreviousKeyEnvs  []string         `json:"previous_key_envs"`
+	SigningKeyFiles  []string         `json:"signing_key_files"`
+	SigningKeyEnvs   []string         `json:"signing_key_envs"`
+	RequireSigned    *bool            `json:"require_signed_urls"`
+	ThumbnailSizes   []int            `json:"thumbnail_sizes"`
+	StripMetadata    *bool            `json:"strip_image_metadata"`
+	SealStreams      *bool            `json:"seal_streams"`
+	ContentPolicy    *ContentPolicy   `json:"content_policy"`
+	BaseURL          *string          `json:"base_url"`
+	PublicDir        *string          `json:"public_dir"`
+	TempDir          *string          `json:"temp_dir"`
+	PathType         *string          `json:"path_type"`
+	JournalDir       *string          `json:"journal_dir"`
+	RootDir          *string          `json:"root_dir"`
+	QuarantineDir    *string          `json:"quarantine_dir"`
+	Retry            *fileRetryPolicy `json:"retry"`
+}
+
+// fileRetryPolicy is the JSON representation of a RetryPolicy
+type fileRetryPolicy struct {
+	MaxAttempts int     `json:"max_attempts"`
+	BaseDelay   string  `json:"base_delay"`
+	MaxDelay    string  `json:"max_delay"`
+	Jitter      float64 `json:"jitter"`
+}
+
+// LoadConfig builds a configuration from defaults, an optional JSON file and
+// SEALFILE_ environment variables, in increasing order of precedence.
+//
+// Keys should not be written inline: key_file names a file holding the key (relative
+// to the config file) and key_env names an environment variable holding it. Each layer
+// may use only one of encryption_key, key_file and key_env; the last layer setting one wins.
+// Relative directories in the file are taken relative to the config file as well, or to
+// root_dir when one is configured. Pass an empty path to configure from the environment
+// only. The result is validated.
+func LoadConfig(path string) (*Config, error) {
+	return LoadConfigWith(path, nil)
+}
+
+// LoadConfigWith is like LoadConfig, but explicit settings, such as those of command-line
+// flags, are applied by explicit over every other layer before the result is validated
+func LoadConfigWith(path string, explicit func(*Config) error) (*Config, error) {
+	config := DefaultConfig()
+	config.EncryptionKey = ""
+
+	var fc *fileConfig
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+
+		fc = &fileConfig{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(fc); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+
+		if err := fc.apply(config, filepath.Dir(path)); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	}
+
+	env, err := configFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := env.apply(config, "."); err != nil {
+		return nil, fmt.Errorf("invalid environment configuration: %w", err)
+	}
+	if fc != nil {
+		fc.resolveDirs(config, filepath.Dir(path), env)
+	}
+
+	if explicit != nil {
+		if err := explicit(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// configFromEnv reads the SEALFILE_ environment variables
+func configFromEnv() (*fileConfig, error) {
+	lookup := func(name string) *string {
+		if value, ok := os.LookupEnv(EnvPrefix + name); ok {
+			return &value
+		}
+		return nil
+	}
+	list := func(name string) []string {
+		if value := lookup(name); value != nil && *value != "" {
+			return strings.Split(*value, string(os.PathListSeparator))
+		}
+		return nil
+	}
+
+	fc := &fileConfig{
+		EncryptionKey:    lookup("ENCRYPTION_KEY"),
+		KeyFile:          lookup("KEY_FILE"),
+		KeyEnv:           lookup("KEY_ENV"),
+		PreviousKeyFiles: list("PREVIOUS_KEY_FILES"),
+		PreviousKeyEnvs:  list("PREVIOUS_KEY_ENVS"),
+		SigningKeyFiles:  list("SIGNING_KEY_FILES"),
+		SigningKeyEnvs:   list("SIGNING_KEY_ENVS"),
+		BaseURL:          lookup("BASE_URL"),
+		PublicDir:        lookup("PUBLIC_DIR"),
+		TempDir:          lookup("TEMP_DIR"),
+		PathType:         lookup("PATH_TYPE"),
+		JournalDir:       lookup("JOURNAL_DIR"),
+		RootDir:          lookup("ROOT_DIR"),
+		QuarantineDir:    lookup("QUARANTINE_DIR"),
+	}
+
+	if attempts := lookup("RETRY_MAX_ATTEMPTS"); attempts != nil {
+		n, err := strconv.Atoi(*attempts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sRETRY_MAX_ATTEMPTS: %w", EnvPrefix, err)
+		}
+		fc.Retry = &fileRetryPolicy{MaxAttempts: n}
+	}
+	if required := lookup("REQUIRE_SIGNED_URLS"); required != nil {
+		value, err := strconv.ParseBool(*required)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sREQUIRE_SIGNED_URLS: %w", EnvPrefix, err)
+		}
+		fc.RequireSigned = &value
+	}
+	if strip := lookup("STRIP_IMAGE_METADATA"); strip != nil {
+		value, err := strconv.ParseBool(*strip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sSTRIP_IMAGE_METADATA: %w", EnvPrefix, err)
+		}
+		fc.StripMetadata = &value
+	}
+	if streams := lookup("SEAL_STREAMS"); streams != nil {
+		value, err := strconv.ParseBool(*streams)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sSEAL_STREAMS: %w", EnvPrefix, err)
+		}
+		fc.SealStreams = &value
+	}
+	if sizes := lookup("THUMBNAIL_SIZES"); sizes != nil && *sizes != "" {
+		for _, size := range strings.Split(*sizes, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(size))
+			if err != nil {
+				return nil, fmt.Errorf("invalid %sTHUMBNAIL_SIZES: %w", EnvPrefix, err)
+			}
+			fc.ThumbnailSizes = append(fc.ThumbnailSizes, n)
+		}
+	}
+	return fc, nil
+}
+
+// apply overrides the values of config that are set in fc
+func (fc *fileConfig) apply(config *Config, baseDir string) error {
+	key, err := fc.resolveKey(baseDir)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		config.EncryptionKey = *key
+	}
+
+	if len(fc.PreviousKeyFiles) > 0 || len(fc.PreviousKeyEnvs) > 0 {
+		config.PreviousKeys = nil
+		for _, file := range fc.PreviousKeyFiles {
+			key, err := readKeyFile(file, baseDir)
+			if err != nil {
+				return err
+			}
+			config.PreviousKeys = append(config.PreviousKeys, key)
+		}
+		for _, name := range fc.PreviousKeyEnvs {
+			key, err := readKeyEnv(name)
+			if err != nil {
+				return err
+			}
+			config.PreviousKeys = append(config.PreviousKeys, key)
+		}
+	}
+
+	if len(fc.SigningKeyFiles) > 0 || len(fc.SigningKeyEnvs) > 0 {
+		config.SigningKeys = nil
+		for _, file := range fc.SigningKeyFiles {
+			key, err := readKeyFile(file, baseDir)
+			if err != nil {
+				return err
+			}
+			config.SigningKeys = append(config.SigningKeys, key)
+		}
+		for _, name := range fc.SigningKeyEnvs {
+			key, err := readKeyEnv(name)
+			if err != nil {
+				return err
+			}
+			config.SigningKeys = append(config.SigningKeys, key)
+		}
+	}
+	if fc.RequireSigned != nil {
+		config.RequireSignedURLs = *fc.RequireSigned
+	}
+	if fc.StripMetadata != nil {
+		config.StripImageMetadata = *fc.StripMetadata
+	}
+	if fc.SealStreams != nil {
+		config.SealStreams = *fc.SealStreams
+	}
+	if fc.ThumbnailSizes != nil {
+		config.ThumbnailSizes = append([]int(nil), fc.ThumbnailSizes...)
+	}
+	if fc.ContentPolicy != nil {
+		config.ContentPolicy = fc.ContentPolicy.clone()
+	}
+
+	if fc.BaseURL != nil {
+		config.BaseURL = *fc.BaseURL
+	}
+	if fc.PublicDir != nil {
+		config.PublicDir = *fc.PublicDir
+	}
+	if fc.TempDir != nil {
+		config.TempDir = *fc.TempDir
+	}
+	if fc.JournalDir != nil {
+		config.JournalDir = *fc.JournalDir
+	}
+	if fc.RootDir != nil {
+		config.RootDir = *fc.RootDir
+	}
+	if fc.QuarantineDir != nil {
+		config.QuarantineDir = *fc.QuarantineDir
+	}
+	if fc.PathType != nil {
+		pathType, err := ParsePathType(*fc.PathType)
+		if err != nil {
+			return err
+		}
+		config.PathType = pathType
+	}
+	if fc.Retry != nil {
+		retry, err := fc.Retry.policy(config.Retry)
+		if err != nil {
+			return err
+		}
+		config.Retry = retry
+	}
+	return nil
+}
+
+// resolveDirs takes the relative directories set by fc relative to baseDir, the directory of
+// the config file, unless the later layer overrides them. Inside a root directory they stay
+// relative to the root, which is itself taken relative to the config file.
+func (fc *fileConfig) resolveDirs(config *Config, baseDir string, later *fileConfig) {
+	resolve := func(set, override *string, dir *string) {
+		if set != nil && override == nil && *dir != "" && !filepath.IsAbs(*dir) {
+			*dir = filepath.Join(baseDir, *dir)
+		}
+	}
+
+	resolve(fc.RootDir, later.RootDir, &config.RootDir)
+	if config.RootDir != "" {
+		return
+	}
+	resolve(fc.PublicDir, later.PublicDir, &config.PublicDir)
+	resolve(fc.TempDir, later.TempDir, &config.TempDir)
+	resolve(fc.JournalDir, later.JournalDir, &config.JournalDir)
+	resolve(fc.QuarantineDir, later.QuarantineDir, &config.QuarantineDir)
+}
+
+// resolveKey returns the key set by this layer, or nil when it sets none
+func (fc *fileConfig) resolveKey(baseDir string) (*string, error) {
+	sources := 0
+	for _, source := range []*string{fc.EncryptionKey, fc.KeyFile, fc.KeyEnv} {
+		if source != nil {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return nil, fmt.Errorf("only one of encryption_key, key_file and key_env may be set")
+	}
+
+	switch {
+	case fc.EncryptionKey != nil:
+		return fc.EncryptionKey, nil
+	case fc.KeyFile != nil:
+		key, err := readKeyFile(*fc.KeyFile, baseDir)
+		return &key, err
+	case fc.KeyEnv != nil:
+		key, err := readKeyEnv(*fc.KeyEnv)
+		return &key, err
+	}
+	return nil, nil
+}
+
+// policy converts the JSON retry settings, starting from base when given
+func (fr *fileRetryPolicy) policy(base *RetryPolicy) (*RetryPolicy, error) {
+	policy := DefaultRetryPolicy()
+	if base != nil {
+		copied := *base
+		policy = &copied
+	}
+
+	if fr.MaxAttempts != 0 {
+		policy.MaxAttempts = fr.MaxAttempts
+	}
+	if fr.Jitter != 0 {
+		policy.Jitter = fr.Jitter
+	}
+	for _, d := range []struct {
+		value  string
+		target *time.Duration
+	}{{fr.BaseDelay, &policy.BaseDelay}, {fr.MaxDelay, &policy.MaxDelay}} {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry delay %q: %w", d.value, err)
+		}
+		*d.target = parsed
+	}
+	return policy, nil
+}
+
+// readKeyFile reads a key from a file, relative paths are resolved against baseDir
+func readKeyFile(path, baseDir string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := strings.TrimRight(string(data), "\r\n")
+	if key == "" {
+		return "", fmt.Errorf("key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// readKeyEnv reads a key from the named environment variable
+func readKeyEnv(name string) (string, error) {
+	key, ok := os.LookupEnv(name)
+	if !ok || key == "" {
+		return "", fmt.Errorf("key environment variable %s is not set", name)
+	}
+	return key, nil
+}
+
+// ParsePathType parses "directory" or "http" into a PathType
+func ParsePathType(value string) (PathType, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "directory", "dir", "":
+		return DirectoryPath, nil
+	case "http", "url":
+		return HTTPPath, nil
+	default:
+		return DirectoryPath, fmt.Errorf("unknown path type %q", value)
+	}
+}
+
+// Validate checks that the configuration is complete and consistent
+func (c *Config) Validate() error {
+	if c.EncryptionKey == "" {
+		return fmt.Errorf("invalid config: no encryption key configured")
+	}
+	for _, key := range c.PreviousKeys {
+		if key == "" {
+			return fmt.Errorf("invalid config: previous keys must not be empty")
+		}
+	}
+	for _, key := range c.SigningKeys {
+		if key == "" {
+			return fmt.Errorf("invalid config: signing keys must not be empty")
+		}
+	}
+	for _, size := range c.ThumbnailSizes {
+		if size <= 0 || size > maxThumbnailSize {
+			return fmt.Errorf("invalid config: thumbnail sizes must be between 1 and %d", maxThumbnailSize)
+		}
+	}
+	if c.ContentPolicy != nil {
+		if err := c.ContentPolicy.validate(); err != nil {
+			return fmt.Errorf("invalid config: %w", err)
+		}
+	}
+	if c.PublicDir == "" {
+		return fmt.Errorf("invalid config: public directory is required")
+	}
+	if c.TempDir == "" {
+		return fmt.Errorf("invalid config: temp directory is required")
+	}
+
+	switch c.PathType {
+	case DirectoryPath:
+	case HTTPPath:
+		if c.BaseURL == "" {
+			return fmt.Errorf("invalid config: base URL is required for HTTP paths")
+		}
+	default:
+		return fmt.Errorf("invalid config: unknown path type %d", c.PathType)
+	}
+
+	if c.BaseURL != "" {
+		u, err := url.Parse(c.BaseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid config: base URL %q is not an absolute URL", c.BaseURL)
+		}
+	}
+
+	if c.Retry != nil && (c.Retry.MaxAttempts < 0 || c.Retry.Jitter < 0 || c.Retry.Jitter > 1) {
+		return fmt.Errorf("invalid config: retry policy out of range")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package sealfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// configFileForTest writes a config file into a fresh directory and returns its path
+func configFileForTest(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "conf", "sealfile.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigResolvesDirsRelativeToFile(t *testing.T) {
+	path := configFileForTest(t, `{
+		"encryption_key": "file key",
+		"public_dir": "public",
+		"temp_dir": "../temp",
+		"journal_dir": "journal",
+		"quarantine_dir": "/var/quarantine"
+	}`)
+	dir := filepath.Dir(path)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]string{
+		"public_dir":     {config.PublicDir, filepath.Join(dir, "public")},
+		"temp_dir":       {config.TempDir, filepath.Join(dir, "../temp")},
+		"journal_dir":    {config.JournalDir, filepath.Join(dir, "journal")},
+		"quarantine_dir": {config.QuarantineDir, "/var/quarantine"},
+	}
+	for name, got := range want {
+		if got[0] != got[1] {
+			t.Errorf("%s is %q, want %q", name, got[0], got[1])
+		}
+	}
+
+	// Directories the environment sets are kept as given
+	t.Setenv(EnvPrefix+"PUBLIC_DIR", "env-public")
+	if config, err = LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if config.PublicDir != "env-public" || config.JournalDir != filepath.Join(dir, "journal") {
+		t.Fatalf("got public dir %q, journal dir %q", config.PublicDir, config.JournalDir)
+	}
+}
+
+func TestLoadConfigKeepsDirsRelativeToRoot(t *testing.T) {
+	path := configFileForTest(t, `{
+		"encryption_key": "file key",
+		"root_dir": "data",
+		"public_dir": "public",
+		"journal_dir": ""
+	}`)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(filepath.Dir(path), "data"); config.RootDir != want {
+		t.Fatalf("root dir is %q, want %q", config.RootDir, want)
+	}
+	if config.PublicDir != "public" || config.JournalDir != "" {
+		t.Fatalf("got public dir %q, journal dir %q", config.PublicDir, config.JournalDir)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := configFileForTest(t, `{
+		"encryption_key": "file key",
+		"base_url": "https://file.example",
+		"path_type": "url"
+	}`)
+
+	// Defaults are used for what no layer sets
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultConfig()
+	if config.EncryptionKey != "file key" || config.BaseURL != "https://file.example" || config.TempDir != defaults.TempDir {
+		t.Fatalf("file layer not applied: %+v", config)
+	}
+	if config.PathType == defaults.PathType {
+		t.Fatalf("path type is the default %v", config.PathType)
+	}
+
+	// The environment overrides the file
+	t.Setenv(EnvPrefix+"ENCRYPTION_KEY", "env key")
+	t.Setenv(EnvPrefix+"BASE_URL", "https://env.example")
+	if config, err = LoadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	if config.EncryptionKey != "env key" || config.BaseURL != "https://env.example" {
+		t.Fatalf("environment layer not applied: %+v", config)
+	}
+
+	// Explicit settings override the environment and are validated
+	config, err = LoadConfigWith(path, func(config *Config) error {
+		config.EncryptionKey = "explicit key"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.EncryptionKey != "explicit key" || config.BaseURL != "https://env.example" {
+		t.Fatalf("explicit layer not applied: %+v", config)
+	}
+	if _, err := LoadConfigWith(path, func(config *Config) error {
+		config.EncryptionKey = ""
+		return nil
+	}); err == nil {
+		t.Fatal("explicit settings were not validated")
+	}
+}
@@ -0,0 +1,72 @@
+package sealfile
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConfigLoader loads a configuration from a file
+type ConfigLoader func(path string) (*Config, error)
+
+// WatchConfigFile polls path and reloads the configuration whenever the file changes.
+// A failed reload keeps the active configuration and is reported to onError when given.
+// The returned function stops watching.
+func (fm *FileManager) WatchConfigFile(path string, interval time.Duration, load ConfigLoader, onError func(error)) (func(), error) {
+	if load == nil {
+		return nil, fmt.Errorf("config loader must not be nil")
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	last, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch config file: %w", err)
+	}
+
+	report := func(err error) {
+		if onError != nil {
+			onError(err)
+		}
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				report(fmt.Errorf("failed to watch config file: %w", err))
+				continue
+			}
+			if info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+
+			config, err := load(path)
+			if err != nil {
+				report(fmt.Errorf("failed to reload config: %w", err))
+				continue
+			}
+			if err := fm.UpdateConfig(config); err != nil {
+				report(fmt.Errorf("failed to reload config: %w", err))
+			}
+		}
+	}()
+
+	var stop sync.Once
+	return func() {
+		stop.Do(func() { close(done) })
+	}, nil
+}
@@ -0,0 +1,251 @@
+package sealfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otherKey is a second valid key for tests switching keys
+var otherKey = strings.Repeat("k", 32)
+
+// pauseFirst returns an authorizer holding the first operation it authorizes until release
+// is closed, entered is closed once that operation arrived
+func pauseFirst() (authorizer Authorizer, entered <-chan struct{}, release chan struct{}) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	return AuthorizerFunc(func(context.Context, AccessOp, string) error {
+		first := false
+		once.Do(func() {
+			first = true
+			close(arrived)
+		})
+		if first {
+			<-release
+		}
+		return nil
+	}), arrived, release
+}
+
+// withKey returns the configuration of fm using key and no previous keys
+func withKey(fm *FileManager, key string) *Config {
+	config := fm.GetConfig()
+	config.EncryptionKey = key
+	config.PreviousKeys = nil
+	return config
+}
+
+// reloadWhilePaused runs op, swaps the configuration of fm for config while op is held by
+// the authorizer and returns the error of op
+func reloadWhilePaused(t *testing.T, fm *FileManager, config *Config, op func() error) error {
+	t.Helper()
+	authorizer, entered, release := pauseFirst()
+	fm.SetAuthorizer(authorizer)
+	defer fm.SetAuthorizer(nil)
+
+	done := make(chan error, 1)
+	go func() { done <- op() }()
+
+	<-entered
+	if err := fm.UpdateConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	return <-done
+}
+
+func TestOperationKeepsSnapshotDuringReload(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	original := fm.GetConfig()
+
+	// A save started before the reload seals with the key it started with
+	err := reloadWhilePaused(t, fm, withKey(fm, otherKey), func() error {
+		_, err := fm.SaveDataAsSecureFile([]byte("before"), "files", "a.txt")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.LoadSecureFileFromDisk("files", "a.txt"); !errors.Is(err, ErrAuthenticationFailed) && !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("file sealed during the reload opens with the new key: %v", err)
+	}
+
+	// A load started before the reload decrypts with the key it started with
+	if err := fm.UpdateConfig(original); err != nil {
+		t.Fatal(err)
+	}
+	var loaded *SecureFile
+	err = reloadWhilePaused(t, fm, withKey(fm, otherKey), func() error {
+		var err error
+		loaded, err = fm.LoadSecureFileFromDisk("files", "a.txt")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.Data) != "before" {
+		t.Fatalf("loaded %q", loaded.Data)
+	}
+}
+
+func TestBatchKeepsSnapshotDuringReload(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	original := fm.GetConfig()
+
+	operations := make([]FileOperation, 16)
+	for i := range operations {
+		operations[i] = FileOperation{Data: []byte(fmt.Sprint(i)), Path: "files", Filename: fmt.Sprintf("%d.txt", i)}
+	}
+
+	var results []FileOperation
+	err := reloadWhilePaused(t, fm, withKey(fm, otherKey), func() error {
+		results = NewBatchProcessor(fm, 4).EncryptOperations(operations)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every item of the batch was sealed with the key the batch started with
+	if err := fm.UpdateConfig(original); err != nil {
+		t.Fatal(err)
+	}
+	for i, result := range results {
+		if result.Error != nil {
+			t.Fatal(result.Error)
+		}
+		sf, err := fm.LoadSecureFileFromDisk("files", result.Filename)
+		if err != nil {
+			t.Fatalf("item %d was not sealed with the key of the batch: %v", i, err)
+		}
+		if string(sf.Data) != fmt.Sprint(i) {
+			t.Fatalf("item %d holds %q", i, sf.Data)
+		}
+	}
+}
+
+func TestConcurrentReloads(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	streams, whole := fm.GetConfig(), fm.GetConfig()
+	streams.SealStreams = true
+
+	stop := make(chan struct{})
+	var reloads sync.WaitGroup
+	reloads.Add(1)
+	go func() {
+		defer reloads.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			config := whole
+			if i%2 == 0 {
+				config = streams
+			}
+			if err := fm.UpdateConfig(config); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for w := range 4 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range 20 {
+				data := []byte(fmt.Sprintf("worker %d file %d", w, i))
+				filename := fmt.Sprintf("%d-%d.txt", w, i)
+				if _, err := fm.SaveDataAsSecureFile(data, "files", filename); err != nil {
+					t.Error(err)
+					return
+				}
+				sf, err := fm.LoadSecureFileFromDisk("files", filename)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(sf.Data, data) {
+					t.Errorf("%s holds %q", filename, sf.Data)
+				}
+			}
+		}()
+	}
+
+	workers.Wait()
+	close(stop)
+	reloads.Wait()
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	root := t.TempDir()
+	path := filepath.Join(root, "sealfile.json")
+	write := func(streams bool) {
+		data, err := json.Marshal(map[string]any{
+			"encryption_key": strings.Repeat("w", 32),
+			"root_dir":       root,
+			"public_dir":     "public",
+			"seal_streams":   streams,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(false)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fm, err := NewFileManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	stop, err := fm.WatchConfigFile(path, 5*time.Millisecond, LoadConfig, func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// A reload that fails keeps the active configuration
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed reload was not reported")
+	}
+	if fm.GetConfig().EncryptionKey != config.EncryptionKey {
+		t.Fatal("failed reload replaced the configuration")
+	}
+
+	write(true)
+	deadline := time.Now().Add(5 * time.Second)
+	for !fm.GetConfig().SealStreams {
+		if time.Now().After(deadline) {
+			t.Fatal("changed config file was not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
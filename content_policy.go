@@ -0,0 +1,183 @@
+package sealfile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ContentPolicy checks saved files against the type of their content, see Config.ContentPolicy.
+// Types are detected with DefaultTypeRegistry; content naming another type than the
+// extension counts as that type, so renamed files cannot slip past the rules.
+type ContentPolicy struct {
+	VerifyContent bool          `json:"verify_content"` // Refuse files whose content contradicts the category of their extension
+	Rules         []ContentRule `json:"rules"`          // Type restrictions per directory, the most specific rule applies
+}
+
+// ContentRule restricts the file types saved below a directory. Entries of Allow and
+// Deny name a category, such as "image", or a type, such as "svg".
+type ContentRule struct {
+	Dir   string   `json:"dir"`   // Slash-separated directory relative to Config.RootDir, every directory when empty
+	Allow []string `json:"allow"` // Accepted types, all when empty; content of unknown type is refused otherwise
+	Deny  []string `json:"deny"`  // Refused types, taking precedence over Allow
+}
+
+// ContentError is reported when the content policy refuses a file, it matches ErrContentRejected
+type ContentError struct {
+	Filename string
+	Declared FileType // Type of the extension, zero when the extension is unknown
+	Detected FileType // Type of the content, zero when it is not recognized
+	Reason   string   // "mismatch", "denied" or "not allowed"
+	Dir      string   // Directory of the rule refusing the file, empty for mismatches
+}
+
+// Error describes why the file was refused
+func (e *ContentError) Error() string {
+	switch e.Reason {
+	case "mismatch":
+		detected := e.Detected.Name
+		if detected == "" {
+			detected = "unrecognized content"
+		}
+		return fmt.Sprintf("%s: %s is named as %s but holds %s", ErrContentRejected, e.Filename, e.Declared.Name, detected)
+	default:
+		return fmt.Sprintf("%s: %s of type %s is %s in %s", ErrContentRejected, e.Filename, typeName(e.Detected), e.Reason, e.Dir)
+	}
+}
+
+// Unwrap returns ErrContentRejected
+func (e *ContentError) Unwrap() error {
+	return ErrContentRejected
+}
+
+// typeName names a detected type for messages
+func typeName(fileType FileType) string {
+	if fileType.Name == "" {
+		return "unknown"
+	}
+	return fileType.Name
+}
+
+// clone returns a deep copy of the policy
+func (p *ContentPolicy) clone() *ContentPolicy {
+	if p == nil {
+		return nil
+	}
+	clone := &ContentPolicy{VerifyContent: p.VerifyContent, Rules: make([]ContentRule, len(p.Rules))}
+	for i, rule := range p.Rules {
+		clone.Rules[i] = ContentRule{
+			Dir:   rule.Dir,
+			Allow: append([]string(nil), rule.Allow...),
+			Deny:  append([]string(nil), rule.Deny...),
+		}
+	}
+	return clone
+}
+
+// validate checks that every rule names categories or types known to DefaultTypeRegistry
+func (p *ContentPolicy) validate() error {
+	for _, rule := range p.Rules {
+		for _, entry := range append(append([]string(nil), rule.Allow...), rule.Deny...) {
+			if !DefaultTypeRegistry.knows(entry) {
+				return fmt.Errorf("content rule for %q names unknown type %q", rule.Dir, entry)
+			}
+		}
+	}
+	return nil
+}
+
+// ruleFor returns the rule with the longest directory covering dir, or nil
+func (p *ContentPolicy) ruleFor(dir string) *ContentRule {
+	var best *ContentRule
+	bestLen := -1
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		prefix := strings.TrimSuffix(path.Clean("/"+rule.Dir), "/")
+		if pathCovers(rule.Dir, dir) && len(prefix) > bestLen {
+			best, bestLen = rule, len(prefix)
+		}
+	}
+	return best
+}
+
+// check applies the policy to a file saved into dir, head holding the start of its content
+func (p *ContentPolicy) check(dir, filename string, head []byte) error {
+	declared, named := DefaultTypeRegistry.Lookup(filename)
+	detected, _ := DefaultTypeRegistry.Detect(filename, head)
+
+	if p.VerifyContent && named && !declared.Matches(head) {
+		sniffed, ok := DefaultTypeRegistry.Sniff(head)
+		// Content of another type of the same category, such as a PNG named .jpg, passes.
+		// Types without signatures, such as text, are only refused for recognized content.
+		if (ok && sniffed.Category != declared.Category) || (!ok && len(declared.Signatures) > 0) {
+			return &ContentError{Filename: filename, Declared: declared, Detected: sniffed, Reason: "mismatch"}
+		}
+	}
+
+	rule := p.ruleFor(dir)
+	if rule == nil {
+		return nil
+	}
+	refuse := func(reason string) error {
+		return &ContentError{Filename: filename, Declared: declared, Detected: detected, Reason: reason, Dir: dir}
+	}
+	if detected.listedIn(rule.Deny) {
+		return refuse("denied")
+	}
+	if len(rule.Allow) > 0 && !detected.listedIn(rule.Allow) {
+		return refuse("not allowed")
+	}
+	return nil
+}
+
+// listedIn reports whether entries name the type or its category
+func (t FileType) listedIn(entries []string) bool {
+	if t.Name == "" {
+		return false
+	}
+	for _, entry := range entries {
+		entry = strings.ToLower(entry)
+		if entry == strings.ToLower(t.Name) || entry == strings.ToLower(string(t.Category)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContent applies the content policy to the file about to be written to fullPath.
+// Files the package manages itself are not checked.
+func (sf *SecureFile) checkContent(snapshot *configSnapshot, fullPath string, head []byte) error {
+	policy := snapshot.config.ContentPolicy
+	if policy == nil || sf.fm.unchecked {
+		return nil
+	}
+	return policy.check(snapshot.authorizedPath(filepath.Dir(fullPath)), sf.Filename, head)
+}
+
+// checkContentFrom applies the content policy to the start of r. The returned reader
+// yields the whole content, including the bytes read for the check.
+func (sf *SecureFile) checkContentFrom(snapshot *configSnapshot, fullPath string, r io.Reader) (io.Reader, error) {
+	if snapshot.config.ContentPolicy == nil || sf.fm.unchecked {
+		return r, nil
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+	if err := sf.checkContent(snapshot, fullPath, head); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// contentHead returns the start of data inspected by the content policy
+func contentHead(data []byte) []byte {
+	return data[:min(len(data), sniffLen)]
+}
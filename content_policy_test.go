@@ -0,0 +1,137 @@
+package sealfile
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// policyForTest returns a file manager enforcing policy
+func policyForTest(t *testing.T, policy *ContentPolicy) *FileManager {
+	return newTestFileManager(t, func(config *Config) { config.ContentPolicy = policy })
+}
+
+func TestContentPolicyRejectsMismatch(t *testing.T) {
+	fm := policyForTest(t, &ContentPolicy{VerifyContent: true})
+	png := pngForTest(t, 4, 4)
+
+	tests := []struct {
+		name     string
+		filename string
+		data     []byte
+		detected string // Name of the detected type of refused files, empty when accepted
+		refused  bool
+	}{
+		{"executable named as image", "photo.jpg", []byte("MZ\x90\x00 executable"), "exe", true},
+		{"unrecognized content named as image", "photo.jpg", []byte("plain text"), "", true},
+		{"image named as document", "notes.txt", png, "png", true},
+		{"image of another type", "photo.jpg", png, "", false},
+		{"text named as text", "notes.txt", []byte("plain text"), "", false},
+		{"unknown extension", "data.bin", []byte("MZ\x90\x00 executable"), "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := fm.SaveDataAsSecureFile(test.data, "files", test.filename)
+			if !test.refused {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var contentErr *ContentError
+			if !errors.As(err, &contentErr) || !errors.Is(err, ErrContentRejected) {
+				t.Fatalf("got %v, want a content error", err)
+			}
+			if contentErr.Reason != "mismatch" || contentErr.Detected.Name != test.detected {
+				t.Fatalf("refused as %q holding %q", contentErr.Reason, contentErr.Detected.Name)
+			}
+			if exists(rootPath(fm, "files/"+test.filename)) {
+				t.Fatal("refused file was written")
+			}
+		})
+	}
+}
+
+func TestContentPolicyRejectsStreamedMismatch(t *testing.T) {
+	fm := policyForTest(t, &ContentPolicy{VerifyContent: true})
+
+	err := fm.NewSecureFile(nil, "files", "photo.png").SaveEncryptedFrom(bytes.NewReader([]byte("MZ\x90\x00 executable")))
+	if !errors.Is(err, ErrContentRejected) {
+		t.Fatalf("got %v, want ErrContentRejected", err)
+	}
+	if exists(rootPath(fm, "files/photo.png")) {
+		t.Fatal("refused file was written")
+	}
+
+	data := pngForTest(t, 4, 4)
+	if err := fm.NewSecureFile(nil, "files", "photo.png").SaveEncryptedFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := fm.LoadSecureFileFromDisk("files", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Data, data) {
+		t.Fatal("streamed content was not kept whole")
+	}
+}
+
+func TestContentPolicyRules(t *testing.T) {
+	fm := policyForTest(t, &ContentPolicy{Rules: []ContentRule{
+		{Deny: []string{"executable"}},
+		{Dir: "images", Allow: []string{"image"}, Deny: []string{"svg"}},
+		{Dir: "images/raw", Allow: []string{"document", "image"}},
+	}})
+	png := pngForTest(t, 4, 4)
+
+	tests := []struct {
+		dir      string
+		filename string
+		data     []byte
+		reason   string // Reason of the refusal, empty when accepted
+	}{
+		{"files", "notes.txt", []byte("plain text"), ""},
+		{"files", "tool.exe", []byte("MZ\x90\x00"), "denied"},
+		{"files", "tool.bin", []byte("MZ\x90\x00"), "denied"},
+		{"images", "photo.png", png, ""},
+		{"images", "notes.txt", []byte("plain text"), "not allowed"},
+		{"images", "data.bin", []byte{0x00, 0x01}, "not allowed"},
+		{"images", "logo.svg", []byte("<svg></svg>"), "denied"},
+		{"images/raw", "notes.txt", []byte("plain text"), ""},
+		{"images/raw/nested", "photo.png", png, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.dir+"/"+test.filename, func(t *testing.T) {
+			_, err := fm.SaveDataAsSecureFile(test.data, test.dir, test.filename)
+			if test.reason == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			var contentErr *ContentError
+			if !errors.As(err, &contentErr) {
+				t.Fatalf("got %v, want a content error", err)
+			}
+			if contentErr.Reason != test.reason {
+				t.Fatalf("refused as %q, want %q", contentErr.Reason, test.reason)
+			}
+		})
+	}
+}
+
+func TestContentPolicyValidate(t *testing.T) {
+	config := DefaultConfig()
+	config.EncryptionKey = "test key of exactly thirty-two b"
+	config.ContentPolicy = &ContentPolicy{Rules: []ContentRule{{Dir: "images", Allow: []string{"picture"}}}}
+	if err := config.Validate(); err == nil {
+		t.Fatal("policy naming an unknown type was accepted")
+	}
+
+	config.ContentPolicy.Rules[0].Allow = []string{"image", "PDF"}
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
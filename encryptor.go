@@ -3,7 +3,10 @@ package sealfile
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 )
@@ -13,6 +16,7 @@ type Encryptor struct {
 	key       []byte
 	cipherKey cipher.Block
 	cipherGCM cipher.AEAD
+	keyCheck  []byte
 }
 
 // NewEncryptor creates a new Encryptor with the provided key
@@ -31,9 +35,20 @@ func NewEncryptor(key string) (*Encryptor, error) {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
 
+	// The key check value identifies the key without revealing it
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte("sealfile key check"))
+	e.keyCheck = mac.Sum(nil)[:streamKeyCheck]
+
 	return e, nil
 }
 
+// KeyCheck returns the key check value of the key as a hex string.
+// It is stored with sealed data to recognize the key and reveals nothing about it.
+func (e *Encryptor) KeyCheck() string {
+	return hex.EncodeToString(e.keyCheck)
+}
+
 // setKey pads or truncates the key to valid AES key sizes (16, 24, or 32 bytes)
 func (e *Encryptor) setKey(key string) {
 	keyBytes := []byte(key)
@@ -62,7 +77,7 @@ func (e *Encryptor) Encrypt(data []byte) ([]byte, error) {
 func (e *Encryptor) Decrypt(encryptedData []byte) ([]byte, error) {
 	nonceSize := e.cipherGCM.NonceSize()
 	if len(encryptedData) < nonceSize {
-		return nil, fmt.Errorf("encrypted data too short")
+		return nil, mark(ErrCorrupted, fmt.Errorf("encrypted data too short"))
 	}
 
 	nonce := encryptedData[:nonceSize]
@@ -70,8 +85,24 @@ func (e *Encryptor) Decrypt(encryptedData []byte) ([]byte, error) {
 
 	decrypted, err := e.cipherGCM.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decrypt data: %w", err)
+		return nil, mark(ErrAuthenticationFailed, fmt.Errorf("failed to decrypt data: %w", err))
 	}
 
 	return decrypted, nil
 }
+
+// decryptChecked decrypts whole-file data sealed under keyCheck, telling a wrong key from
+// corrupted data. Data of earlier versions carries no key check and is only decrypted.
+func (e *Encryptor) decryptChecked(encryptedData, keyCheck []byte) ([]byte, error) {
+	if keyCheck == nil {
+		return e.Decrypt(encryptedData)
+	}
+	if !hmac.Equal(keyCheck, e.keyCheck) {
+		return nil, mark(ErrWrongKey, mark(ErrAuthenticationFailed, fmt.Errorf("sealed with a different key")))
+	}
+	decrypted, err := e.Decrypt(encryptedData)
+	if err != nil {
+		return nil, mark(ErrCorrupted, err)
+	}
+	return decrypted, nil
+}
@@ -0,0 +1,150 @@
+package sealfile
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Errors reported by file operations. They are wrapped with context, test for them with errors.Is.
+var (
+	// ErrNotFound is reported when a file does not exist, it also matches fs.ErrNotExist
+	ErrNotFound = errors.New("file not found")
+	// ErrAlreadyExists is reported when a file would be overwritten without permission
+	ErrAlreadyExists = errors.New("file already exists")
+	// ErrRevisionMismatch is reported when a file changed since the revision a save depends on
+	ErrRevisionMismatch = errors.New("file changed since it was loaded")
+	// ErrAuthenticationFailed is reported when sealed data does not authenticate with the key.
+	// Data carrying a key check also reports ErrWrongKey or ErrCorrupted.
+	ErrAuthenticationFailed = errors.New("authentication failed")
+	// ErrWrongKey is reported when data was sealed with another key, it also matches ErrAuthenticationFailed
+	ErrWrongKey = errors.New("wrong key")
+	// ErrCorrupted is reported when sealed data is truncated or malformed
+	ErrCorrupted = errors.New("sealed data corrupted")
+	// ErrUnsupportedFormat is reported when a file is not sealed in a format this package reads
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	// ErrPathEscape is returned when a path resolves outside of Config.RootDir
+	ErrPathEscape = errors.New("path escapes the root directory")
+	// ErrTenantMismatch is returned when a file was sealed for another tenant
+	ErrTenantMismatch = errors.New("file belongs to another tenant")
+	// ErrInvalidTenant is returned for tenant identifiers that cannot name a directory
+	ErrInvalidTenant = errors.New("invalid tenant id")
+	// ErrPermissionDenied is returned when the Authorizer of a FileManager refuses an operation
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrContentRejected is returned when the ContentPolicy refuses a file, see ContentError
+	ErrContentRejected = errors.New("content rejected")
+	// ErrScanRejected is returned when the Scanner rejects or quarantines a file, see ScanError
+	ErrScanRejected = errors.New("rejected by scanner")
+	// ErrURLSignature is returned for URLs whose signature or constraints do not verify
+	ErrURLSignature = errors.New("invalid URL signature")
+	// ErrURLExpired is returned for signed URLs past their expiry
+	ErrURLExpired = errors.New("signed URL expired")
+	// ErrURLUsed is returned for one-time URLs that were already used
+	ErrURLUsed = errors.New("signed URL already used")
+)
+
+// SealError records a failed operation and the file it failed on
+type SealError struct {
+	Op   string // Operation, such as "seal", "unseal", "open", "delete", "copy" or "rekey"
+	Path string
+	Err  error
+}
+
+// Error returns the operation, the path and the cause
+func (e *SealError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause
+func (e *SealError) Unwrap() error {
+	return e.Err
+}
+
+// Is maps file system errors of the cause onto the package errors
+func (e *SealError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return errors.Is(e.Err, fs.ErrNotExist)
+	case ErrAlreadyExists:
+		return errors.Is(e.Err, fs.ErrExist)
+	}
+	return false
+}
+
+// newSealError wraps err into a SealError, unless it already carries one
+func newSealError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var sealErr *SealError
+	if errors.As(err, &sealErr) {
+		return err
+	}
+	return &SealError{Op: op, Path: path, Err: err}
+}
+
+// markedError classifies an error with a package error without changing its message
+type markedError struct {
+	err  error
+	kind error
+}
+
+// mark classifies err as kind
+func mark(kind, err error) error {
+	return &markedError{err: err, kind: kind}
+}
+
+// Error returns the message of the classified error
+func (e *markedError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns both the classified error and its class
+func (e *markedError) Unwrap() []error {
+	return []error{e.err, e.kind}
+}
+
+// errorKinds names the package errors, more specific errors first
+var errorKinds = []struct {
+	err  error
+	kind string
+}{
+	{ErrNotFound, "not_found"},
+	{ErrAlreadyExists, "already_exists"},
+	{ErrRevisionMismatch, "revision_mismatch"},
+	{ErrWrongKey, "wrong_key"},
+	{ErrCorrupted, "corrupted"},
+	{ErrAuthenticationFailed, "authentication_failed"},
+	{ErrUnsupportedFormat, "unsupported_format"},
+	{ErrPathEscape, "path_escape"},
+	{ErrTenantMismatch, "tenant_mismatch"},
+	{ErrInvalidTenant, "invalid_tenant"},
+	{ErrPermissionDenied, "permission_denied"},
+	{ErrContentRejected, "content_rejected"},
+	{ErrScanRejected, "scan_rejected"},
+	{ErrBatchAborted, "aborted"},
+	{ErrBatchRolledBack, "rolled_back"},
+	{ErrJobNotFound, "job_not_found"},
+	{ErrJobLocked, "job_locked"},
+	{ErrResponseTooLarge, "response_too_large"},
+}
+
+// ErrorKind returns a stable name for the package error err matches, such as "not_found",
+// or "other". It suits machine-readable output; APIError maps the names back.
+func ErrorKind(err error) string {
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return kind.kind
+		}
+	}
+	return "other"
+}
+
+// errorOfKind returns the package error named kind, or nil
+func errorOfKind(kind string) error {
+	for _, known := range errorKinds {
+		if known.kind == kind {
+			return known.err
+		}
+	}
+	return nil
+}
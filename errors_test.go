@@ -0,0 +1,24 @@
+package sealfile
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestErrorKindsRoundTrip(t *testing.T) {
+	for _, known := range errorKinds {
+		wrapped := fmt.Errorf("context: %w", known.err)
+		if kind := ErrorKind(wrapped); kind != known.kind {
+			t.Errorf("ErrorKind(%v) = %q, want %q", known.err, kind, known.kind)
+		}
+		if err := errorOfKind(known.kind); err != known.err {
+			t.Errorf("errorOfKind(%q) = %v, want %v", known.kind, err, known.err)
+		}
+	}
+	if kind := ErrorKind(ErrBatchAborted); kind != "aborted" {
+		t.Errorf("ErrorKind(ErrBatchAborted) = %q", kind)
+	}
+	if kind := ErrorKind(fmt.Errorf("unrelated")); kind != "other" {
+		t.Errorf("unrelated error has kind %q", kind)
+	}
+}
@@ -1,17 +1,76 @@
 package sealfile
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-// FileManager manages secure file operations
+// FileManager manages secure file operations.
+// It is safe for concurrent use; configuration updates are swapped in atomically.
 type FileManager struct {
-	config     *Config
-	encryptor  *Encryptor
+	snapshot   atomic.Pointer[configSnapshot]
+	updateMu   sync.Mutex
 	compressor *Compressor
+	nonces     *atomic.Pointer[NonceStore] // Shared with pinned copies
+	authorizer *atomic.Pointer[Authorizer] // Shared with pinned copies, nil when every operation is allowed
+	scanner    *atomic.Pointer[Scanner]    // Shared with pinned copies, nil when files are not scanned
+	ctx        context.Context             // Operations are authorized for its principal
+	unchecked  bool                        // Skips authorization, content checks and scans, see internal
+	presigned  bool                        // Skips authorization only, see signed
+}
+
+// configSnapshot is an immutable configuration together with the keys derived from it.
+// Operations load the snapshot once and finish on it, even if the configuration is updated meanwhile.
+type configSnapshot struct {
+	config      *Config
+	encryptor   *Encryptor
+	previous    []*Encryptor
+	journalDir  string   // JournalDir resolved inside the root directory
+	signingKeys [][]byte // Signs URLs with the first key and verifies them with any
+}
+
+// newConfigSnapshot copies config and derives its keys, reusing encryptors of base whose key did not change
+func newConfigSnapshot(config *Config, base *configSnapshot) (*configSnapshot, error) {
+	config = config.clone()
+
+	var encryptor *Encryptor
+	if base != nil && base.config.EncryptionKey == config.EncryptionKey {
+		encryptor = base.encryptor
+	} else {
+		var err error
+		encryptor, err = NewEncryptor(config.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create encryptor: %w", err)
+		}
+	}
+
+	previous, err := newPreviousEncryptors(config.PreviousKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &configSnapshot{
+		config:      config,
+		encryptor:   encryptor,
+		previous:    previous,
+		signingKeys: newSigningKeys(config, encryptor, previous),
+	}
+	if config.JournalDir != "" {
+		if snapshot.journalDir, err = snapshot.resolveDir(config.JournalDir); err != nil {
+			return nil, fmt.Errorf("invalid journal directory: %w", err)
+		}
+	}
+	return snapshot, nil
 }
 
 // FileOperation represents a file operation for batch processing
@@ -20,6 +79,8 @@ type FileOperation struct {
 	Path     string
 	Filename string
 	Error    error
+	Attempts int  // Number of attempts made when the batch retries failed items
+	Existed  bool // Whether the file existed before a batch encryption
 }
 
 // CopyOptions defines options for file copying
@@ -46,6 +107,9 @@ type CopyResult struct {
 	DestFilename   string
 	Success        bool
 	Error          error
+	Attempts       int   // Number of attempts made when the batch retries failed items
+	DestExisted    bool  // Whether the destination existed before the copy
+	Bytes          int64 // Bytes written to the destination, or that would be written on a dry run
 }
 
 // NewFileManager creates a new FileManager instance
@@ -54,23 +118,69 @@ func NewFileManager(config *Config) (*FileManager, error) {
 		config = DefaultConfig()
 	}
 
-	encryptor, err := NewEncryptor(config.EncryptionKey)
+	snapshot, err := newConfigSnapshot(config, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create encryptor: %w", err)
+		return nil, err
+	}
+
+	// Refuse keys that do not match a store sealed with another key
+	if err := snapshot.verifyKey(); err != nil {
+		return nil, err
 	}
 
 	fm := &FileManager{
-		config:     config,
-		encryptor:  encryptor,
 		compressor: NewCompressor(),
+		nonces:     &atomic.Pointer[NonceStore]{},
+		authorizer: &atomic.Pointer[Authorizer]{},
+		scanner:    &atomic.Pointer[Scanner]{},
 	}
-
+	fm.SetNonceStore(NewMemoryNonceStore())
+	fm.snapshot.Store(snapshot)
 	return fm, nil
 }
 
+// newPreviousEncryptors creates encryptors for the keys of earlier rotations
+func newPreviousEncryptors(keys []string) ([]*Encryptor, error) {
+	previous := make([]*Encryptor, 0, len(keys))
+	for _, key := range keys {
+		encryptor, err := NewEncryptor(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create encryptor for previous key: %w", err)
+		}
+		previous = append(previous, encryptor)
+	}
+	return previous, nil
+}
+
+// current returns the active configuration snapshot
+func (fm *FileManager) current() *configSnapshot {
+	return fm.snapshot.Load()
+}
+
+// pinned returns a FileManager bound to the active snapshot, so that a multi-step
+// operation is not affected by configuration updates while it runs
+func (fm *FileManager) pinned() *FileManager {
+	return fm.withSnapshot(fm.current())
+}
+
+// withSnapshot returns a FileManager bound to the given snapshot
+func (fm *FileManager) withSnapshot(snapshot *configSnapshot) *FileManager {
+	pinned := &FileManager{
+		compressor: fm.compressor,
+		nonces:     fm.nonces,
+		authorizer: fm.authorizer,
+		scanner:    fm.scanner,
+		ctx:        fm.ctx,
+		unchecked:  fm.unchecked,
+		presigned:  fm.presigned,
+	}
+	pinned.snapshot.Store(snapshot)
+	return pinned
+}
+
 // NewSecureFile creates a new SecureFile instance
 func (fm *FileManager) NewSecureFile(data []byte, path, filename string) *SecureFile {
-	return newSecureFile(data, path, filename, fm.config, fm.encryptor, fm.compressor)
+	return newSecureFile(data, path, filename, fm)
 }
 
 // LoadSecureFileFromDisk loads a secure file from disk
@@ -97,190 +207,394 @@ func (fm *FileManager) SaveDataAsSecureFile(data []byte, path, filename string)
 	return sf, nil
 }
 
-// GetConfig returns the current configuration
+// SaveStreamAsSecureFile seals everything read from r into a secure file without buffering it
+func (fm *FileManager) SaveStreamAsSecureFile(r io.Reader, path, filename string) (*SecureFile, error) {
+	sf := fm.NewSecureFile(nil, path, filename)
+	if err := sf.SaveEncryptedFrom(r); err != nil {
+		return nil, err
+	}
+	return sf, nil
+}
+
+// GetConfig returns a copy of the current configuration.
+// Changes to the copy take effect only when passed to UpdateConfig.
 func (fm *FileManager) GetConfig() *Config {
-	return fm.config
+	return fm.current().config.clone()
+}
+
+// TempDir returns Config.TempDir resolved inside the root directory, creating it when needed
+func (fm *FileManager) TempDir() (string, error) {
+	dir, err := fm.current().resolveDir(fm.current().config.TempDir)
+	if err != nil {
+		return "", err
+	}
+	if err := EnsureDirectory(dir); err != nil {
+		return "", err
+	}
+	return dir, nil
 }
 
-// UpdateConfig updates the configuration (creates new encryptor if key changed)
+// UpdateConfig atomically replaces the configuration (creates new encryptor if key changed).
+// Operations already running finish with the configuration they started with.
+// A configuration whose keys do not match the store marker of the public directory is rejected.
 func (fm *FileManager) UpdateConfig(config *Config) error {
-	if config.EncryptionKey != fm.config.EncryptionKey {
-		encryptor, err := NewEncryptor(config.EncryptionKey)
-		if err != nil {
-			return fmt.Errorf("failed to create new encryptor: %w", err)
-		}
-		fm.encryptor = encryptor
+	if config == nil {
+		return fmt.Errorf("config must not be nil")
 	}
-	fm.config = config
+
+	fm.updateMu.Lock()
+	defer fm.updateMu.Unlock()
+
+	snapshot, err := newConfigSnapshot(config, fm.current())
+	if err != nil {
+		return err
+	}
+	if err := snapshot.verifyKey(); err != nil {
+		return err
+	}
+	fm.snapshot.Store(snapshot)
 	return nil
 }
 
-// CreateMultipleEncryptedFiles creates multiple encrypted files from a list of file operations
-func (fm *FileManager) CreateMultipleEncryptedFiles(operations []FileOperation, maxConcurrency int) []FileOperation {
-	if maxConcurrency <= 0 {
-		maxConcurrency = 5
+// RekeyFile re-encrypts a file sealed with one of the previous keys using the current key.
+// Files that already open with the current key are left untouched.
+func (fm *FileManager) RekeyFile(path, filename string) error {
+	fm = fm.pinned()
+	encryptor, reader, err := fm.openWithAnyKey(path, filename)
+	if err != nil {
+		return newSealError("rekey", fm.NewSecureFile(nil, path, filename).GetFullPath(), err)
 	}
+	defer func() { _ = reader.Close() }()
 
-	results := make([]FileOperation, len(operations))
-	copy(results, operations)
+	if encryptor == fm.current().encryptor {
+		return nil
+	}
 
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, maxConcurrency)
+	sf := fm.NewSecureFile(nil, path, filename)
+	if err := sf.SaveEncryptedFrom(reader); err != nil {
+		return fmt.Errorf("failed to rekey file: %w", err)
+	}
+	return nil
+}
 
-	for i := range results {
-		wg.Add(1)
-		go func(index int) {
-			defer wg.Done()
-			semaphore <- struct{}{}        // Acquire semaphore
-			defer func() { <-semaphore }() // Release semaphore
+// openWithAnyKey opens a file with the first key able to decrypt it, current key first
+func (fm *FileManager) openWithAnyKey(path, filename string) (*Encryptor, io.ReadCloser, error) {
+	snapshot := fm.current()
+	encryptors := append([]*Encryptor{snapshot.encryptor}, snapshot.previous...)
 
-			op := &results[index]
-			sf := fm.NewSecureFile(op.Data, op.Path, op.Filename)
+	var lastErr error
+	for _, encryptor := range encryptors {
+		keyed := fm.withSnapshot(&configSnapshot{config: snapshot.config, encryptor: encryptor})
+		sf := keyed.NewSecureFile(nil, path, filename)
+		reader, err := sf.OpenDecrypted()
+		if err != nil {
+			lastErr = err
+			continue
+		}
 
-			if err := sf.SaveEncrypted(); err != nil {
-				op.Error = fmt.Errorf("failed to encrypt and save file %s: %w", op.Filename, err)
-			} else {
-				op.Error = nil // Success
-			}
-		}(i)
+		return encryptor, reader, nil
 	}
+	return nil, nil, lastErr
+}
 
-	wg.Wait()
-	return results
+// CreateMultipleEncryptedFiles creates multiple encrypted files from a list of file operations
+func (fm *FileManager) CreateMultipleEncryptedFiles(operations []FileOperation, maxConcurrency int) []FileOperation {
+	return NewBatchProcessor(fm, maxConcurrency).EncryptOperations(operations)
 }
 
 // DecryptMultipleFiles decrypts multiple files from a list of file operations
 func (fm *FileManager) DecryptMultipleFiles(operations []FileOperation, maxConcurrency int) []FileOperation {
-	if maxConcurrency <= 0 {
-		maxConcurrency = 5
+	return NewBatchProcessor(fm, maxConcurrency).DecryptOperations(operations)
+}
+
+// CopyFileToNewLocation copies a file to a new location with optional decryption
+func (fm *FileManager) CopyFileToNewLocation(sourcePath, sourceFilename, destPath, destFilename string, options CopyOptions) error {
+	fm = fm.pinned()
+	operation := CopyOperation{
+		SourcePath:     sourcePath,
+		SourceFilename: sourceFilename,
+		DestPath:       destPath,
+		DestFilename:   destFilename,
+		Options:        options,
 	}
+	_, _, err := fm.copyFile(operation, false)
+	return fm.copyError(operation, err)
+}
 
-	results := make([]FileOperation, len(operations))
-	copy(results, operations)
+// copyError wraps the error of a copy into a SealError naming the source file
+func (fm *FileManager) copyError(operation CopyOperation, err error) error {
+	if err == nil {
+		return nil
+	}
+	_, sourceFullPath, resolveErr := fm.current().resolveFile(operation.SourcePath, operation.SourceFilename)
+	if resolveErr != nil {
+		sourceFullPath = filepath.Join(operation.SourcePath, operation.SourceFilename)
+	}
+	return newSealError("copy", sourceFullPath, err)
+}
+
+// copyFile performs a copy, or only validates it on a dry run. It reports whether the
+// destination already existed and the number of bytes written to it.
+func (fm *FileManager) copyFile(operation CopyOperation, dryRun bool) (bool, int64, error) {
+	options := operation.Options
+	destFullPath, destExists, err := fm.prepareCopyDestination(operation.DestPath, operation.DestFilename, options, dryRun)
+	if err != nil {
+		return destExists, 0, err
+	}
 
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, maxConcurrency)
+	data, err := fm.readCopySource(operation.SourcePath, operation.SourceFilename, options)
+	if err != nil {
+		return destExists, 0, err
+	}
 
-	for i := range results {
-		wg.Add(1)
-		go func(index int) {
-			defer wg.Done()
-			semaphore <- struct{}{}        // Acquire semaphore
-			defer func() { <-semaphore }() // Release semaphore
+	if dryRun {
+		return destExists, int64(len(data)), nil
+	}
 
-			op := &results[index]
-			sf, err := fm.LoadSecureFileFromDisk(op.Path, op.Filename)
-			if err != nil {
-				op.Error = fmt.Errorf("failed to decrypt file %s: %w", op.Filename, err)
-			} else {
-				op.Data = sf.Data
-				op.Error = nil
-			}
-		}(i)
+	// Without overwriting, a destination created since it was checked is never replaced
+	if _, err := fm.current().config.Retry.DoContext(fm.Context(), func() error {
+		return writeFileRouted(destFullPath, !options.OverwriteExisting, func(w io.Writer) (string, error) {
+			_, err := w.Write(data)
+			return destFullPath, err
+		})
+	}); err != nil {
+		if options.DecryptBeforeCopy {
+			return destExists, 0, fmt.Errorf("failed to write unencrypted file: %w", err)
+		}
+		return destExists, 0, fmt.Errorf("failed to write encrypted file: %w", err)
 	}
 
-	wg.Wait()
-	return results
+	return destExists, int64(len(data)), nil
 }
 
-// CopyFileToNewLocation copies a file to a new location with optional decryption
-func (fm *FileManager) CopyFileToNewLocation(sourcePath, sourceFilename, destPath, destFilename string, options CopyOptions) error {
+// prepareCopyDestination creates the destination directory and applies the overwrite option.
+// A dry run creates nothing and checks that the destination could be written instead.
+// It returns the resolved destination path and whether the destination already exists.
+func (fm *FileManager) prepareCopyDestination(destPath, destFilename string, options CopyOptions, dryRun bool) (string, bool, error) {
+	destDir, destFullPath, err := fm.current().resolveFile(destPath, destFilename)
+	if err != nil {
+		return "", false, err
+	}
+	if err := fm.authorize(AccessSave, destFullPath); err != nil {
+		return "", false, err
+	}
+
 	// Ensure destination directory exists if requested
-	if options.CreateDirectories {
-		if err := EnsureDirectory(destPath); err != nil {
-			return fmt.Errorf("failed to create destination directory: %w", err)
+	if options.CreateDirectories && !dryRun {
+		if err := EnsureDirectory(destDir); err != nil {
+			return destFullPath, false, fmt.Errorf("failed to create destination directory: %w", err)
 		}
 	}
 
-	destFullPath := filepath.Join(destPath, destFilename)
+	info, statErr := os.Stat(destFullPath)
+	exists := statErr == nil
 
 	// Check if destination exists and handle overwrite option
-	if !options.OverwriteExisting {
-		if _, err := os.Stat(destFullPath); err == nil {
-			return fmt.Errorf("destination file already exists: %s", destFullPath)
+	if exists && !options.OverwriteExisting {
+		return destFullPath, true, fmt.Errorf("destination %w: %s", ErrAlreadyExists, destFullPath)
+	}
+
+	if dryRun {
+		if exists && info.IsDir() {
+			return destFullPath, true, fmt.Errorf("destination is a directory: %s", destFullPath)
+		}
+		if err := checkWritableDirectory(destDir, options.CreateDirectories); err != nil {
+			return destFullPath, exists, err
 		}
 	}
 
-	if options.DecryptBeforeCopy {
-		return fm.copyWithDecryption(sourcePath, sourceFilename, destPath, destFilename)
+	return destFullPath, exists, nil
+}
+
+// readCopySource returns the bytes that a copy writes to its destination
+func (fm *FileManager) readCopySource(sourcePath, sourceFilename string, options CopyOptions) ([]byte, error) {
+	_, sourceFullPath, err := fm.current().resolveFile(sourcePath, sourceFilename)
+	if err != nil {
+		return nil, err
+	}
+	if err := fm.authorize(AccessCopy, sourceFullPath); err != nil {
+		return nil, err
 	}
 
-	return fm.copyEncryptedFile(sourcePath, sourceFilename, destPath, destFilename)
+	if options.DecryptBeforeCopy {
+		return fm.readDecryptedSource(sourcePath, sourceFilename)
+	}
+	return fm.readEncryptedSource(sourcePath, sourceFilename)
 }
 
-// copyWithDecryption decrypts the file and saves the unencrypted version
-func (fm *FileManager) copyWithDecryption(sourcePath, sourceFilename, destPath, destFilename string) error {
-	// Load and decrypt source file
+// readDecryptedSource loads and decrypts the source file of a copy
+func (fm *FileManager) readDecryptedSource(sourcePath, sourceFilename string) ([]byte, error) {
 	sourceFile, err := fm.LoadSecureFileFromDisk(sourcePath, sourceFilename)
 	if err != nil {
-		return fmt.Errorf("failed to load source file: %w", err)
+		return nil, fmt.Errorf("failed to load source file: %w", err)
 	}
+	return sourceFile.Data, nil
+}
 
-	// Write unencrypted data to destination
-	destFullPath := filepath.Join(destPath, destFilename)
-	if err := os.WriteFile(destFullPath, sourceFile.Data, 0644); err != nil {
-		return fmt.Errorf("failed to write unencrypted file: %w", err)
+// readEncryptedSource reads the source file of a copy as-is
+func (fm *FileManager) readEncryptedSource(sourcePath, sourceFilename string) ([]byte, error) {
+	_, sourceFullPath, err := fm.current().resolveFile(sourcePath, sourceFilename)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	var data []byte
+	_, err = fm.current().config.Retry.DoContext(fm.Context(), func() error {
+		var readErr error
+		data, readErr = os.ReadFile(sourceFullPath)
+		return readErr
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file: %w", err)
+	}
+	return data, nil
 }
 
-// copyEncryptedFile copies the encrypted file as-is
-func (fm *FileManager) copyEncryptedFile(sourcePath, sourceFilename, destPath, destFilename string) error {
-	sourceFullPath := filepath.Join(sourcePath, sourceFilename)
-	destFullPath := filepath.Join(destPath, destFilename)
+// BatchCopyFiles copies multiple files to new locations with optional decryption
+func (fm *FileManager) BatchCopyFiles(copyOperations []CopyOperation, maxConcurrency int) []CopyResult {
+	return NewBatchProcessor(fm, maxConcurrency).CopyFiles(copyOperations)
+}
+
+// FileFormat identifies how a file on disk is sealed
+type FileFormat string
+
+const (
+	FormatStream  FileFormat = "stream"  // Sealed stream, the format of every new file
+	FormatLegacy  FileFormat = "legacy"  // Compressed whole-file format of earlier versions
+	FormatUnknown FileFormat = "unknown" // Not sealed by this package
+)
+
+// FileEntry describes a file listed by ListFiles
+type FileEntry struct {
+	Name     string
+	Size     int64
+	ModTime  time.Time
+	Format   FileFormat
+	KeyCheck string // Key check recorded in the header of the file, not authenticated
+}
+
+// ListFiles lists the files of a directory and how they are sealed, without decrypting them.
+// Temporary files of unfinished writes, thumbnails and the key check marker are left out.
+func (fm *FileManager) ListFiles(path string) ([]FileEntry, error) {
+	dir, err := fm.current().resolveDir(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := fm.authorize(AccessList, dir); err != nil {
+		return nil, newSealError("list", dir, err)
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, newSealError("list", dir, fmt.Errorf("failed to read directory: %w", err))
+	}
+
+	var entries []FileEntry
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if !dirEntry.Type().IsRegular() || name == KeyCheckFile || isTemporaryName(name) || isThumbnailName(name) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
 
-	// Read source file (encrypted)
-	data, err := os.ReadFile(sourceFullPath)
+		entry := FileEntry{Name: name, Size: info.Size(), ModTime: info.ModTime()}
+		entry.Format, entry.KeyCheck = detectFormat(filepath.Join(dir, name))
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// detectFormat reads the start of a file to tell its format and recorded key check
+func detectFormat(path string) (FileFormat, string) {
+	file, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to read source file: %w", err)
+		return FormatUnknown, ""
 	}
+	defer func() { _ = file.Close() }()
 
-	// Write to destination (still encrypted)
-	if err := os.WriteFile(destFullPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write encrypted file: %w", err)
+	header, err := readStreamHeader(file)
+	if err == nil {
+		return FormatStream, hex.EncodeToString(header.keyCheck)
 	}
 
-	return nil
+	head := make([]byte, 2)
+	if _, err := file.ReadAt(head, 0); err == nil && head[0] == 0x1f && head[1] == 0x8b {
+		header := make([]byte, 512)
+		n, _ := file.ReadAt(header, 0)
+		return FormatLegacy, hex.EncodeToString(gzipKeyCheck(header[:n]))
+	}
+	return FormatUnknown, ""
 }
 
-// BatchCopyFiles copies multiple files to new locations with optional decryption
-func (fm *FileManager) BatchCopyFiles(copyOperations []CopyOperation, maxConcurrency int) []CopyResult {
-	if maxConcurrency <= 0 {
-		maxConcurrency = 5
-	}
-
-	results := make([]CopyResult, len(copyOperations))
-
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, maxConcurrency)
-
-	for i, op := range copyOperations {
-		wg.Add(1)
-		go func(index int, operation CopyOperation) {
-			defer wg.Done()
-			semaphore <- struct{}{}        // Acquire semaphore
-			defer func() { <-semaphore }() // Release semaphore
-
-			err := fm.CopyFileToNewLocation(
-				operation.SourcePath,
-				operation.SourceFilename,
-				operation.DestPath,
-				operation.DestFilename,
-				operation.Options,
-			)
-
-			results[index] = CopyResult{
-				SourcePath:     operation.SourcePath,
-				SourceFilename: operation.SourceFilename,
-				DestPath:       operation.DestPath,
-				DestFilename:   operation.DestFilename,
-				Success:        err == nil,
-				Error:          err,
-			}
-		}(i, op)
-	}
-
-	wg.Wait()
-	return results
+// isTemporaryName reports whether name belongs to a temporary file of an atomic write or a batch
+func isTemporaryName(name string) bool {
+	return strings.HasPrefix(name, ".") && (strings.Contains(name, ".tmp-") || strings.Contains(name, ".stage-")) ||
+		strings.Contains(name, ".bak-")
+}
+
+// MoveFile moves a sealed file. Sealed files do not depend on their location, so the
+// file is renamed when possible and copied otherwise. With DecryptBeforeCopy the
+// destination receives the plaintext and the sealed source is removed.
+func (fm *FileManager) MoveFile(sourcePath, sourceFilename, destPath, destFilename string, options CopyOptions) error {
+	fm = fm.pinned()
+	operation := CopyOperation{
+		SourcePath:     sourcePath,
+		SourceFilename: sourceFilename,
+		DestPath:       destPath,
+		DestFilename:   destFilename,
+		Options:        options,
+	}
+
+	_, sourceFullPath, err := fm.current().resolveFile(sourcePath, sourceFilename)
+	if err != nil {
+		return newSealError("move", filepath.Join(sourcePath, sourceFilename), err)
+	}
+	// Moving a file away copies it and deletes the source
+	for _, op := range []AccessOp{AccessCopy, AccessDelete} {
+		if err := fm.authorize(op, sourceFullPath); err != nil {
+			return newSealError("move", sourceFullPath, err)
+		}
+	}
+
+	if !options.DecryptBeforeCopy {
+		destFullPath, _, err := fm.prepareCopyDestination(destPath, destFilename, options, false)
+		if err != nil {
+			return newSealError("move", sourceFullPath, err)
+		}
+		// Without overwriting, a destination created since it was checked is never replaced
+		move := os.Rename
+		if !options.OverwriteExisting {
+			move = renameExclusive
+		}
+		if err := move(sourceFullPath, destFullPath); err == nil {
+			fm.current().moveThumbnails(sourceFullPath, destFullPath)
+			return nil
+		} else if errors.Is(err, ErrAlreadyExists) {
+			return newSealError("move", sourceFullPath, fmt.Errorf("destination %w", err))
+		} else if !errors.Is(err, syscall.EXDEV) {
+			return newSealError("move", sourceFullPath, fmt.Errorf("failed to move file: %w", err))
+		}
+		// Moving across file systems falls back to copying
+	}
+
+	if _, _, err := fm.copyFile(operation, false); err != nil {
+		return newSealError("move", sourceFullPath, err)
+	}
+	if err := os.Remove(sourceFullPath); err != nil {
+		return newSealError("move", sourceFullPath, fmt.Errorf("failed to remove source file: %w", err))
+	}
+
+	// Thumbnails cannot follow a copy, sealed destinations get new ones
+	snapshot := fm.current()
+	if snapshot.wantsThumbnails(sourceFilename) {
+		fm.NewSecureFile(nil, sourcePath, sourceFilename).removeThumbnails(snapshot)
+	}
+	if !options.DecryptBeforeCopy && snapshot.wantsThumbnails(destFilename) {
+		// The file itself was moved, failing thumbnails do not fail the move
+		_ = fm.NewSecureFile(nil, destPath, destFilename).writeThumbnails(snapshot, nil)
+	}
+	return nil
 }
@@ -0,0 +1,79 @@
+package sealfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenameExclusive(t *testing.T) {
+	dir := t.TempDir()
+	source, target := filepath.Join(dir, "source"), filepath.Join(dir, "target")
+	if err := os.WriteFile(source, []byte("source"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(target, []byte("target"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// An existing target is kept and so is the source
+	if err := renameExclusive(source, target); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("got %v, want ErrAlreadyExists", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "target" || !exists(source) {
+		t.Fatalf("target holds %q, source exists %v", data, exists(source))
+	}
+
+	if err := os.Remove(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := renameExclusive(source, target); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "source" || exists(source) {
+		t.Fatalf("target holds %q, source exists %v", data, exists(source))
+	}
+}
+
+func TestMoveFileOverwrite(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if _, err := fm.SaveDataAsSecureFile([]byte(name), "files", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := fm.MoveFile("files", "a.txt", "files", "b.txt", CopyOptions{})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("got %v, want ErrAlreadyExists", err)
+	}
+	if loaded, err := fm.LoadSecureFileFromDisk("files", "b.txt"); err != nil || string(loaded.Data) != "b.txt" {
+		t.Fatalf("destination replaced: %v, %v", loaded, err)
+	}
+
+	if err := fm.MoveFile("files", "a.txt", "files", "b.txt", CopyOptions{OverwriteExisting: true}); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := fm.LoadSecureFileFromDisk("files", "b.txt"); err != nil || string(loaded.Data) != "a.txt" {
+		t.Fatalf("destination not replaced: %v, %v", loaded, err)
+	}
+	if exists(rootPath(fm, "files/a.txt")) {
+		t.Fatal("source left in place")
+	}
+
+	// Moves and copies to new files leave no temporary files behind
+	if err := fm.MoveFile("files", "b.txt", "files", "c.txt", CopyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.CopyFileToNewLocation("files", "c.txt", "files", "d.txt", CopyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(rootPath(fm, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("directory holds %d entries, want c.txt and d.txt", len(entries))
+	}
+}
@@ -0,0 +1,282 @@
+package sealfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetadataContentType is the metadata key holding the media type served for a file
+const MetadataContentType = "content_type"
+
+// sniffLen is the number of bytes used to detect the content type
+const sniffLen = 512
+
+// Handler returns an http.Handler serving decrypted files at the URLs built by GetURL.
+// Request paths are taken relative to the path of Config.BaseURL and map onto files
+// under PublicDir; paths leaving PublicDir and hidden files are not served. Add the
+// query parameter "download" to serve a file as an attachment and "thumbnail" with a
+// size to serve its thumbnail. Signed URLs are verified, see SecureFile.SignedURL.
+func (fm *FileManager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fm := fm.WithContext(r.Context())
+		if err := fm.verifySignedURL(r); err != nil {
+			writeSignatureError(w, err)
+			return
+		}
+		// A valid signature stands in for authorization, the signer was authorized when signing
+		if r.URL.Query().Get(signedSig) != "" {
+			fm = fm.signed()
+		}
+		sf, err := fm.fileForRequest(r.URL.Path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		name := sf.Filename
+		if value := r.URL.Query().Get("thumbnail"); value != "" {
+			size, err := strconv.Atoi(value)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			// Thumbnails are authorized through their image
+			if err := fm.authorize(AccessLoad, sf.GetFullPath()); err != nil {
+				writeFileError(w, r, err)
+				return
+			}
+			name = thumbnailDownloadName(sf.Filename, size)
+			sf = sf.thumbnailFile(size)
+		}
+		serveFile(w, r, sf, name, func() error { return fm.redeemSignedURL(r) })
+	})
+}
+
+// fileForRequest maps a request path onto a sealed file under PublicDir
+func (fm *FileManager) fileForRequest(requestPath string) (*SecureFile, error) {
+	snapshot := fm.current()
+
+	if base, err := url.Parse(snapshot.config.BaseURL); err == nil {
+		if basePath := strings.TrimSuffix(base.Path, "/"); basePath != "" {
+			if !strings.HasPrefix(requestPath, basePath+"/") {
+				return nil, ErrNotFound
+			}
+			requestPath = requestPath[len(basePath):]
+		}
+	}
+
+	rel := strings.TrimPrefix(requestPath, "/")
+	if rel == "" {
+		return nil, ErrNotFound
+	}
+	for _, segment := range strings.Split(rel, "/") {
+		// Hidden files include the key check marker and temporary files of unfinished writes
+		if segment == "" || segment == "." || segment == ".." || strings.HasPrefix(segment, ".") {
+			return nil, ErrNotFound
+		}
+	}
+
+	publicDir, err := snapshot.resolveDir(snapshot.config.PublicDir)
+	if err != nil {
+		return nil, err
+	}
+	fullPath, err := resolveWithin(publicDir, filepath.FromSlash(rel))
+	if err != nil {
+		return nil, err
+	}
+	return fm.NewSecureFile(nil, filepath.Dir(fullPath), filepath.Base(fullPath)), nil
+}
+
+// serveFile decrypts a sealed file into the response under the given name. Range
+// requests only decrypt the chunks covering the requested bytes. opened is called once
+// the file was opened for the response and refuses it with its error.
+func serveFile(w http.ResponseWriter, r *http.Request, sf *SecureFile, name string, opened func() error) {
+	// Authorized before the ETag, revalidations must not reveal changes to files the caller may not load.
+	// The ETag and the body come from the same open file, so a file replaced in between cannot
+	// pair the tag of one version with the content of another.
+	snapshot := sf.fm.current()
+	file, stream, err := sf.openFile(snapshot)
+	if err != nil {
+		writeFileError(w, r, err)
+		return
+	}
+	etag, err := sealedETag(file)
+	if err != nil {
+		_ = file.Close()
+		writeFileError(w, r, err)
+		return
+	}
+	// Answer revalidations before decrypting anything
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		_ = file.Close()
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	content, err := sf.decryptSeeker(snapshot, file, stream)
+	if err != nil {
+		writeFileError(w, r, err)
+		return
+	}
+	defer func() { _ = content.Close() }()
+	// One-time URLs are used up by responses carrying the content only
+	if err := opened(); err != nil {
+		writeSignatureError(w, err)
+		return
+	}
+
+	mediaType, err := contentType(sf, content)
+	if err != nil {
+		writeFileError(w, r, err)
+		return
+	}
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Content-Type", mediaType)
+	header.Set("Content-Disposition", contentDisposition(r, name, mediaType))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if activeContent(mediaType) {
+		header.Set("Content-Security-Policy", "sandbox")
+	}
+	header.Set("Cache-Control", "private, no-cache")
+
+	// ServeContent handles HEAD, Range and If-Range against the ETag
+	http.ServeContent(w, r, name, time.Time{}, content)
+}
+
+// sealedETag derives an entity tag from the sealed bytes of an open file. Nonces are
+// random, so the tag changes with every save even when the content stays the same.
+func sealedETag(file *os.File) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, 64)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	hash := sha256.New()
+	hash.Write(head[:n])
+	fmt.Fprintf(hash, "%d", info.Size())
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, nil
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// thumbnailDownloadName names a thumbnail after its image, such as "photo-256.jpg"
+func thumbnailDownloadName(filename string, size int) string {
+	ext := ".png"
+	if thumbnailContentType(filename) == "image/jpeg" {
+		ext = filepath.Ext(filename)
+	}
+	return fmt.Sprintf("%s-%d%s", GetFileNameWithoutExtension(filename), size, ext)
+}
+
+// contentType returns the media type from the metadata, the extension or the content, see DefaultTypeRegistry
+func contentType(sf *SecureFile, content io.ReadSeeker) (string, error) {
+	if value := sf.Metadata[MetadataContentType]; value != "" {
+		return value, nil
+	}
+	if value := mime.TypeByExtension(filepath.Ext(sf.Filename)); value != "" {
+		return value, nil
+	}
+	if fileType, ok := DefaultTypeRegistry.Lookup(sf.Filename); ok && fileType.MIMEType != "" {
+		return fileType.MIMEType, nil
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if fileType, ok := DefaultTypeRegistry.Sniff(head[:n]); ok && fileType.MIMEType != "" {
+		return fileType.MIMEType, nil
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// contentDisposition serves files inline unless a download was asked for or the
+// content could run scripts in the origin of the handler
+func contentDisposition(r *http.Request, filename, mediaType string) string {
+	disposition := "inline"
+	if _, ok := r.URL.Query()["download"]; ok || activeContent(mediaType) {
+		disposition = "attachment"
+	}
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); value != "" {
+		return value
+	}
+	return disposition
+}
+
+// activeContent reports whether browsers may run scripts of content of the media type,
+// as they do for HTML, SVG, XML and JavaScript
+func activeContent(mediaType string) bool {
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	switch mediaType {
+	case "text/html", "application/xhtml+xml", "image/svg+xml", "text/xml", "application/xml",
+		"text/javascript", "application/javascript", "application/x-javascript",
+		"text/ecmascript", "application/ecmascript", "text/xsl":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+xml")
+}
+
+// writeFileError maps a failed file operation onto an HTTP status
+func writeFileError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, fs.ErrNotExist), errors.Is(err, ErrPathEscape), errors.Is(err, ErrTenantMismatch):
+		http.NotFound(w, r)
+	case errors.Is(err, ErrPermissionDenied):
+		http.Error(w, "forbidden", http.StatusForbidden)
+	default:
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+	}
+}
+
+// writeSignatureError maps a rejected signed URL onto an HTTP status
+func writeSignatureError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrURLExpired):
+		http.Error(w, "link expired", http.StatusForbidden)
+	case errors.Is(err, ErrURLUsed):
+		http.Error(w, "link already used", http.StatusForbidden)
+	case errors.Is(err, ErrURLSignature):
+		http.Error(w, "invalid link", http.StatusForbidden)
+	default:
+		http.Error(w, "failed to verify link", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,98 @@
+package sealfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getForTest serves a GET request for path with the given headers
+func getForTest(fm *FileManager, path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	fm.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestHandlerRanges(t *testing.T) {
+	for _, streams := range []bool{false, true} {
+		fm := newTestFileManager(t, func(c *Config) { c.SealStreams = streams })
+		data := randomBytes(t, 2*streamChunkSize+100)
+		if _, err := fm.SaveDataAsSecureFile(data, "public/docs", "a.bin"); err != nil {
+			t.Fatal(err)
+		}
+
+		full := getForTest(fm, "/docs/a.bin", nil)
+		etag := full.Header().Get("ETag")
+		if full.Code != http.StatusOK || !bytes.Equal(full.Body.Bytes(), data) || etag == "" {
+			t.Fatalf("streams %v: full response %d with ETag %q", streams, full.Code, etag)
+		}
+
+		tests := []struct {
+			name    string
+			headers map[string]string
+			status  int
+			from    int // First byte of the expected body
+			to      int // Byte after the expected body
+		}{
+			{"range across chunks", map[string]string{"Range": fmt.Sprintf("bytes=%d-%d", streamChunkSize-5, streamChunkSize+4)}, http.StatusPartialContent, streamChunkSize - 5, streamChunkSize + 5},
+			{"suffix range", map[string]string{"Range": "bytes=-10"}, http.StatusPartialContent, len(data) - 10, len(data)},
+			{"open range", map[string]string{"Range": fmt.Sprintf("bytes=%d-", 2*streamChunkSize)}, http.StatusPartialContent, 2 * streamChunkSize, len(data)},
+			{"range past the end", map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(data))}, http.StatusRequestedRangeNotSatisfiable, 0, 0},
+			{"current If-Range", map[string]string{"Range": "bytes=0-9", "If-Range": etag}, http.StatusPartialContent, 0, 10},
+			{"stale If-Range", map[string]string{"Range": "bytes=0-9", "If-Range": `"stale"`}, http.StatusOK, 0, len(data)},
+			{"revalidation", map[string]string{"If-None-Match": etag}, http.StatusNotModified, 0, 0},
+			{"weak revalidation", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified, 0, 0},
+			{"stale revalidation", map[string]string{"If-None-Match": `"stale"`}, http.StatusOK, 0, len(data)},
+		}
+		for _, test := range tests {
+			w := getForTest(fm, "/docs/a.bin", test.headers)
+			if w.Code != test.status {
+				t.Fatalf("streams %v, %s: status %d, want %d", streams, test.name, w.Code, test.status)
+			}
+			if test.status == http.StatusRequestedRangeNotSatisfiable {
+				continue
+			}
+			if !bytes.Equal(w.Body.Bytes(), data[test.from:test.to]) {
+				t.Fatalf("streams %v, %s: got %d bytes, want bytes %d to %d", streams, test.name, w.Body.Len(), test.from, test.to)
+			}
+			if w.Header().Get("ETag") != etag {
+				t.Fatalf("streams %v, %s: ETag %q, want %q", streams, test.name, w.Header().Get("ETag"), etag)
+			}
+		}
+
+		// Saving the same content again changes the ETag
+		if _, err := fm.SaveDataAsSecureFile(data, "public/docs", "a.bin"); err != nil {
+			t.Fatal(err)
+		}
+		w := getForTest(fm, "/docs/a.bin", map[string]string{"If-None-Match": etag})
+		if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+			t.Fatalf("streams %v: after saving again %d with ETag %q", streams, w.Code, w.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestHandlerETagNeedsAuthorization(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	if _, err := fm.SaveDataAsSecureFile([]byte("secret"), "public/docs", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	etag := getForTest(fm, "/docs/a.txt", nil).Header().Get("ETag")
+
+	fm.SetAuthorizer(AuthorizerFunc(func(context.Context, AccessOp, string) error {
+		return errors.New("denied")
+	}))
+	for _, headers := range []map[string]string{nil, {"If-None-Match": etag}} {
+		w := getForTest(fm, "/docs/a.txt", headers)
+		if w.Code != http.StatusForbidden || w.Header().Get("ETag") != "" {
+			t.Fatalf("denied request answered %d with ETag %q", w.Code, w.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package api defines the routes and JSON messages of the sealfile HTTP API,
+// shared by the server in package sealfile and the client in package client.
+package api
+
+import "time"
+
+// Routes of the API
+const (
+	PathFile        = "/v1/file"  // PUT seals the body, GET unseals, DELETE deletes; ?path=&filename=
+	PathFiles       = "/v1/files" // GET lists a directory; ?path=
+	PathCopy        = "/v1/copy"
+	PathMove        = "/v1/move"
+	PathBatchSeal   = "/v1/batch/seal"
+	PathBatchUnseal = "/v1/batch/unseal"
+	PathBatchDelete = "/v1/batch/delete"
+	PathBatchCopy   = "/v1/batch/copy"
+)
+
+// MetadataHeader carries the metadata of a file as a JSON object
+const MetadataHeader = "Sealfile-Metadata"
+
+// Error is the body of a failed request and the error of a failed batch item
+type Error struct {
+	Kind    string `json:"kind"` // See sealfile.ErrorKind
+	Message string `json:"error"`
+}
+
+// Entry is a file listed by PathFiles
+type Entry struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Format   string    `json:"format"`
+	KeyCheck string    `json:"key_check,omitempty"`
+}
+
+// CopyOptions mirrors sealfile.CopyOptions, so that either converts into the other
+type CopyOptions struct {
+	DecryptBeforeCopy bool `json:"decrypt,omitempty"`
+	OverwriteExisting bool `json:"overwrite,omitempty"`
+	CreateDirectories bool `json:"create_directories,omitempty"`
+}
+
+// Copy is the body of PathCopy and PathMove and an item of PathBatchCopy
+type Copy struct {
+	SourcePath     string      `json:"source_path"`
+	SourceFilename string      `json:"source_filename"`
+	DestPath       string      `json:"dest_path"`
+	DestFilename   string      `json:"dest_filename"`
+	Options        CopyOptions `json:"options"`
+}
+
+// Operation is an item of the seal, unseal and delete batches
+type Operation struct {
+	Path     string `json:"path"`
+	Filename string `json:"filename"`
+	Data     []byte `json:"data,omitempty"`
+	Error    *Error `json:"error,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	Existed  bool   `json:"existed,omitempty"`
+}
+
+// CopyResult is a result of PathBatchCopy
+type CopyResult struct {
+	Copy
+	Success     bool   `json:"success"`
+	Error       *Error `json:"error,omitempty"`
+	Attempts    int    `json:"attempts,omitempty"`
+	DestExisted bool   `json:"dest_existed,omitempty"`
+	Bytes       int64  `json:"bytes,omitempty"`
+}
+
+// Batch is the body of the seal, unseal and delete batches and of their responses
+type Batch struct {
+	Concurrency int         `json:"concurrency,omitempty"` // Capped by the server
+	Operations  []Operation `json:"operations"`
+}
+
+// CopyBatch is the body of PathBatchCopy
+type CopyBatch struct {
+	Concurrency int    `json:"concurrency,omitempty"`
+	Operations  []Copy `json:"operations"`
+}
+
+// CopyBatchResult is the response of PathBatchCopy
+type CopyBatchResult struct {
+	Results []CopyResult `json:"results"`
+}
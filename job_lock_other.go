@@ -0,0 +1,26 @@
+//go:build !unix
+
+package sealfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// lockFile takes an exclusive lock on the file at path by creating it, the returned function
+// removes it again. A lock file left behind by a crash must be removed by hand.
+func lockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return nil, ErrJobLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+	return func() {
+		_ = file.Close()
+		_ = os.Remove(path)
+	}, nil
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package sealfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on the file at path, creating it when needed. The lock
+// is released by the returned function, or by the operating system when the process dies.
+func lockFile(path string) (func(), error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrJobLocked
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	// The lock file may have been removed and created again while it was being locked
+	locked, statErr := file.Stat()
+	current, err := os.Stat(path)
+	if statErr != nil || err != nil || !os.SameFile(locked, current) {
+		_ = file.Close()
+		return nil, ErrJobLocked
+	}
+	return func() { _ = file.Close() }, nil
+}
@@ -0,0 +1,659 @@
+package sealfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobOp identifies the work performed by a journaled job item
+type JobOp string
+
+const (
+	JobSeal   JobOp = "seal"   // Seal Data into Path/Filename
+	JobUnseal JobOp = "unseal" // Decrypt Path/Filename into DestPath/DestFilename
+	JobCopy   JobOp = "copy"   // Copy Path/Filename to DestPath/DestFilename according to Options
+	JobRekey  JobOp = "rekey"  // Re-encrypt Path/Filename with the current key
+	JobDelete JobOp = "delete" // Delete Path/Filename
+)
+
+// JobItemState is the state of a journaled job item
+type JobItemState string
+
+const (
+	JobPending JobItemState = "pending"
+	JobRunning JobItemState = "running"
+	JobDone    JobItemState = "done"
+	JobFailed  JobItemState = "failed"
+)
+
+const (
+	journalExtension = ".job"
+	payloadExtension = ".data"
+	lockExtension    = ".lock"
+)
+
+var (
+	// ErrJobNotFound is returned when a job has no journal
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobLocked is returned when a job is already run, by this or another process
+	ErrJobLocked = errors.New("job is already running")
+)
+
+// JobItem describes a single item of a journaled job
+type JobItem struct {
+	Op           JobOp       `json:"op"`
+	Path         string      `json:"path"`
+	Filename     string      `json:"filename"`
+	DestPath     string      `json:"dest_path,omitempty"`
+	DestFilename string      `json:"dest_filename,omitempty"`
+	Options      CopyOptions `json:"options"`
+	Data         []byte      `json:"-"` // Plaintext of seal items, kept sealed in the journal until applied
+}
+
+// JobItemStatus reports the outcome of a job item
+type JobItemStatus struct {
+	Item     JobItem
+	State    JobItemState
+	Error    error
+	Attempts int
+}
+
+// JobStatus reports the state of a journaled job
+type JobStatus struct {
+	ID      string
+	Created time.Time
+	Items   []JobItemStatus
+	Error   error // Why ListJobs could not read the journal, the job has no items then
+}
+
+// Done reports whether every item of the job completed successfully
+func (js *JobStatus) Done() bool {
+	if js.Error != nil {
+		return false
+	}
+	for _, item := range js.Items {
+		if item.State != JobDone {
+			return false
+		}
+	}
+	return true
+}
+
+// interrupted reports whether the job stopped while items were still pending or running
+func (js *JobStatus) interrupted() bool {
+	for _, item := range js.Items {
+		if item.State == JobPending || item.State == JobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// jobHeader is the first line of a journal file
+type jobHeader struct {
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Items   []JobItem `json:"items"`
+}
+
+// jobRecord is a state change appended to a journal file
+type jobRecord struct {
+	Item     int          `json:"item"`
+	State    JobItemState `json:"state"`
+	Error    string       `json:"error,omitempty"`
+	Attempts int          `json:"attempts,omitempty"`
+}
+
+// journal appends state changes of a job to its journal file
+type journal struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// record durably appends a state change
+func (j *journal) record(index int, state JobItemState, err error, attempts int) error {
+	rec := jobRecord{Item: index, State: state, Attempts: attempts}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	line, marshalErr := json.Marshal(rec)
+	if marshalErr != nil {
+		return fmt.Errorf("failed to encode journal record: %w", marshalErr)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	return nil
+}
+
+// newJobID returns a sortable, unique job identifier
+func newJobID() (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// journalPath returns the journal file of a job
+func (fm *FileManager) journalPath(jobID string) string {
+	return filepath.Join(fm.current().journalDir, jobID+journalExtension)
+}
+
+// payloadDir returns the directory holding the sealed payloads of a job
+func (fm *FileManager) payloadDir(jobID string) string {
+	return filepath.Join(fm.current().journalDir, jobID+payloadExtension)
+}
+
+// checkJobID refuses job identifiers that cannot name a journal
+func checkJobID(jobID string) error {
+	if strings.ContainsAny(jobID, `/\`) || jobID == "" || jobID == "." || jobID == ".." {
+		return fmt.Errorf("invalid job id %q", jobID)
+	}
+	return nil
+}
+
+// lockJob takes the lock that a job is run and removed under, failing with ErrJobLocked
+// while another run holds it. The lock of a job that has no journal yet is taken as well.
+func (fm *FileManager) lockJob(jobID string) (func(), error) {
+	if err := checkJobID(jobID); err != nil {
+		return nil, err
+	}
+	return lockFile(filepath.Join(fm.current().journalDir, jobID+lockExtension))
+}
+
+// readJob replays the journal of a job
+func (fm *FileManager) readJob(jobID string) (*JobStatus, error) {
+	status, _, err := fm.readJournal(jobID)
+	return status, err
+}
+
+// readJournal replays the journal of a job and returns the length of its complete records.
+// A record torn by a crash has no newline, it is ignored and is not part of that length.
+func (fm *FileManager) readJournal(jobID string) (*JobStatus, int64, error) {
+	if err := checkJobID(jobID); err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(fm.journalPath(jobID))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read journal header: %w", err)
+	}
+	complete := int64(len(line))
+
+	var header jobHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode journal header: %w", err)
+	}
+
+	status := &JobStatus{ID: header.ID, Created: header.Created, Items: make([]JobItemStatus, len(header.Items))}
+	for i, item := range header.Items {
+		status.Items[i] = JobItemStatus{Item: item, State: JobPending}
+	}
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read journal: %w", err)
+		}
+
+		var rec jobRecord
+		if err := json.Unmarshal(line, &rec); err != nil || rec.Item < 0 || rec.Item >= len(status.Items) {
+			return nil, 0, mark(ErrCorrupted, fmt.Errorf("corrupted journal record in job %s", jobID))
+		}
+		complete += int64(len(line))
+
+		item := &status.Items[rec.Item]
+		item.State = rec.State
+		item.Error = nil
+		if rec.Error != "" {
+			item.Error = errors.New(rec.Error)
+		}
+		if rec.Attempts > 0 {
+			item.Attempts = rec.Attempts
+		}
+	}
+
+	return status, complete, nil
+}
+
+// ListJobs returns the status of every job that still has a journal.
+// Jobs whose journal cannot be read are listed with their Error set.
+func (fm *FileManager) ListJobs() ([]*JobStatus, error) {
+	if fm.current().journalDir == "" {
+		return nil, nil
+	}
+
+	entries, err := os.ReadDir(fm.current().journalDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal directory: %w", err)
+	}
+
+	var jobs []*JobStatus
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, journalExtension) {
+			continue
+		}
+		jobID := strings.TrimSuffix(name, journalExtension)
+		status, err := fm.readJob(jobID)
+		if errors.Is(err, ErrJobNotFound) {
+			// Removed since the directory was read
+			continue
+		}
+		if err != nil {
+			status = &JobStatus{ID: jobID, Error: err}
+		}
+		jobs = append(jobs, status)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs, nil
+}
+
+// RemoveJob deletes the journal and payloads of a job.
+// It fails with ErrJobLocked while the job is running.
+func (fm *FileManager) RemoveJob(jobID string) error {
+	unlock, err := fm.lockJob(jobID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return fm.removeJob(jobID)
+}
+
+// removeJob deletes the journal, payloads and lock file of a job whose lock is held
+func (fm *FileManager) removeJob(jobID string) error {
+	if err := os.RemoveAll(fm.payloadDir(jobID)); err != nil {
+		return fmt.Errorf("failed to remove job payloads: %w", err)
+	}
+	if err := os.Remove(fm.journalPath(jobID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	_ = os.Remove(filepath.Join(fm.current().journalDir, jobID+lockExtension))
+	return nil
+}
+
+// ResumeAll resumes every job that stopped with pending or running items, such as jobs
+// interrupted by a restart, and returns their status. Items are authorized, checked, scanned
+// and cancelled like those of any other batch, so call it once the hooks of the FileManager
+// are installed. Jobs run by another process and jobs whose journal cannot be read are left alone.
+func (bp *BatchProcessor) ResumeAll() ([]*JobStatus, error) {
+	jobs, err := bp.fm.ListJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	var resumed []*JobStatus
+	for _, job := range jobs {
+		if job.Error != nil || !job.interrupted() {
+			continue
+		}
+		status, err := bp.Resume(job.ID)
+		if errors.Is(err, ErrJobLocked) || errors.Is(err, ErrJobNotFound) {
+			continue
+		}
+		if err != nil {
+			return resumed, err
+		}
+		resumed = append(resumed, status)
+	}
+	return resumed, nil
+}
+
+// StartJob journals the items and runs them. The journal is removed once every item
+// succeeded; otherwise the job can be resumed with Resume, also after a restart.
+func (bp *BatchProcessor) StartJob(items []JobItem) (*JobStatus, error) {
+	fm := bp.fm.pinned()
+	if bp.dryRun {
+		return bp.checkJob(fm, items), nil
+	}
+	if fm.current().journalDir == "" {
+		return nil, fmt.Errorf("journal directory is not configured")
+	}
+	if err := EnsureDirectory(fm.current().journalDir); err != nil {
+		return nil, err
+	}
+
+	jobID, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	// The job is locked before its journal exists, so no other process resumes it meanwhile
+	unlock, err := fm.lockJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	// Seal payloads before the journal exists, so a journal never refers to missing data
+	for i, item := range items {
+		if item.Op != JobSeal {
+			continue
+		}
+		if err := fm.sealJobPayload(jobID, i, item); err != nil {
+			_ = fm.removeJob(jobID)
+			return nil, err
+		}
+	}
+
+	header := jobHeader{ID: jobID, Created: time.Now().UTC(), Items: items}
+	line, err := json.Marshal(header)
+	if err != nil {
+		_ = fm.removeJob(jobID)
+		return nil, fmt.Errorf("failed to encode journal header: %w", err)
+	}
+	if err := writeFileAtomic(fm.journalPath(jobID), func(w io.Writer) error {
+		_, err := w.Write(append(line, '\n'))
+		return err
+	}); err != nil {
+		_ = fm.removeJob(jobID)
+		return nil, fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return bp.runJob(fm, jobID)
+}
+
+// sealJobPayload seals the data of a seal item into the journal. Payloads are moved into
+// place sealed, so their content is checked and scanned now and they are sealed under the
+// rules of their target filename, such as stripping image metadata.
+func (fm *FileManager) sealJobPayload(jobID string, index int, item JobItem) error {
+	snapshot := fm.current()
+	target := fm.NewSecureFile(item.Data, item.Path, item.Filename)
+	if _, fullPath, err := target.locate(snapshot); err == nil {
+		if err := target.screen(snapshot, fullPath); err != nil {
+			return newSealError("seal", fullPath, err)
+		}
+	}
+
+	if err := EnsureDirectory(fm.payloadDir(jobID)); err != nil {
+		return err
+	}
+	payload := filepath.Join(fm.payloadDir(jobID), strconv.Itoa(index))
+	if err := writeFileAtomic(payload, target.writeSealed(snapshot)); err != nil {
+		return fmt.Errorf("failed to journal payload of %s: %w", item.Filename, err)
+	}
+	return nil
+}
+
+// checkJob validates job items without journaling or running them.
+// Items that would fail are reported as failed, all others stay pending.
+func (bp *BatchProcessor) checkJob(fm *FileManager, items []JobItem) *JobStatus {
+	errs, _ := bp.run(len(items), nil, func(index int) error {
+		item := items[index]
+		var err error
+		switch item.Op {
+		case JobSeal:
+			_, err = fm.NewSecureFile(item.Data, item.Path, item.Filename).checkSave()
+		case JobUnseal, JobCopy:
+			options := item.Options
+			if item.Op == JobUnseal {
+				options.DecryptBeforeCopy = true
+			}
+			_, _, err = fm.copyFile(CopyOperation{
+				SourcePath:     item.Path,
+				SourceFilename: item.Filename,
+				DestPath:       item.DestPath,
+				DestFilename:   item.DestFilename,
+				Options:        options,
+			}, true)
+		case JobRekey:
+			var reader io.ReadCloser
+			if _, reader, err = fm.openWithAnyKey(item.Path, item.Filename); err == nil {
+				_ = reader.Close()
+			}
+		case JobDelete:
+			err = fm.NewSecureFile(nil, item.Path, item.Filename).checkDelete()
+		default:
+			err = fmt.Errorf("unknown job operation %q", item.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to %s file %s: %w", item.Op, item.Filename, err)
+		}
+		return nil
+	})
+
+	status := &JobStatus{Created: time.Now().UTC(), Items: make([]JobItemStatus, len(items))}
+	for i, item := range items {
+		status.Items[i] = JobItemStatus{Item: item, State: JobPending, Error: errs[i]}
+		if errs[i] != nil {
+			status.Items[i].State = JobFailed
+		}
+	}
+	return status
+}
+
+// Resume runs every item of a journaled job that has not completed yet.
+// Items are idempotent, so work finished just before an interruption is not repeated.
+// It fails with ErrJobLocked while the job is running, in this or another process.
+func (bp *BatchProcessor) Resume(jobID string) (*JobStatus, error) {
+	fm := bp.fm.pinned()
+	if err := checkJobID(jobID); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(fm.journalPath(jobID)); errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, jobID)
+	}
+
+	unlock, err := fm.lockJob(jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	status, err := bp.runJob(fm, jobID)
+	if errors.Is(err, ErrJobNotFound) {
+		// The job completed and was removed before the lock was taken
+		_ = os.Remove(filepath.Join(fm.current().journalDir, jobID+lockExtension))
+	}
+	return status, err
+}
+
+// runJob runs the unfinished items of a job whose lock is held and journals their outcome.
+// Journaled jobs cannot be rolled back, so AllOrNothing behaves like FailFast here.
+func (bp *BatchProcessor) runJob(fm *FileManager, jobID string) (*JobStatus, error) {
+	status, complete, err := fm.readJournal(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(fm.journalPath(jobID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	j := &journal{file: file}
+	defer func() { _ = file.Close() }()
+
+	// Records are appended after the last complete one, a torn record would garble them
+	if info, err := file.Stat(); err == nil && info.Size() > complete {
+		if err := file.Truncate(complete); err != nil {
+			return nil, fmt.Errorf("failed to repair journal: %w", err)
+		}
+	}
+
+	var unfinished []int
+	for i, item := range status.Items {
+		if item.State != JobDone {
+			unfinished = append(unfinished, i)
+		}
+	}
+
+	itemManager := bp.itemManager(fm)
+	errs, attempts := bp.run(len(unfinished), nil, func(k int) error {
+		index := unfinished[k]
+		item := status.Items[index]
+		resumed := item.State != JobPending
+
+		if err := j.record(index, JobRunning, nil, 0); err != nil {
+			return err
+		}
+		if err := bp.runJobItem(itemManager, status.ID, index, item.Item, resumed); err != nil {
+			return fmt.Errorf("failed to %s file %s: %w", item.Item.Op, item.Item.Filename, err)
+		}
+		return j.record(index, JobDone, nil, 0)
+	})
+
+	var journalErr error
+	for k, index := range unfinished {
+		item := &status.Items[index]
+		item.Attempts = attempts[k]
+		item.Error = errs[k]
+		if errs[k] == nil {
+			item.State = JobDone
+			continue
+		}
+		item.State = JobFailed
+		if err := j.record(index, JobFailed, errs[k], attempts[k]); err != nil && journalErr == nil {
+			journalErr = err
+		}
+	}
+	if journalErr != nil {
+		return status, journalErr
+	}
+
+	if status.Done() {
+		_ = file.Close()
+		if err := fm.removeJob(status.ID); err != nil {
+			return status, err
+		}
+	}
+	return status, nil
+}
+
+// runJobItem performs a single job item. Items that were started before are
+// checked for work that already completed, so running them again is harmless.
+func (bp *BatchProcessor) runJobItem(fm *FileManager, jobID string, index int, item JobItem, resumed bool) error {
+	switch item.Op {
+	case JobSeal:
+		return fm.applyJobPayload(jobID, index, item, resumed)
+	case JobUnseal:
+		options := item.Options
+		options.DecryptBeforeCopy = true
+		return fm.copyIdempotent(item, options, resumed)
+	case JobCopy:
+		return fm.copyIdempotent(item, item.Options, resumed)
+	case JobRekey:
+		return fm.RekeyFile(item.Path, item.Filename)
+	case JobDelete:
+		err := fm.DeleteFile(item.Path, item.Filename)
+		if resumed && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown job operation %q", item.Op)
+	}
+}
+
+// applyJobPayload moves the sealed payload of a seal item into place and writes its thumbnails
+func (fm *FileManager) applyJobPayload(jobID string, index int, item JobItem, resumed bool) error {
+	payload := filepath.Join(fm.payloadDir(jobID), strconv.Itoa(index))
+	dir, target, err := fm.current().resolveFile(item.Path, item.Filename)
+	if err != nil {
+		return err
+	}
+	if err := fm.authorize(AccessSave, target); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(payload); errors.Is(err, fs.ErrNotExist) {
+		// The payload was moved into place before the interruption, its thumbnails may not have been
+		if _, err := os.Stat(target); resumed && err == nil {
+			return fm.writeJobThumbnails(item)
+		}
+		return fmt.Errorf("journal payload of %s is missing", item.Filename)
+	}
+
+	if err := EnsureDirectory(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(payload, target); err == nil {
+		return fm.writeJobThumbnails(item)
+	}
+
+	// The journal may live on another file system than the target
+	source, err := os.Open(payload)
+	if err != nil {
+		return fmt.Errorf("failed to read journal payload: %w", err)
+	}
+	defer func() { _ = source.Close() }()
+
+	if err := writeFileAtomic(target, func(w io.Writer) error {
+		_, err := io.Copy(w, source)
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := os.Remove(payload); err != nil {
+		return err
+	}
+	return fm.writeJobThumbnails(item)
+}
+
+// writeJobThumbnails writes the thumbnails of a seal item from the file moved into place
+func (fm *FileManager) writeJobThumbnails(item JobItem) error {
+	snapshot := fm.current()
+	if !snapshot.wantsThumbnails(item.Filename) {
+		return nil
+	}
+	sf := fm.NewSecureFile(nil, item.Path, item.Filename)
+	return newSealError("thumbnail", sf.GetFullPath(), sf.writeThumbnails(snapshot, nil))
+}
+
+// copyIdempotent copies a file atomically. A resumed copy whose destination already
+// holds the expected content is treated as completed.
+func (fm *FileManager) copyIdempotent(item JobItem, options CopyOptions, resumed bool) error {
+	data, err := fm.readCopySource(item.Path, item.Filename, options)
+	if err != nil {
+		return err
+	}
+
+	destFullPath, _, err := fm.prepareCopyDestination(item.DestPath, item.DestFilename, options, false)
+	if err != nil {
+		if existing, readErr := os.ReadFile(destFullPath); resumed && readErr == nil && bytes.Equal(existing, data) {
+			return nil
+		}
+		return err
+	}
+
+	return writeFileAtomic(destFullPath, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
@@ -0,0 +1,299 @@
+package sealfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeJournal writes the journal of an interrupted job, followed by the given raw records
+func writeJournal(t *testing.T, dir, jobID string, items []JobItem, records string) {
+	t.Helper()
+	header, err := json.Marshal(jobHeader{ID: jobID, Created: time.Now().UTC(), Items: items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, jobID+journalExtension), append(append(header, '\n'), records...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJobResumedAfterInterruption(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) { c.JournalDir = "journal" })
+	if _, err := fm.SaveDataAsSecureFile([]byte("a"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The job stopped while deleting a.txt, in the middle of writing a record
+	items := []JobItem{
+		{Op: JobDelete, Path: "files", Filename: "a.txt"},
+		{Op: JobDelete, Path: "files", Filename: "missing.txt"},
+	}
+	writeJournal(t, rootPath(fm, "journal"), "job-1", items, `{"item":0,"state":"running"}`+"\n"+`{"item":1,"sta`)
+
+	config := *fm.current().config
+	resumed, err := NewFileManager(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(rootPath(fm, "files/a.txt")); err != nil {
+		t.Fatalf("NewFileManager resumed the job: %v", err)
+	}
+	statuses, err := NewBatchProcessor(resumed, 1).ResumeAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].ID != "job-1" {
+		t.Fatalf("unexpected resumed jobs %+v", statuses)
+	}
+	if _, err := os.Stat(rootPath(fm, "files/a.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("a.txt was not deleted on resume: %v", err)
+	}
+
+	// The missing file fails the job, whose journal must still read after the torn record
+	jobs, err := resumed.ListJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Error != nil {
+		t.Fatalf("unexpected jobs %+v", jobs)
+	}
+	if jobs[0].Items[0].State != JobDone || jobs[0].Items[1].State != JobFailed {
+		t.Fatalf("unexpected item states %+v", jobs[0].Items)
+	}
+	if _, err := NewBatchProcessor(resumed, 1).Resume("job-1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompletedJobRemovesJournal(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) { c.JournalDir = "journal" })
+	status, err := NewBatchProcessor(fm, 2).StartJob([]JobItem{
+		{Op: JobSeal, Path: "files", Filename: "a.txt", Data: []byte("a")},
+		{Op: JobSeal, Path: "files", Filename: "b.txt", Data: []byte("b")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Done() {
+		t.Fatalf("job not done: %+v", status.Items)
+	}
+	entries, err := os.ReadDir(rootPath(fm, "journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("journal directory not empty: %v", entries)
+	}
+
+	sf, err := fm.LoadSecureFileFromDisk("files", "b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sf.Data) != "b" {
+		t.Fatalf("got %q", sf.Data)
+	}
+}
+
+func TestUnreadableJournalIsListed(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) { c.JournalDir = "journal" })
+	dir := rootPath(fm, "journal")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken"+journalExtension), []byte("not json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewBatchProcessor(fm, 1).ResumeAll(); err != nil {
+		t.Fatalf("a broken journal failed ResumeAll: %v", err)
+	}
+	jobs, err := fm.ListJobs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != "broken" || jobs[0].Error == nil || jobs[0].Done() {
+		t.Fatalf("unexpected jobs %+v", jobs)
+	}
+}
+
+func TestRunningJobIsLocked(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) { c.JournalDir = "journal" })
+	writeJournal(t, rootPath(fm, "journal"), "job-1", []JobItem{{Op: JobDelete, Path: "files", Filename: "a.txt"}}, "")
+
+	unlock, err := fm.lockJob("job-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBatchProcessor(fm, 1).Resume("job-1"); !errors.Is(err, ErrJobLocked) {
+		t.Fatalf("resume of a locked job: got %v", err)
+	}
+	if err := fm.RemoveJob("job-1"); !errors.Is(err, ErrJobLocked) {
+		t.Fatalf("removal of a locked job: got %v", err)
+	}
+
+	// Another FileManager skips the locked job instead of resuming it
+	config := *fm.current().config
+	other, err := NewFileManager(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses, err := NewBatchProcessor(other, 1).ResumeAll(); err != nil || len(statuses) != 0 {
+		t.Fatalf("resumed %d locked jobs: %v", len(statuses), err)
+	}
+	if _, err := fm.readJob("job-1"); err != nil {
+		t.Fatalf("locked job was touched: %v", err)
+	}
+
+	unlock()
+	if err := fm.RemoveJob("job-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBatchProcessor(fm, 1).Resume("job-1"); !errors.Is(err, ErrJobNotFound) {
+		t.Fatalf("resume of a removed job: got %v", err)
+	}
+}
+
+// withPNGChunk inserts a chunk right after the IHDR chunk of a PNG image
+func withPNGChunk(image []byte, chunkType string, data []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, data...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	ihdrEnd := len(pngMagic) + 8 + 13 + 4
+	return append(append(append([]byte(nil), image[:ihdrEnd]...), chunk...), image[ihdrEnd:]...)
+}
+
+func TestJobSealsPayloadUnderTargetRules(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) {
+		c.JournalDir = "journal"
+		c.StripImageMetadata = true
+		c.ThumbnailSizes = []int{16}
+	})
+	image := withPNGChunk(pngForTest(t, 64, 64), "tEXt", []byte("Location\x00secret place"))
+
+	status, err := NewBatchProcessor(fm, 1).StartJob([]JobItem{{Op: JobSeal, Path: "images", Filename: "photo.png", Data: image}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Done() {
+		t.Fatalf("job not done: %+v", status.Items)
+	}
+
+	sf, err := fm.LoadSecureFileFromDisk("images", "photo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sf.Data, []byte("secret place")) {
+		t.Fatal("journaled image kept its text metadata")
+	}
+	if _, err := sf.Thumbnail(16); err != nil {
+		t.Fatalf("journaled image has no thumbnail: %v", err)
+	}
+}
+
+func TestResumedSealWritesMissingThumbnails(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) {
+		c.JournalDir = "journal"
+		c.ThumbnailSizes = []int{16}
+	})
+	// The payload was moved into place, the process stopped before the thumbnails were written
+	if _, err := fm.SaveDataAsSecureFile(pngForTest(t, 64, 64), "images", "photo.png"); err != nil {
+		t.Fatal(err)
+	}
+	for _, thumbnail := range fm.current().thumbnailPaths(rootPath(fm, "images/photo.png")) {
+		if err := os.Remove(thumbnail); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeJournal(t, rootPath(fm, "journal"), "job-1", []JobItem{{Op: JobSeal, Path: "images", Filename: "photo.png"}},
+		`{"item":0,"state":"running"}`+"\n")
+
+	if _, err := NewBatchProcessor(fm, 1).Resume("job-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fm.NewSecureFile(nil, "images", "photo.png").Thumbnail(16); err != nil {
+		t.Fatalf("resumed job wrote no thumbnail: %v", err)
+	}
+}
+
+func TestResumeAllIsAuthorized(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) { c.JournalDir = "journal" })
+	if _, err := fm.SaveDataAsSecureFile([]byte("a"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	writeJournal(t, rootPath(fm, "journal"), "job-1", []JobItem{{Op: JobDelete, Path: "files", Filename: "a.txt"}}, "")
+
+	fm.SetAuthorizer(&PathPolicy{Rules: []PolicyRule{{Roles: []string{"admin"}}}})
+	statuses, err := NewBatchProcessor(fm, 1).ResumeAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || !errors.Is(statuses[0].Items[0].Error, ErrPermissionDenied) {
+		t.Fatalf("unauthorized resume: %+v", statuses)
+	}
+	if _, err := os.Stat(rootPath(fm, "files/a.txt")); err != nil {
+		t.Fatalf("unauthorized resume deleted the file: %v", err)
+	}
+
+	// The failed job is no longer interrupted, it is retried explicitly
+	admin := fm.WithContext(WithPrincipal(context.Background(), Principal{ID: "ops", Roles: []string{"admin"}}))
+	status, err := NewBatchProcessor(admin, 1).Resume("job-1")
+	if err != nil || !status.Done() {
+		t.Fatalf("authorized resume: %+v, %v", status, err)
+	}
+}
+
+func TestDryRunJobJournalsNothing(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) { c.JournalDir = "journal" })
+	if _, err := fm.SaveDataAsSecureFile([]byte("a"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	items := []JobItem{
+		{Op: JobSeal, Path: "files", Filename: "b.txt", Data: []byte("b")},
+		{Op: JobSeal, Path: "../outside", Filename: "c.txt", Data: []byte("c")},
+		{Op: JobCopy, Path: "files", Filename: "a.txt", DestPath: "files", DestFilename: "a.txt"},
+		{Op: JobDelete, Path: "files", Filename: "missing.txt"},
+		{Op: JobRekey, Path: "files", Filename: "a.txt"},
+	}
+
+	before := treeForTest(t, fm.current().config.RootDir)
+	planned, err := NewBatchProcessorWithOptions(fm, BatchOptions{DryRun: true}).StartJob(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := treeForTest(t, fm.current().config.RootDir)
+	for path, content := range after {
+		if before[path] != content || len(after) != len(before) {
+			t.Fatalf("dry run changed the tree from %v to %v", before, after)
+		}
+	}
+	if planned.ID != "" {
+		t.Fatalf("dry run created job %s", planned.ID)
+	}
+
+	status, err := NewBatchProcessor(fm, 0).StartJob(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, item := range status.Items {
+		dry := planned.Items[i]
+		if (dry.State == JobFailed) != (item.State == JobFailed) || ErrorKind(dry.Error) != ErrorKind(item.Error) {
+			t.Errorf("item %d: dry run reported %s %v, the job %s %v", i, dry.State, dry.Error, item.State, item.Error)
+		}
+	}
+	if planned.Items[0].State != JobPending || planned.Items[1].State != JobFailed || planned.Items[3].State != JobFailed {
+		t.Fatalf("unexpected planned states %+v", planned.Items)
+	}
+}
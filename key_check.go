@@ -0,0 +1,85 @@
+package sealfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// KeyCheckFile is the name of the store marker recording the key of a public directory
+const KeyCheckFile = ".sealfile-keycheck"
+
+// keyCheckMarker is the content of the store marker
+type keyCheckMarker struct {
+	KeyCheck string `json:"key_check"`
+}
+
+// WriteKeyCheck records the key check of the current key in the public directory.
+// Write it again after rotating keys and rekeying the store.
+func (fm *FileManager) WriteKeyCheck() error {
+	snapshot := fm.current()
+	path, err := snapshot.keyCheckPath()
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(keyCheckMarker{KeyCheck: snapshot.encryptor.KeyCheck()})
+	if err != nil {
+		return fmt.Errorf("failed to encode key check: %w", err)
+	}
+	if err := EnsureDirectory(filepath.Dir(path)); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := w.Write(append(encoded, '\n'))
+		return err
+	})
+}
+
+// VerifyKey checks the configured keys against the store marker of the public directory.
+// It fails with ErrWrongKey when neither the current nor a previous key matches,
+// and succeeds when the store has no marker.
+func (fm *FileManager) VerifyKey() error {
+	return fm.current().verifyKey()
+}
+
+// verifyKey checks the keys of the snapshot against the store marker
+func (s *configSnapshot) verifyKey() error {
+	path, err := s.keyCheckPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read key check: %w", err)
+	}
+
+	var marker keyCheckMarker
+	if err := json.Unmarshal(data, &marker); err != nil || marker.KeyCheck == "" {
+		return mark(ErrCorrupted, fmt.Errorf("invalid key check in %s", path))
+	}
+
+	for _, encryptor := range append([]*Encryptor{s.encryptor}, s.previous...) {
+		if encryptor.KeyCheck() == marker.KeyCheck {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the store at %s was sealed with another key", ErrWrongKey, filepath.Dir(path))
+}
+
+// keyCheckPath returns the location of the store marker
+func (s *configSnapshot) keyCheckPath() (string, error) {
+	dir, err := s.resolveDir(s.config.PublicDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, KeyCheckFile), nil
+}
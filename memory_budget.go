@@ -0,0 +1,79 @@
+package sealfile
+
+import (
+	"os"
+	"sync"
+)
+
+// memoryBudget admits work while the estimated bytes held in memory stay within a limit
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	inUse int64
+}
+
+// newMemoryBudget creates a budget of limit bytes, or nil when limit is not positive
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// acquire blocks until n bytes fit into the budget.
+// Work larger than the whole budget is admitted once nothing else is in flight.
+func (b *memoryBudget) acquire(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.inUse > 0 && b.inUse+n > b.limit {
+		b.cond.Wait()
+	}
+	b.inUse += n
+}
+
+// release returns n bytes to the budget
+func (b *memoryBudget) release(n int64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.inUse -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+// exceeded reports whether n bytes are more than the whole budget
+func (b *memoryBudget) exceeded(n int64) bool {
+	return b != nil && n > b.limit
+}
+
+// sealCost estimates the memory held while sealing size bytes: the data itself and either,
+// for sealed streams, the chunk being sealed and its ciphertext, or, for whole-file sealing,
+// the ciphertext and its gzip copy
+func (b *memoryBudget) sealCost(size int, stream bool) int64 {
+	if !stream {
+		return 3*int64(size) + streamTagOverhead
+	}
+	chunk := min(size, streamChunkSize)
+	return int64(size) + 2*int64(chunk+streamTagOverhead)
+}
+
+// loadCost estimates the memory held while loading the file at fullPath: the file as read
+// from disk, the ciphertext decompressed from whole-file sealing and the plaintext, none of
+// them larger than the file. Files that cannot be inspected cost nothing, loading them fails.
+func (b *memoryBudget) loadCost(fullPath string) int64 {
+	if b == nil {
+		return 0
+	}
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return 0
+	}
+	return 3 * info.Size()
+}
@@ -0,0 +1,89 @@
+package sealfile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudgetBlocksUntilReleased(t *testing.T) {
+	b := newMemoryBudget(100)
+	b.acquire(80)
+
+	admitted := make(chan struct{})
+	go func() {
+		b.acquire(30)
+		close(admitted)
+	}()
+
+	select {
+	case <-admitted:
+		t.Fatal("work beyond the budget was admitted")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.release(80)
+	select {
+	case <-admitted:
+	case <-time.After(time.Second):
+		t.Fatal("work was not admitted after release")
+	}
+}
+
+func TestMemoryBudgetAdmitsOversizedWorkAlone(t *testing.T) {
+	b := newMemoryBudget(100)
+	done := make(chan struct{})
+	go func() {
+		b.acquire(500)
+		b.release(500)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("oversized work was never admitted")
+	}
+}
+
+func TestMemoryBudgetCosts(t *testing.T) {
+	b := newMemoryBudget(1)
+	if cost := b.sealCost(10<<20, true); cost < 10<<20 {
+		t.Fatalf("sealing 10 MiB costs only %d bytes", cost)
+	}
+	if cost := b.loadCost("/nonexistent/file"); cost != 0 {
+		t.Fatalf("missing file costs %d bytes", cost)
+	}
+}
+
+func TestBatchStreamsFilesLargerThanBudget(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	large, small := randomBytes(t, 1<<20), randomBytes(t, 1<<10)
+
+	for _, policy := range []FailurePolicy{ContinueOnError, AllOrNothing} {
+		bp := NewBatchProcessorWithOptions(fm, BatchOptions{MemoryBudget: 1 << 20, FailurePolicy: policy})
+		errs := bp.SaveAllFiles([]*SecureFile{
+			fm.NewSecureFile(large, "files", "large.bin"),
+			fm.NewSecureFile(small, "files", "small.bin"),
+		})
+		for _, err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Sealing the large file as a whole would hold three copies of it
+		if format, _ := detectFormat(rootPath(fm, "files/large.bin")); format != FormatStream {
+			t.Fatalf("file over the budget was sealed as %s", format)
+		}
+		if format, _ := detectFormat(rootPath(fm, "files/small.bin")); format != FormatLegacy {
+			t.Fatalf("file within the budget was sealed as %s", format)
+		}
+		loaded, err := fm.LoadSecureFileFromDisk("files", "large.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(loaded.Data, large) {
+			t.Fatal("stream-sealed file does not round-trip")
+		}
+	}
+}
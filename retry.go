@@ -0,0 +1,139 @@
+package sealfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy defines how operations failing with transient errors are retried
+type RetryPolicy struct {
+	MaxAttempts int                  // Total attempts including the first one
+	BaseDelay   time.Duration        // Delay before the second attempt, doubled for every further attempt
+	MaxDelay    time.Duration        // Upper bound for a single delay
+	Jitter      float64              // Fraction of the delay randomly added or removed (0 to 1)
+	Retryable   func(err error) bool // Decides which errors are retried, IsTransientError when nil
+}
+
+// RetryError wraps the last error of an operation that was attempted more than once
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+// Error implements the error interface
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("%v (after %d attempts)", e.Err, e.Attempts)
+}
+
+// Unwrap returns the last error
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultRetryPolicy returns a retry policy suited for local and network storage
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: 4,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    2 * time.Second,
+		Jitter:      0.2,
+		Retryable:   IsTransientError,
+	}
+}
+
+// IsTransientError reports whether an error is likely to go away when the operation is retried
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	transient := []error{syscall.EAGAIN, syscall.EBUSY, syscall.EINTR, syscall.ETIMEDOUT, os.ErrDeadlineExceeded}
+	for _, target := range transient {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Do runs fn until it succeeds, fails with a non-retryable error or runs out of attempts.
+// It returns the number of attempts made. A nil policy runs fn exactly once.
+func (p *RetryPolicy) Do(fn func() error) (int, error) {
+	return p.DoContext(context.Background(), fn)
+}
+
+// DoContext is like Do but stops waiting for the next attempt once ctx is done.
+// A RetryError returned by fn comes from a nested policy that already retried, so it is
+// not retried again and its attempts are added to the count.
+func (p *RetryPolicy) DoContext(ctx context.Context, fn func() error) (int, error) {
+	if p == nil || p.MaxAttempts <= 1 {
+		return withNestedAttempts(0, fn())
+	}
+
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsTransientError
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return attempt, nil
+		}
+
+		var nested *RetryError
+		if errors.As(err, &nested) {
+			return withNestedAttempts(attempt-1, err)
+		}
+		if attempt >= p.MaxAttempts || !retryable(err) {
+			if attempt > 1 {
+				err = &RetryError{Attempts: attempt, Err: err}
+			}
+			return attempt, err
+		}
+
+		timer := time.NewTimer(p.delay(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, &RetryError{Attempts: attempt, Err: fmt.Errorf("%w: %w", ctx.Err(), err)}
+		}
+	}
+}
+
+// withNestedAttempts counts the attempts of err after the given earlier attempts.
+// The count of a RetryError of a nested policy is extended by the earlier attempts.
+func withNestedAttempts(earlier int, err error) (int, error) {
+	var nested *RetryError
+	if !errors.As(err, &nested) {
+		return earlier + 1, err
+	}
+	nested.Attempts += earlier
+	return nested.Attempts, err
+}
+
+// delay returns the backoff before the attempt following the given one
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || (p.MaxDelay > 0 && delay > p.MaxDelay) {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		delay = time.Duration(float64(delay) * (1 + jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
@@ -0,0 +1,179 @@
+package sealfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// alwaysRetry retries every error
+func alwaysRetry(error) bool { return true }
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{
+		1:  10 * time.Millisecond,
+		2:  20 * time.Millisecond,
+		3:  40 * time.Millisecond,
+		4:  50 * time.Millisecond,
+		80: 50 * time.Millisecond, // The shift overflows
+	} {
+		if got := policy.delay(attempt); got != want {
+			t.Errorf("delay after attempt %d = %v, want %v", attempt, got, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for range 100 {
+		if got := policy.delay(1); got < 5*time.Millisecond || got > 15*time.Millisecond {
+			t.Fatalf("jittered delay %v is out of range", got)
+		}
+	}
+}
+
+// timeoutError is a network error that timed out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{syscall.EAGAIN, true},
+		{&os.PathError{Op: "open", Path: "file", Err: syscall.EBUSY}, true},
+		{fmt.Errorf("failed to write file: %w", syscall.EINTR), true},
+		{os.ErrDeadlineExceeded, true},
+		{timeoutError{}, true},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, true},
+		{os.ErrNotExist, false},
+		{syscall.EACCES, false},
+		{ErrAuthenticationFailed, false},
+	}
+	for _, test := range tests {
+		if got := IsTransientError(test.err); got != test.want {
+			t.Errorf("IsTransientError(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+// failing returns a function failing with the given errors in turn, and succeeding afterwards
+func failing(calls *int, errs ...error) func() error {
+	return func() error {
+		*calls++
+		if *calls <= len(errs) {
+			return errs[*calls-1]
+		}
+		return nil
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3}
+
+	var calls int
+	attempts, err := policy.Do(failing(&calls, syscall.EAGAIN, syscall.EBUSY))
+	if err != nil || attempts != 3 || calls != 3 {
+		t.Fatalf("succeeded after %d attempts and %d calls: %v", attempts, calls, err)
+	}
+
+	calls = 0
+	attempts, err = policy.Do(failing(&calls, syscall.EAGAIN, os.ErrNotExist))
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 2 || attempts != 2 || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("non-retryable error after %d attempts: %v", attempts, err)
+	}
+
+	calls = 0
+	attempts, err = policy.Do(failing(&calls, os.ErrNotExist))
+	if attempts != 1 || errors.As(err, &retryErr) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("error of a single attempt is %v after %d attempts", err, attempts)
+	}
+
+	calls = 0
+	attempts, err = policy.Do(failing(&calls, syscall.EAGAIN, syscall.EAGAIN, syscall.EAGAIN, syscall.EAGAIN))
+	if !errors.As(err, &retryErr) || retryErr.Attempts != 3 || attempts != 3 || calls != 3 || !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("exhausted policy made %d attempts and %d calls: %v", attempts, calls, err)
+	}
+
+	calls = 0
+	var none *RetryPolicy
+	if attempts, err := none.Do(failing(&calls, syscall.EAGAIN)); attempts != 1 || calls != 1 || !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("nil policy made %d attempts: %v", attempts, err)
+	}
+}
+
+func TestRetryPolicyNested(t *testing.T) {
+	inner := &RetryPolicy{MaxAttempts: 3, Retryable: alwaysRetry}
+	outer := &RetryPolicy{MaxAttempts: 3, Retryable: alwaysRetry}
+
+	var calls, outerCalls int
+	attempts, err := outer.Do(func() error {
+		outerCalls++
+		if outerCalls == 1 {
+			// The first outer attempt fails before reaching the nested policy
+			return syscall.EAGAIN
+		}
+		_, err := inner.Do(func() error {
+			calls++
+			return syscall.EAGAIN
+		})
+		return fmt.Errorf("failed to save: %w", err)
+	})
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("got %v, want a RetryError", err)
+	}
+	if outerCalls != 2 || calls != 3 {
+		t.Fatalf("outer policy ran %d times and the nested one %d times, want 2 and 3", outerCalls, calls)
+	}
+	if attempts != 4 || retryErr.Attempts != 4 {
+		t.Fatalf("reported %d and %d attempts, want 4", attempts, retryErr.Attempts)
+	}
+}
+
+func TestRetryPolicyContext(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	var calls int
+	started := time.Now()
+	attempts, err := policy.DoContext(ctx, failing(&calls, syscall.EAGAIN, syscall.EAGAIN))
+	if time.Since(started) > time.Minute {
+		t.Fatal("backoff ignored the cancelled context")
+	}
+	if attempts != 1 || calls != 1 || !errors.Is(err, context.Canceled) || !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("cancelled after %d attempts: %v", attempts, err)
+	}
+}
+
+func TestBatchRetriesAtOneLayer(t *testing.T) {
+	fm := newTestFileManager(t, func(config *Config) {
+		config.Retry = &RetryPolicy{MaxAttempts: 3, Retryable: alwaysRetry}
+	})
+	bp := NewBatchProcessorWithOptions(fm, BatchOptions{Retry: &RetryPolicy{MaxAttempts: 2, Retryable: alwaysRetry}})
+
+	results := bp.DecryptOperations([]FileOperation{{Path: "files", Filename: "missing.txt"}})
+	if results[0].Error == nil || results[0].Attempts != 2 {
+		t.Fatalf("missing file failed after %d attempts: %v", results[0].Attempts, results[0].Error)
+	}
+
+	// Without a batch policy the attempts of Config.Retry are reported
+	results = NewBatchProcessor(fm, 1).DecryptOperations([]FileOperation{{Path: "files", Filename: "missing.txt"}})
+	if results[0].Error == nil || results[0].Attempts != 3 {
+		t.Fatalf("missing file failed after %d attempts: %v", results[0].Attempts, results[0].Error)
+	}
+}
@@ -0,0 +1,59 @@
+package sealfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// LoadDecryptedRevision loads and decrypts the file like LoadDecrypted and returns its
+// revision, an identifier of the sealed bytes that were decrypted. Pass it to
+// SaveEncryptedFromIfRevision to save only when nobody changed the file meanwhile.
+func (sf *SecureFile) LoadDecryptedRevision() (_ string, err error) {
+	defer sf.wrapError("unseal", &err)
+	return sf.loadRevision(sf.fm.current())
+}
+
+// SaveEncryptedFromIfRevision is SaveEncryptedFrom for a file that must still be at the
+// given revision, or must not exist when revision is empty. The revision is checked right
+// before the sealed file is moved into place; ErrRevisionMismatch is returned otherwise.
+// With an empty revision the file is created atomically, it is never replaced.
+func (sf *SecureFile) SaveEncryptedFromIfRevision(r io.Reader, revision string) (err error) {
+	defer sf.wrapError("seal", &err)
+	err = sf.saveEncryptedFrom(r, func(fullPath string) error {
+		current, err := fileRevision(fullPath)
+		if err != nil {
+			return err
+		}
+		if current != revision {
+			return fmt.Errorf("%w: %s", ErrRevisionMismatch, sf.Filename)
+		}
+		return nil
+	}, revision == "")
+	if errors.Is(err, ErrAlreadyExists) {
+		return fmt.Errorf("%w: %s", ErrRevisionMismatch, sf.Filename)
+	}
+	return err
+}
+
+// fileRevision returns the revision of the sealed file at path, empty when it does not exist
+func fileRevision(path string) (string, error) {
+	sealed, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return sealedRevision(sealed), nil
+}
+
+// sealedRevision returns the revision of sealed bytes
+func sealedRevision(sealed []byte) string {
+	sum := sha256.Sum256(sealed)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package sealfile
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSaveEncryptedFromIfRevision(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	if _, err := fm.SaveDataAsSecureFile([]byte("v1"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	sf := fm.NewSecureFile(nil, "files", "a.txt")
+	revision, err := sf.LoadDecryptedRevision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision == "" || string(sf.Data) != "v1" {
+		t.Fatalf("revision %q, data %q", revision, sf.Data)
+	}
+
+	// Someone else saves in between
+	if _, err := fm.SaveDataAsSecureFile([]byte("v2"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sf.SaveEncryptedFromIfRevision(strings.NewReader("mine"), revision); !errors.Is(err, ErrRevisionMismatch) {
+		t.Fatalf("got %v, want ErrRevisionMismatch", err)
+	}
+	loaded, err := fm.LoadSecureFileFromDisk("files", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.Data, []byte("v2")) {
+		t.Fatalf("a failed save changed the file to %q", loaded.Data)
+	}
+
+	if revision, err = sf.LoadDecryptedRevision(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sf.SaveEncryptedFromIfRevision(strings.NewReader("mine"), revision); err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty revision requires that the file does not exist
+	if err := sf.SaveEncryptedFromIfRevision(strings.NewReader("again"), ""); !errors.Is(err, ErrRevisionMismatch) {
+		t.Fatalf("got %v, want ErrRevisionMismatch", err)
+	}
+	created := fm.NewSecureFile(nil, "files", "b.txt")
+	if err := created.SaveEncryptedFromIfRevision(strings.NewReader("new"), ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTempDirStaysInRoot(t *testing.T) {
+	fm := newTestFileManager(t, func(c *Config) { c.TempDir = "scratch" })
+	dir, err := fm.TempDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != rootPath(fm, "scratch") {
+		t.Fatalf("temp directory %q", dir)
+	}
+
+	fm = newTestFileManager(t, func(c *Config) { c.TempDir = "../outside" })
+	if _, err := fm.TempDir(); !errors.Is(err, ErrPathEscape) {
+		t.Fatalf("got %v, want ErrPathEscape", err)
+	}
+}
@@ -0,0 +1,97 @@
+package sealfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// resolveDir maps a directory onto the root directory of the configuration.
+// Relative paths are taken relative to the root and the result, with symbolic links
+// followed, must stay inside it. Without a root directory paths are used as given.
+func (s *configSnapshot) resolveDir(path string) (string, error) {
+	root := s.config.RootDir
+	if root == "" {
+		return path, nil
+	}
+	return resolveWithin(root, path)
+}
+
+// resolveWithin maps path onto root. Relative paths are taken relative to root and the
+// result, with symbolic links followed, must stay inside it.
+func resolveWithin(root, path string) (string, error) {
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve root directory: %w", err)
+	}
+
+	resolved := filepath.Clean(path)
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(rootAbs, resolved)
+	}
+	if !isWithin(rootAbs, resolved) {
+		return "", fmt.Errorf("%w: %s", ErrPathEscape, path)
+	}
+
+	// A symbolic link inside the root must not lead out of it
+	realRoot, err := evalExistingSymlinks(rootAbs)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := evalExistingSymlinks(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(realRoot, realPath) {
+		return "", fmt.Errorf("%w: %s", ErrPathEscape, path)
+	}
+
+	return resolved, nil
+}
+
+// resolveFile resolves the directory and the full path of a file inside the root directory
+func (s *configSnapshot) resolveFile(path, filename string) (string, string, error) {
+	dir, err := s.resolveDir(path)
+	if err != nil {
+		return "", "", err
+	}
+
+	fullPath := filepath.Join(dir, filename)
+	if s.config.RootDir != "" {
+		// The filename itself may contain separators or parent references
+		if _, err := s.resolveDir(fullPath); err != nil {
+			return "", "", err
+		}
+	}
+	return dir, fullPath, nil
+}
+
+// isWithin reports whether path is root or lies below it; both must be clean and absolute
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// evalExistingSymlinks follows symbolic links in the longest existing prefix of path
+func evalExistingSymlinks(path string) (string, error) {
+	missing := ""
+	for current := path; ; current = filepath.Dir(current) {
+		real, err := filepath.EvalSymlinks(current)
+		if err == nil {
+			return filepath.Join(real, missing), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to resolve path: %w", err)
+		}
+
+		parent := filepath.Dir(current)
+		if parent == current {
+			return path, nil
+		}
+		missing = filepath.Join(filepath.Base(current), missing)
+	}
+}
@@ -0,0 +1,53 @@
+package sealfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveWithin(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "inside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symbolic links unavailable: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(root, "inside"), filepath.Join(root, "alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"inside", "inside/new/dir", "alias/file", filepath.Join(root, "inside"), "inside/../inside"} {
+		resolved, err := resolveWithin(root, path)
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if !isWithin(root, resolved) {
+			t.Errorf("%s resolved to %s outside the root", path, resolved)
+		}
+	}
+
+	for _, path := range []string{"..", "../sibling", "inside/../../sibling", outside, "escape", "escape/file", "escape/new/dir"} {
+		if _, err := resolveWithin(root, path); !errors.Is(err, ErrPathEscape) {
+			t.Errorf("%s: got %v, want ErrPathEscape", path, err)
+		}
+	}
+}
+
+func TestResolveFileRejectsEscapingFilename(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	snapshot := fm.current()
+
+	for _, filename := range []string{"../../escape.txt", "../../" + filepath.Base(snapshot.config.RootDir) + "-other/file"} {
+		if _, _, err := snapshot.resolveFile("files", filename); !errors.Is(err, ErrPathEscape) {
+			t.Errorf("%s: got %v, want ErrPathEscape", filename, err)
+		}
+	}
+	if _, _, err := snapshot.resolveFile("files", "../files2/ok.txt"); err != nil {
+		t.Errorf("filename staying inside the root: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package sealfile
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScanVerdict is the decision of a Scanner on a file
+type ScanVerdict string
+
+const (
+	ScanAllow      ScanVerdict = "allow"      // Seal the file as asked
+	ScanReject     ScanVerdict = "reject"     // Refuse the file, nothing is written
+	ScanQuarantine ScanVerdict = "quarantine" // Seal the file below Config.QuarantineDir instead
+)
+
+// ScanResult is the outcome of scanning a file
+type ScanResult struct {
+	Verdict ScanVerdict
+	Threat  string // Name of what was found, such as a virus signature; empty for clean files
+}
+
+// Scanner inspects the plaintext of a file before it is sealed, sealed files cannot be
+// scanned at rest without the key. The name is the path of the file as shown to an
+// Authorizer. Scan may stop reading r once it reached a verdict. Errors fail the save,
+// and verdicts other than ScanAllow and ScanQuarantine reject the file.
+type Scanner interface {
+	Scan(ctx context.Context, name string, r io.Reader) (ScanResult, error)
+}
+
+// ScannerFunc adapts a function to a Scanner
+type ScannerFunc func(ctx context.Context, name string, r io.Reader) (ScanResult, error)
+
+// Scan calls f
+func (f ScannerFunc) Scan(ctx context.Context, name string, r io.Reader) (ScanResult, error) {
+	return f(ctx, name, r)
+}
+
+// ScanError is reported when a Scanner rejects or quarantines a file, it matches ErrScanRejected
+type ScanError struct {
+	Filename       string
+	Result         ScanResult
+	QuarantinePath string // Sealed copy below Config.QuarantineDir, empty when the file was rejected
+}
+
+// Error describes the verdict
+func (e *ScanError) Error() string {
+	threat := e.Result.Threat
+	if threat == "" {
+		threat = "no threat named"
+	}
+	if e.QuarantinePath != "" {
+		return fmt.Sprintf("%s: %s quarantined as %s (%s)", ErrScanRejected, e.Filename, e.QuarantinePath, threat)
+	}
+	return fmt.Sprintf("%s: %s (%s)", ErrScanRejected, e.Filename, threat)
+}
+
+// Unwrap returns ErrScanRejected
+func (e *ScanError) Unwrap() error {
+	return ErrScanRejected
+}
+
+// SetScanner installs a Scanner consulted before every file is sealed by SaveEncrypted,
+// SaveEncryptedFrom, batches, journaled jobs and uploads; nil removes it. Scans run with
+// the context given to WithContext. The scanner is shared with FileManagers derived from fm.
+func (fm *FileManager) SetScanner(scanner Scanner) {
+	if scanner == nil {
+		fm.scanner.Store(nil)
+		return
+	}
+	fm.scanner.Store(&scanner)
+}
+
+// scanning reports whether files saved through fm are scanned
+func (fm *FileManager) scanning() bool {
+	return !fm.unchecked && fm.scanner.Load() != nil
+}
+
+// scan runs the scanner on the plaintext of the file about to be written to fullPath
+func (sf *SecureFile) scan(snapshot *configSnapshot, fullPath string, r io.Reader) (ScanResult, error) {
+	scanner := sf.fm.scanner.Load()
+	if scanner == nil || sf.fm.unchecked {
+		return ScanResult{Verdict: ScanAllow}, nil
+	}
+	result, err := (*scanner).Scan(sf.fm.Context(), snapshot.authorizedPath(fullPath), r)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("failed to scan file: %w", err)
+	}
+	return result, nil
+}
+
+// scanWhileReading returns a reader passing r on to the scanner as it is read. finish
+// ends the scan with the error reading ended in, nil once r was read to the end, and
+// returns the result.
+func (sf *SecureFile) scanWhileReading(snapshot *configSnapshot, fullPath string, r io.Reader) (_ io.Reader, finish func(error) (ScanResult, error)) {
+	if !sf.fm.scanning() {
+		return r, func(error) (ScanResult, error) { return ScanResult{Verdict: ScanAllow}, nil }
+	}
+
+	type outcome struct {
+		result ScanResult
+		err    error
+	}
+	pr, pw := io.Pipe()
+	outcomes := make(chan outcome, 1)
+	go func() {
+		result, err := sf.scan(snapshot, fullPath, pr)
+		// Scanners may stop reading early, the rest is drained so that sealing goes on
+		_, _ = io.Copy(io.Discard, pr)
+		outcomes <- outcome{result, err}
+	}()
+
+	return io.TeeReader(r, pw), func(err error) (ScanResult, error) {
+		_ = pw.CloseWithError(err)
+		done := <-outcomes
+		return done.result, done.err
+	}
+}
+
+// screen applies the content policy and the scanner to Data before it is written to fullPath.
+// Quarantined data is sealed below the quarantine directory and reported as a ScanError.
+func (sf *SecureFile) screen(snapshot *configSnapshot, fullPath string) error {
+	if err := sf.checkContent(snapshot, fullPath, contentHead(sf.Data)); err != nil {
+		return err
+	}
+	result, err := sf.scan(snapshot, fullPath, bytes.NewReader(sf.Data))
+	if err != nil {
+		return err
+	}
+	if result.Verdict != ScanQuarantine {
+		return sf.verdictError(result, "")
+	}
+
+	target, err := snapshot.quarantineTarget(fullPath)
+	if err == nil {
+		err = writeFileAtomic(target, sf.writeSealed(snapshot))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to quarantine file: %w", err)
+	}
+	return sf.verdictError(result, target)
+}
+
+// verdictError reports a scan result, nil when the file is allowed
+func (sf *SecureFile) verdictError(result ScanResult, quarantinePath string) error {
+	if result.Verdict == ScanAllow {
+		return nil
+	}
+	return &ScanError{Filename: sf.Filename, Result: result, QuarantinePath: quarantinePath}
+}
+
+// quarantineTarget returns the path a quarantined file is sealed to instead of fullPath.
+// Quarantined files keep their path below the quarantine directory, their names are
+// prefixed with the time of quarantine so that files quarantined earlier are kept.
+func (s *configSnapshot) quarantineTarget(fullPath string) (string, error) {
+	if s.config.QuarantineDir == "" {
+		return "", fmt.Errorf("quarantine directory is not configured")
+	}
+	quarantineDir, err := s.resolveDir(s.config.QuarantineDir)
+	if err != nil {
+		return "", err
+	}
+	rel := strings.TrimPrefix(s.authorizedPath(fullPath), "/")
+	target, err := resolveWithin(quarantineDir, filepath.FromSlash(rel))
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(target)
+	if err := EnsureDirectory(dir); err != nil {
+		return "", err
+	}
+	stamp := time.Now().UTC().Format("20060102T150405.000000000Z")
+	return filepath.Join(dir, stamp+"-"+filepath.Base(target)), nil
+}
@@ -0,0 +1,31 @@
+package sealfile
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newTestFileManager creates a FileManager confined to a temporary root directory.
+// configure may adjust the configuration before the FileManager is created.
+func newTestFileManager(t *testing.T, configure func(*Config)) *FileManager {
+	t.Helper()
+	root := t.TempDir()
+	config := DefaultConfig()
+	config.EncryptionKey = "test key of exactly thirty-two b"
+	config.RootDir = root
+	config.PublicDir = "public"
+	config.TempDir = "temp"
+	if configure != nil {
+		configure(config)
+	}
+	fm, err := NewFileManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fm
+}
+
+// rootPath returns the location of a slash-separated path below the root directory of fm
+func rootPath(fm *FileManager, path string) string {
+	return filepath.Join(fm.current().config.RootDir, filepath.FromSlash(path))
+}
@@ -1,101 +1,451 @@
 package sealfile
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-// SecureFile represents a file with encryption capabilities
+// MetadataTenant is the metadata key holding the tenant a file was sealed for
+const MetadataTenant = "tenant"
+
+// SecureFile represents a file with encryption capabilities.
+// Every operation uses the configuration of its FileManager at the time the operation starts.
 type SecureFile struct {
-	Path       string
-	Filename   string
-	Extension  string
-	Data       []byte
-	config     *Config
-	encryptor  *Encryptor
-	compressor *Compressor
+	Path      string
+	Filename  string
+	Extension string
+	Data      []byte
+	Metadata  map[string]string // Stored authenticated in the sealed file, set again when loading
+	Stripped  *StripReport      // Image metadata removed by the last save, see Config.StripImageMetadata
+	fm        *FileManager
 }
 
 // NewSecureFile creates a new SecureFile instance (internal use)
-func newSecureFile(data []byte, path, filename string, config *Config, encryptor *Encryptor, compressor *Compressor) *SecureFile {
+func newSecureFile(data []byte, path, filename string, fm *FileManager) *SecureFile {
 	return &SecureFile{
-		Path:       path,
-		Filename:   filename,
-		Extension:  filepath.Ext(filename),
-		Data:       data,
-		config:     config,
-		encryptor:  encryptor,
-		compressor: compressor,
+		Path:      path,
+		Filename:  filename,
+		Extension: filepath.Ext(filename),
+		Data:      data,
+		fm:        fm,
+	}
+}
+
+// SaveEncrypted seals the file data into the file. Files carrying metadata, and every file
+// with Config.SealStreams, are written as sealed streams recording the key check of the key;
+// other files are encrypted and compressed as a whole.
+func (sf *SecureFile) SaveEncrypted() (err error) {
+	defer sf.wrapError("seal", &err)
+	return sf.saveEncrypted(false)
+}
+
+// saveEncrypted seals the file data into the file, as a sealed stream when stream is set
+// and otherwise as SaveEncrypted decides
+func (sf *SecureFile) saveEncrypted(stream bool) error {
+	snapshot := sf.fm.current()
+	dir, fullPath, err := sf.access(AccessSave, snapshot)
+	if err != nil {
+		return err
+	}
+	if err := sf.screen(snapshot, fullPath); err != nil {
+		return err
+	}
+
+	// Ensure directory exists
+	if err := EnsureDirectory(dir); err != nil {
+		return err
+	}
+
+	// Write to file
+	write := sf.writeSealedAs(snapshot, stream || snapshot.sealsStream(sf))
+	if _, err := snapshot.config.Retry.DoContext(sf.fm.Context(), func() error {
+		return writeFileAtomic(fullPath, write)
+	}); err != nil {
+		return err
+	}
+
+	if snapshot.wantsThumbnails(sf.Filename) {
+		return sf.writeThumbnails(snapshot, sf.Data)
 	}
+	return nil
 }
 
-// SaveEncrypted saves the file with encryption and compression
-func (sf *SecureFile) SaveEncrypted() error {
+// writeSealed returns a function sealing the file data with the given snapshot into w
+func (sf *SecureFile) writeSealed(snapshot *configSnapshot) func(w io.Writer) error {
+	return sf.writeSealedAs(snapshot, snapshot.sealsStream(sf))
+}
+
+// writeSealedAs returns a function sealing the file data into w, as a sealed stream when
+// stream is set and encrypted and compressed as a whole otherwise
+func (sf *SecureFile) writeSealedAs(snapshot *configSnapshot, stream bool) func(w io.Writer) error {
+	return func(w io.Writer) error {
+		if stream {
+			return sf.sealStream(snapshot, w, bytes.NewReader(sf.Data))
+		}
+
+		sealed, err := sf.seal(snapshot)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(sealed); err != nil {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		return nil
+	}
+}
+
+// sealsStream reports whether the file is sealed as a sealed stream rather than as a whole
+func (s *configSnapshot) sealsStream(sf *SecureFile) bool {
+	return s.config.SealStreams || sf.sealMetadata(s) != nil
+}
+
+// seal returns the encrypted and compressed representation of the file data,
+// stripping image metadata when configured
+func (sf *SecureFile) seal(snapshot *configSnapshot) ([]byte, error) {
+	data := sf.Data
+	if snapshot.stripsImage(sf.Filename) {
+		var stripped bytes.Buffer
+		report, err := StripImageMetadata(&stripped, bytes.NewReader(data))
+		sf.Stripped = report
+		if err != nil {
+			return nil, err
+		}
+		data = stripped.Bytes()
+	}
+
 	// Encrypt the data
-	encrypted, err := sf.encryptor.Encrypt(sf.Data)
+	encrypted, err := snapshot.encryptor.Encrypt(data)
 	if err != nil {
-		return fmt.Errorf("failed to encrypt data: %w", err)
+		return nil, fmt.Errorf("failed to encrypt data: %w", err)
+	}
+
+	// Compress the encrypted data, recording the key check in the gzip header
+	compressed, err := sf.fm.compressor.compressWithKeyCheck(encrypted, snapshot.encryptor.keyCheck)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress data: %w", err)
 	}
 
-	// Compress the encrypted data
-	compressed, err := sf.compressor.Compress(encrypted)
+	return compressed, nil
+}
+
+// SaveEncryptedFrom seals everything read from r into the file as a sealed stream.
+// The content is never held in memory as a whole and Data is left untouched.
+func (sf *SecureFile) SaveEncryptedFrom(r io.Reader) (err error) {
+	defer sf.wrapError("seal", &err)
+	return sf.saveEncryptedFrom(r, nil, false)
+}
+
+// saveEncryptedFrom seals r into the file. When given, precondition is checked right before
+// the sealed file is moved into place and fails the save with its error. With exclusive,
+// an existing file is never replaced and ErrAlreadyExists is returned instead.
+func (sf *SecureFile) saveEncryptedFrom(r io.Reader, precondition func(fullPath string) error, exclusive bool) (err error) {
+	snapshot := sf.fm.current()
+	dir, fullPath, err := sf.access(AccessSave, snapshot)
 	if err != nil {
-		return fmt.Errorf("failed to compress data: %w", err)
+		return err
+	}
+	if r, err = sf.checkContentFrom(snapshot, fullPath, r); err != nil {
+		return err
 	}
 
 	// Ensure directory exists
-	if err := sf.ensureDirectory(); err != nil {
+	if err := EnsureDirectory(dir); err != nil {
 		return err
 	}
 
-	// Write to file
-	fullPath := filepath.Join(sf.Path, sf.Filename)
-	if err := os.WriteFile(fullPath, compressed, 0644); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	// Scanned while sealing, the sealed file goes into place or into quarantine once the verdict is in
+	var quarantined error
+	if err := writeFileRouted(fullPath, exclusive, func(w io.Writer) (string, error) {
+		scanned, finish := sf.scanWhileReading(snapshot, fullPath, r)
+		sealErr := sf.sealStream(snapshot, w, scanned)
+		result, err := finish(sealErr)
+		if sealErr != nil {
+			return "", sealErr
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch result.Verdict {
+		case ScanAllow:
+			if precondition != nil {
+				if err := precondition(fullPath); err != nil {
+					return "", err
+				}
+			}
+			return fullPath, nil
+		case ScanQuarantine:
+			target, err := snapshot.quarantineTarget(fullPath)
+			if err != nil {
+				return "", fmt.Errorf("failed to quarantine file: %w", err)
+			}
+			quarantined = sf.verdictError(result, target)
+			return target, nil
+		default:
+			return "", sf.verdictError(result, "")
+		}
+	}); err != nil {
+		return err
+	}
+	if quarantined != nil {
+		return quarantined
+	}
+
+	// The content was not kept, so thumbnails are made from the sealed file
+	if snapshot.wantsThumbnails(sf.Filename) {
+		return sf.writeThumbnails(snapshot, nil)
+	}
+	return nil
+}
+
+// sealStream copies r into w through a seal writer, stripping image metadata when configured
+func (sf *SecureFile) sealStream(snapshot *configSnapshot, w io.Writer, r io.Reader) error {
+	if snapshot.stripsImage(sf.Filename) {
+		stripped, reports := stripWhileReading(r)
+		defer func() {
+			// Closing stops the stripping when sealing failed
+			_ = stripped.Close()
+			sf.Stripped = <-reports
+		}()
+		r = stripped
+	}
+
+	sw, err := snapshot.encryptor.NewSealWriterWithMetadata(w, sf.sealMetadata(snapshot))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt data: %w", err)
 	}
+	if _, err := io.Copy(sw, r); err != nil {
+		// Errors of the stripping arrive through the pipe and are reported as they are
+		if errors.Is(err, errMalformedImage) {
+			return err
+		}
+		return fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	if err := sw.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt data: %w", err)
+	}
+	return nil
+}
+
+// sealMetadata returns the metadata to store in the sealed file, nil when there is none
+func (sf *SecureFile) sealMetadata(snapshot *configSnapshot) map[string]string {
+	tenant := snapshot.config.TenantID
+	if len(sf.Metadata) == 0 && tenant == "" {
+		return nil
+	}
+
+	metadata := make(map[string]string, len(sf.Metadata)+1)
+	for key, value := range sf.Metadata {
+		metadata[key] = value
+	}
+	if tenant != "" {
+		metadata[MetadataTenant] = tenant
+	}
+	return metadata
+}
 
+// acceptMetadata checks the metadata of an opened file and makes it available.
+// With a tenant configured, files sealed for no tenant are refused like those of another one.
+func (sf *SecureFile) acceptMetadata(snapshot *configSnapshot, metadata map[string]string) error {
+	tenant := snapshot.config.TenantID
+	if tenant != "" && metadata[MetadataTenant] != tenant {
+		return fmt.Errorf("%w: %s", ErrTenantMismatch, sf.Filename)
+	}
+	sf.Metadata = metadata
 	return nil
 }
 
+// OpenDecrypted opens the file for reading its decrypted content.
+// Sealed streams are decrypted while reading, other files are decrypted up front.
+func (sf *SecureFile) OpenDecrypted() (_ io.ReadCloser, err error) {
+	defer sf.wrapError("open", &err)
+	snapshot := sf.fm.current()
+	file, stream, err := sf.openFile(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return sf.decryptSeeker(snapshot, file, stream)
+}
+
+// OpenDecryptedSeeker opens the file for reading its decrypted content at any position.
+// Sealed streams only decrypt the chunks that are read, other files are decrypted up front.
+func (sf *SecureFile) OpenDecryptedSeeker() (_ io.ReadSeekCloser, err error) {
+	defer sf.wrapError("open", &err)
+	snapshot := sf.fm.current()
+	file, stream, err := sf.openFile(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return sf.decryptSeeker(snapshot, file, stream)
+}
+
+// decryptSeeker decrypts a sealed file opened by openFile for reading at any position.
+// The returned reader closes file, which is closed right away on errors.
+func (sf *SecureFile) decryptSeeker(snapshot *configSnapshot, file *os.File, stream bool) (io.ReadSeekCloser, error) {
+	if stream {
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		reader, err := snapshot.encryptor.NewSeekReader(file, info.Size())
+		if err == nil {
+			err = sf.acceptMetadata(snapshot, reader.Metadata())
+		}
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		return struct {
+			io.ReadSeeker
+			io.Closer
+		}{reader, file}, nil
+	}
+
+	// Other files are decrypted from the same open file, not from the path again
+	sealed, err := io.ReadAll(file)
+	_ = file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := sf.unseal(snapshot, sealed); err != nil {
+		return nil, err
+	}
+	return struct {
+		io.ReadSeeker
+		io.Closer
+	}{bytes.NewReader(sf.Data), io.NopCloser(nil)}, nil
+}
+
+// openFile opens the sealed file and reports whether it holds a sealed stream
+func (sf *SecureFile) openFile(snapshot *configSnapshot) (*os.File, bool, error) {
+	_, fullPath, err := sf.access(AccessLoad, snapshot)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var file *os.File
+	_, err = snapshot.config.Retry.DoContext(sf.fm.Context(), func() error {
+		var openErr error
+		file, openErr = os.Open(fullPath)
+		return openErr
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	head := make([]byte, len(streamMagic))
+	n, _ := io.ReadFull(file, head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, false, fmt.Errorf("failed to read file: %w", err)
+	}
+	return file, IsSealedStream(head[:n]), nil
+}
+
 // LoadDecrypted loads and decrypts a file
 func (sf *SecureFile) LoadDecrypted() error {
-	fullPath := filepath.Join(sf.Path, sf.Filename)
+	err := sf.loadDecrypted(sf.fm.current())
+	sf.wrapError("unseal", &err)
+	return err
+}
+
+// loadDecrypted loads and decrypts a file with the keys of the given snapshot
+func (sf *SecureFile) loadDecrypted(snapshot *configSnapshot) error {
+	_, err := sf.loadRevision(snapshot)
+	return err
+}
+
+// loadRevision loads and decrypts a file with the keys of the given snapshot
+// and returns the revision of the sealed bytes it decrypted
+func (sf *SecureFile) loadRevision(snapshot *configSnapshot) (string, error) {
+	_, fullPath, err := sf.access(AccessLoad, snapshot)
+	if err != nil {
+		return "", err
+	}
 
 	// Read compressed data
-	compressed, err := os.ReadFile(fullPath)
+	var compressed []byte
+	_, err = snapshot.config.Retry.DoContext(sf.fm.Context(), func() error {
+		var readErr error
+		compressed, readErr = os.ReadFile(fullPath)
+		return readErr
+	})
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if err := sf.unseal(snapshot, compressed); err != nil {
+		return "", err
+	}
+	return sealedRevision(compressed), nil
+}
+
+// unseal decrypts sealed bytes read from the file into Data
+func (sf *SecureFile) unseal(snapshot *configSnapshot, compressed []byte) error {
+	// Sealed streams are not compressed and are opened chunk by chunk
+	if IsSealedStream(compressed) {
+		reader, err := snapshot.encryptor.NewOpenReader(bytes.NewReader(compressed))
+		if err != nil {
+			return err
+		}
+		if err := sf.acceptMetadata(snapshot, reader.Metadata()); err != nil {
+			return err
+		}
+		if sf.Data, err = io.ReadAll(reader); err != nil {
+			return err
+		}
+		return nil
 	}
 
 	// Decompress data
-	encrypted, err := sf.compressor.Decompress(compressed)
+	encrypted, err := sf.fm.compressor.Decompress(compressed)
 	if err != nil {
 		return fmt.Errorf("failed to decompress data: %w", err)
 	}
 
 	// Decrypt data
-	sf.Data, err = sf.encryptor.Decrypt(encrypted)
+	data, err := snapshot.encryptor.decryptChecked(encrypted, gzipKeyCheck(compressed))
 	if err != nil {
 		return fmt.Errorf("failed to decrypt data: %w", err)
 	}
 
+	// Whole-file sealing carries no metadata
+	if err := sf.acceptMetadata(snapshot, nil); err != nil {
+		return err
+	}
+	sf.Data = data
 	return nil
 }
 
 // Delete removes the secure file from disk
-func (sf *SecureFile) Delete() error {
-	fullPath := filepath.Join(sf.Path, sf.Filename)
-	if err := os.Remove(fullPath); err != nil {
+func (sf *SecureFile) Delete() (err error) {
+	defer sf.wrapError("delete", &err)
+	snapshot := sf.fm.current()
+	_, fullPath, err := sf.access(AccessDelete, snapshot)
+	if err != nil {
+		return err
+	}
+
+	if _, err := snapshot.config.Retry.DoContext(sf.fm.Context(), func() error {
+		return os.Remove(fullPath)
+	}); err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+
+	if snapshot.wantsThumbnails(sf.Filename) {
+		sf.removeThumbnails(snapshot)
+	}
 	return nil
 }
 
 // GetPath returns the file path based on the configured path type
 func (sf *SecureFile) GetPath() string {
-	switch sf.config.PathType {
+	switch sf.fm.current().config.PathType {
 	case HTTPPath:
 		return sf.GetURL()
 	default:
@@ -105,12 +455,30 @@ func (sf *SecureFile) GetPath() string {
 
 // GetURL returns the HTTP URL for the file
 func (sf *SecureFile) GetURL() string {
-	relativePath := strings.TrimPrefix(sf.Path, sf.config.PublicDir)
+	snapshot := sf.fm.current()
+	config := snapshot.config
+
+	// Inside a root directory both paths are resolved before comparing them
+	if config.RootDir != "" {
+		dir, dirErr := snapshot.resolveDir(sf.Path)
+		public, publicErr := snapshot.resolveDir(config.PublicDir)
+		if dirErr == nil && publicErr == nil {
+			if rel, err := filepath.Rel(public, dir); err == nil && isWithin(public, dir) {
+				relativePath := ""
+				if rel != "." {
+					relativePath = "/" + filepath.ToSlash(rel)
+				}
+				return fmt.Sprintf("%s%s/%s", config.BaseURL, relativePath, sf.Filename)
+			}
+		}
+	}
+
+	relativePath := strings.TrimPrefix(sf.Path, config.PublicDir)
 	relativePath = strings.TrimPrefix(relativePath, "/")
 	if relativePath != "" && !strings.HasPrefix(relativePath, "/") {
 		relativePath = "/" + relativePath
 	}
-	return fmt.Sprintf("%s%s/%s", sf.config.BaseURL, relativePath, sf.Filename)
+	return fmt.Sprintf("%s%s/%s", config.BaseURL, relativePath, sf.Filename)
 }
 
 // GetDirectoryPath returns just the directory path
@@ -118,17 +486,74 @@ func (sf *SecureFile) GetDirectoryPath() string {
 	return sf.Path
 }
 
-// GetFullPath returns the complete file path
+// GetFullPath returns the complete file path, resolved inside the root directory when one is configured
 func (sf *SecureFile) GetFullPath() string {
+	if _, fullPath, err := sf.locate(sf.fm.current()); err == nil {
+		return fullPath
+	}
 	return filepath.Join(sf.Path, sf.Filename)
 }
 
-// ensureDirectory creates the directory if it doesn't exist
-func (sf *SecureFile) ensureDirectory() error {
-	if _, err := os.Stat(sf.Path); os.IsNotExist(err) {
-		if err := os.MkdirAll(sf.Path, 0755); err != nil {
-			return fmt.Errorf("failed to create directory: %w", err)
+// wrapError turns a failed operation on the file into a SealError
+func (sf *SecureFile) wrapError(op string, err *error) {
+	*err = newSealError(op, sf.GetFullPath(), *err)
+}
+
+// locate resolves the directory and full path of the file
+func (sf *SecureFile) locate(snapshot *configSnapshot) (string, string, error) {
+	return snapshot.resolveFile(sf.Path, sf.Filename)
+}
+
+// access resolves the directory and full path of the file once op is authorized on it
+func (sf *SecureFile) access(op AccessOp, snapshot *configSnapshot) (string, string, error) {
+	dir, fullPath, err := sf.locate(snapshot)
+	if err != nil {
+		return "", "", err
+	}
+	if err := sf.fm.authorize(op, fullPath); err != nil {
+		return "", "", err
+	}
+	return dir, fullPath, nil
+}
+
+// checkSave validates without side effects that the file could be saved.
+// It reports whether the file already exists and would be overwritten.
+// The content policy is applied when Data is set.
+func (sf *SecureFile) checkSave() (_ bool, err error) {
+	defer sf.wrapError("seal", &err)
+	snapshot := sf.fm.current()
+	dir, fullPath, err := sf.access(AccessSave, snapshot)
+	if err != nil {
+		return false, err
+	}
+	if sf.Data != nil {
+		if err := sf.checkContent(snapshot, fullPath, contentHead(sf.Data)); err != nil {
+			return false, err
 		}
 	}
+
+	info, err := os.Stat(fullPath)
+	if err == nil {
+		if info.IsDir() {
+			return true, fmt.Errorf("failed to write file: %s is a directory", fullPath)
+		}
+		return true, nil
+	}
+	if err := checkWritableDirectory(dir, true); err != nil {
+		return false, fmt.Errorf("failed to create directory: %w", err)
+	}
+	return false, nil
+}
+
+// checkDelete validates without side effects that the file could be deleted
+func (sf *SecureFile) checkDelete() (err error) {
+	defer sf.wrapError("delete", &err)
+	_, fullPath, err := sf.access(AccessDelete, sf.fm.current())
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(fullPath); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,136 @@
+package sealfile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSaveEncryptedFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		streams  bool
+		metadata map[string]string
+		want     FileFormat
+	}{
+		{"whole file by default", false, nil, FormatLegacy},
+		{"stream for metadata", false, map[string]string{"owner": "a"}, FormatStream},
+		{"stream when configured", true, nil, FormatStream},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fm := newTestFileManager(t, func(c *Config) { c.SealStreams = test.streams })
+			sf := fm.NewSecureFile([]byte("content"), "files", "a.txt")
+			sf.Metadata = test.metadata
+			if err := sf.SaveEncrypted(); err != nil {
+				t.Fatal(err)
+			}
+			if format, _ := detectFormat(rootPath(fm, "files/a.txt")); format != test.want {
+				t.Fatalf("format %v, want %v", format, test.want)
+			}
+
+			loaded, err := fm.LoadSecureFileFromDisk("files", "a.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(loaded.Data, []byte("content")) || loaded.Metadata["owner"] != test.metadata["owner"] {
+				t.Fatalf("loaded %q with metadata %v", loaded.Data, loaded.Metadata)
+			}
+
+			config := fm.GetConfig()
+			config.EncryptionKey = "another key"
+			other, err := NewFileManager(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = other.LoadSecureFileFromDisk("files", "a.txt")
+			if !errors.Is(err, ErrWrongKey) || !errors.Is(err, ErrAuthenticationFailed) {
+				t.Fatalf("wrong key: got %v, want ErrWrongKey", err)
+			}
+		})
+	}
+}
+
+func TestWholeFileKeyCheck(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	if _, err := fm.SaveDataAsSecureFile([]byte("content"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	path := rootPath(fm, "files/a.txt")
+
+	entries, err := fm.ListFiles("files")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].KeyCheck != fm.current().encryptor.KeyCheck() {
+		t.Fatalf("listed %+v", entries)
+	}
+
+	// Tampered data under the right key is corrupted, not sealed with another key
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := fm.compressor.Decompress(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted[len(encrypted)-1] ^= 1
+	tampered, err := fm.compressor.compressWithKeyCheck(encrypted, fm.current().encryptor.keyCheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, tampered, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = fm.LoadSecureFileFromDisk("files", "a.txt")
+	if !errors.Is(err, ErrCorrupted) || errors.Is(err, ErrWrongKey) {
+		t.Fatalf("tampered file: got %v, want ErrCorrupted", err)
+	}
+
+	// Files of earlier versions carry no key check and still load
+	encrypted[len(encrypted)-1] ^= 1
+	earlier, err := fm.compressor.Compress(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, earlier, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if format, keyCheck := detectFormat(path); format != FormatLegacy || keyCheck != "" {
+		t.Fatalf("detected %v with key check %q", format, keyCheck)
+	}
+	loaded, err := fm.LoadSecureFileFromDisk("files", "a.txt")
+	if err != nil || string(loaded.Data) != "content" {
+		t.Fatalf("loaded %v, %v", loaded, err)
+	}
+	other, err := NewFileManager(withKey(fm, otherKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.LoadSecureFileFromDisk("files", "a.txt"); !errors.Is(err, ErrAuthenticationFailed) || errors.Is(err, ErrWrongKey) {
+		t.Fatalf("wrong key without key check: got %v", err)
+	}
+}
+
+func TestStripFailureIsReportedAsStripError(t *testing.T) {
+	for _, streams := range []bool{false, true} {
+		fm := newTestFileManager(t, func(c *Config) {
+			c.StripImageMetadata = true
+			c.SealStreams = streams
+		})
+		truncated := []byte{0xff, 0xd8, 0xff, 0xe1, 0x00}
+		err := fm.NewSecureFile(truncated, "images", "photo.jpg").SaveEncrypted()
+		if !errors.Is(err, errMalformedImage) {
+			t.Fatalf("streams %v: got %v, want a malformed image", streams, err)
+		}
+		if !strings.Contains(err.Error(), "failed to strip image metadata") || strings.Contains(err.Error(), "encrypt") {
+			t.Fatalf("streams %v: got %q", streams, err)
+		}
+		if exists(rootPath(fm, "images/photo.jpg")) {
+			t.Fatalf("streams %v: malformed image was saved", streams)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package sealfile
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// SeekReader decrypts a sealed stream with random access. Chunks sit at fixed offsets,
+// so a read only decrypts the chunks covering the requested bytes. Every chunk read is
+// authenticated; a stream cut short at a chunk boundary is detected once its end is read.
+type SeekReader struct {
+	r       io.ReaderAt
+	e       *Encryptor
+	header  *streamHeader
+	offset  int64 // Position of the first chunk
+	chunks  int64
+	size    int64 // Plaintext size
+	pos     int64
+	buf     []byte
+	plain   []byte // Plaintext of the chunk at index current
+	current int64
+}
+
+// NewSeekReader returns a reader that decrypts the sealed stream of the given size read from r.
+// Like NewOpenReader, it opens the first chunk right away to authenticate the key and the header.
+func (e *Encryptor) NewSeekReader(r io.ReaderAt, size int64) (*SeekReader, error) {
+	header, err := readStreamHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkKey(header); err != nil {
+		return nil, err
+	}
+
+	// Every chunk but the last is full, and even empty data is sealed as one chunk
+	offset := int64(len(header.raw))
+	sealedChunk := int64(streamChunkSize + streamTagOverhead)
+	body := size - offset
+	chunks := (body + sealedChunk - 1) / sealedChunk
+	if chunks == 0 || body-(chunks-1)*sealedChunk < streamTagOverhead {
+		return nil, mark(ErrCorrupted, fmt.Errorf("sealed stream truncated"))
+	}
+	if chunks > math.MaxUint32 {
+		return nil, mark(ErrCorrupted, fmt.Errorf("sealed stream too large"))
+	}
+
+	sr := &SeekReader{
+		r:       r,
+		e:       e,
+		header:  header,
+		offset:  offset,
+		chunks:  chunks,
+		size:    body - chunks*streamTagOverhead,
+		buf:     make([]byte, sealedChunk),
+		current: -1,
+	}
+	if err := sr.load(0); err != nil {
+		return nil, err
+	}
+	return sr, nil
+}
+
+// Metadata returns the authenticated metadata stored in the stream header
+func (sr *SeekReader) Metadata() map[string]string {
+	return sr.header.metadata
+}
+
+// Size returns the size of the plaintext
+func (sr *SeekReader) Size() int64 {
+	return sr.size
+}
+
+// Read returns decrypted data from the current position
+func (sr *SeekReader) Read(p []byte) (int, error) {
+	if sr.pos >= sr.size {
+		return 0, io.EOF
+	}
+
+	index := sr.pos / streamChunkSize
+	if err := sr.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, sr.plain[sr.pos-index*streamChunkSize:])
+	sr.pos += int64(n)
+	return n, nil
+}
+
+// Seek sets the position of the next Read
+func (sr *SeekReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sr.pos
+	case io.SeekEnd:
+		offset += sr.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	sr.pos = offset
+	return offset, nil
+}
+
+// load reads and opens the chunk with the given index unless it is already open
+func (sr *SeekReader) load(index int64) error {
+	if index == sr.current {
+		return nil
+	}
+
+	sealedChunk := int64(len(sr.buf))
+	length := sealedChunk
+	last := index == sr.chunks-1
+	if last {
+		length = sr.size + sr.chunks*streamTagOverhead - index*sealedChunk
+	}
+	chunk := sr.buf[:length]
+	if n, err := sr.r.ReadAt(chunk, sr.offset+index*sealedChunk); n < len(chunk) {
+		if errors.Is(err, io.EOF) {
+			return mark(ErrCorrupted, fmt.Errorf("sealed stream truncated"))
+		}
+		return fmt.Errorf("failed to read sealed chunk: %w", err)
+	}
+
+	sr.current = -1
+	plain, err := sr.e.openChunk(sr.header, chunk, uint32(index), last)
+	if err != nil {
+		return err
+	}
+	sr.plain = plain
+	sr.current = index
+	return nil
+}
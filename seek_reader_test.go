@@ -0,0 +1,151 @@
+package sealfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestSeekReaderAcrossChunks(t *testing.T) {
+	e := newTestEncryptor(t, "seek reader key")
+	data := randomBytes(t, 3*streamChunkSize+17)
+	sealed := sealForTest(t, e, data, nil)
+
+	sr, err := e.NewSeekReader(bytes.NewReader(sealed), int64(len(sealed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.Size() != int64(len(data)) {
+		t.Fatalf("size %d, want %d", sr.Size(), len(data))
+	}
+
+	tests := []struct {
+		name   string
+		offset int64
+		whence int
+		length int
+		want   int64 // Position the read starts at
+	}{
+		{"start", 0, io.SeekStart, 10, 0},
+		{"across the first boundary", streamChunkSize - 5, io.SeekStart, 10, streamChunkSize - 5},
+		{"back into the first chunk", 3, io.SeekStart, 10, 3},
+		{"across two boundaries", streamChunkSize - 1, io.SeekStart, streamChunkSize + 2, streamChunkSize - 1},
+		{"relative", 100, io.SeekCurrent, 10, 2*streamChunkSize + 101},
+		{"from the end", -7, io.SeekEnd, 7, int64(len(data)) - 7},
+		{"last chunk boundary", 3 * streamChunkSize, io.SeekStart, 17, 3 * streamChunkSize},
+	}
+	for _, test := range tests {
+		pos, err := sr.Seek(test.offset, test.whence)
+		if err != nil || pos != test.want {
+			t.Fatalf("%s: seek to %d, %v, want %d", test.name, pos, err, test.want)
+		}
+		got := make([]byte, test.length)
+		if _, err := io.ReadFull(sr, got); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(got, data[test.want:test.want+int64(test.length)]) {
+			t.Fatalf("%s: read the wrong bytes", test.name)
+		}
+	}
+
+	// Reads at and past the end report EOF, a negative position is refused
+	for _, offset := range []int64{0, 10} {
+		if _, err := sr.Seek(offset, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := sr.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+			t.Fatalf("read %d bytes at end+%d, %v", n, offset, err)
+		}
+	}
+	if _, err := sr.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("seeked before the start")
+	}
+}
+
+func TestSeekReaderEmptyStream(t *testing.T) {
+	e := newTestEncryptor(t, "seek reader key")
+	sealed := sealForTest(t, e, nil, nil)
+
+	sr, err := e.NewSeekReader(bytes.NewReader(sealed), int64(len(sealed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sr.Read(make([]byte, 1)); n != 0 || err != io.EOF || sr.Size() != 0 {
+		t.Fatalf("read %d bytes of %d, %v", n, sr.Size(), err)
+	}
+}
+
+func TestSeekReaderDetectsDamage(t *testing.T) {
+	e := newTestEncryptor(t, "seek reader key")
+	data := randomBytes(t, 2*streamChunkSize+100)
+	sealed := sealForTest(t, e, data, nil)
+	sealedChunk := streamChunkSize + streamTagOverhead
+	lastChunk := len(sealed) - (len(data) - 2*streamChunkSize + streamTagOverhead)
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	flippedLast := bytes.Clone(sealed)
+	flippedLast[lastChunk] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+	}{
+		{"tampered tag of the final chunk", tampered},
+		{"tampered data of the final chunk", flippedLast},
+		{"truncated final chunk", sealed[:len(sealed)-10]},
+		// Cut at a chunk boundary, the former middle chunk is not marked as the last one
+		{"final chunk missing", sealed[:lastChunk]},
+	}
+	for _, test := range tests {
+		sr, err := e.NewSeekReader(bytes.NewReader(test.sealed), int64(len(test.sealed)))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		// The first chunk still reads, the damage shows once the final chunk is read
+		if _, err := io.ReadFull(sr, make([]byte, 10)); err != nil {
+			t.Fatalf("%s: first chunk: %v", test.name, err)
+		}
+		if _, err := sr.Seek(-1, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sr.Read(make([]byte, 1)); !errors.Is(err, ErrCorrupted) && !errors.Is(err, ErrAuthenticationFailed) {
+			t.Fatalf("%s: got %v, want a corrupted stream", test.name, err)
+		}
+	}
+
+	// A stream too short to hold a chunk is refused right away
+	short := sealed[:lastChunk-sealedChunk+streamTagOverhead-1]
+	if _, err := e.NewSeekReader(bytes.NewReader(short), int64(len(short))); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("short stream: got %v, want ErrCorrupted", err)
+	}
+}
+
+func TestOpenDecryptedSeeker(t *testing.T) {
+	for _, streams := range []bool{false, true} {
+		fm := newTestFileManager(t, func(c *Config) { c.SealStreams = streams })
+		data := randomBytes(t, streamChunkSize+100)
+		sf, err := fm.SaveDataAsSecureFile(data, "public/docs", "a.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := sf.OpenDecryptedSeeker()
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset := int64(streamChunkSize + 10)
+		if _, err := reader.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		tail, err := io.ReadAll(reader)
+		if closeErr := reader.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil || !bytes.Equal(tail, data[offset:]) {
+			t.Fatalf("streams %v: seeked read differs: %v", streams, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package sealfile
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query parameters of signed URLs
+const (
+	signedExpires = "expires"
+	signedIP      = "ip"
+	signedMethod  = "method"
+	signedNonce   = "nonce"
+	signedSig     = "sig"
+)
+
+// SignOptions restricts who may use a signed URL and how
+type SignOptions struct {
+	ClientIP string // Only requests from this address are accepted
+	OneTime  bool   // The URL serves a single download
+	Method   string // Only this HTTP method is accepted, GET also allows HEAD
+}
+
+// NonceStore remembers the one-time URLs that were used
+type NonceStore interface {
+	// Redeem marks nonce as used until expires and reports whether it was unused
+	Redeem(nonce string, expires time.Time) (bool, error)
+}
+
+// MemoryNonceStore keeps used nonces in memory until they expire.
+// It suits a single process; use a shared store when several serve the same URLs.
+type MemoryNonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewMemoryNonceStore creates an empty MemoryNonceStore
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{nonces: make(map[string]time.Time)}
+}
+
+// Redeem marks nonce as used and drops nonces whose URLs expired
+func (s *MemoryNonceStore) Redeem(nonce string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for used, until := range s.nonces {
+		if now.After(until) {
+			delete(s.nonces, used)
+		}
+	}
+	if _, used := s.nonces[nonce]; used {
+		return false, nil
+	}
+	s.nonces[nonce] = expires
+	return true, nil
+}
+
+// SetNonceStore replaces the store of used one-time URLs, by default they are kept in memory
+func (fm *FileManager) SetNonceStore(store NonceStore) {
+	fm.nonces.Store(&store)
+}
+
+// SignedURL returns the URL of the file with an expiry and a signature. The handler
+// rejects the URL once ttl passed or when it or its constraints were altered.
+// The URL grants loading the file, so signing needs AccessLoad, see SetAuthorizer.
+func (sf *SecureFile) SignedURL(ttl time.Duration, options SignOptions) (string, error) {
+	snapshot := sf.fm.current()
+	if snapshot.config.BaseURL == "" {
+		return "", fmt.Errorf("failed to sign URL: no base URL configured")
+	}
+	if ttl <= 0 {
+		return "", fmt.Errorf("failed to sign URL: ttl must be positive")
+	}
+
+	// Whoever holds the URL may load the file, so the signer must be allowed to
+	if _, _, err := sf.access(AccessLoad, snapshot); err != nil {
+		return "", fmt.Errorf("failed to sign URL: %w", err)
+	}
+
+	u, err := url.Parse(sf.GetURL())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign URL: %w", err)
+	}
+
+	query := url.Values{}
+	query.Set(signedExpires, strconv.FormatInt(time.Now().Add(ttl).Unix(), 10))
+	if options.ClientIP != "" {
+		ip := net.ParseIP(options.ClientIP)
+		if ip == nil {
+			return "", fmt.Errorf("failed to sign URL: invalid client IP %q", options.ClientIP)
+		}
+		query.Set(signedIP, ip.String())
+	}
+	if options.Method != "" {
+		query.Set(signedMethod, strings.ToUpper(options.Method))
+	}
+	if options.OneTime {
+		nonce := make([]byte, 16)
+		if _, err := rand.Read(nonce); err != nil {
+			return "", fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		query.Set(signedNonce, base64.RawURLEncoding.EncodeToString(nonce))
+	}
+	query.Set(signedSig, signURL(snapshot.signingKeys[0], u.Path, query))
+
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// verifySignedURL checks the signature and constraints of a request. Requests without
+// a signature pass unless signed URLs are required. The nonce of a one-time URL is not
+// redeemed here, see redeemSignedURL.
+func (fm *FileManager) verifySignedURL(r *http.Request) error {
+	snapshot := fm.current()
+	query := r.URL.Query()
+	signature := query.Get(signedSig)
+	if signature == "" {
+		if snapshot.config.RequireSignedURLs {
+			return ErrURLSignature
+		}
+		return nil
+	}
+
+	// Any current or previous signing key is accepted, so keys can be rotated
+	valid := false
+	for _, key := range snapshot.signingKeys {
+		if hmac.Equal([]byte(signURL(key, r.URL.Path, query)), []byte(signature)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return ErrURLSignature
+	}
+
+	expires, err := strconv.ParseInt(query.Get(signedExpires), 10, 64)
+	if err != nil {
+		return ErrURLSignature
+	}
+	if time.Now().Unix() > expires {
+		return ErrURLExpired
+	}
+
+	if ip := query.Get(signedIP); ip != "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		client := net.ParseIP(host)
+		if client == nil || !client.Equal(net.ParseIP(ip)) {
+			return ErrURLSignature
+		}
+	}
+	if method := query.Get(signedMethod); method != "" && method != r.Method {
+		if method != http.MethodGet || r.Method != http.MethodHead {
+			return ErrURLSignature
+		}
+	}
+	return nil
+}
+
+// redeemSignedURL uses up the nonce of a one-time URL verified by verifySignedURL. It is
+// called once the file was opened, so that a request failing before is not charged for it.
+// HEAD requests and URLs without a nonce do not use anything up.
+func (fm *FileManager) redeemSignedURL(r *http.Request) error {
+	query := r.URL.Query()
+	nonce := query.Get(signedNonce)
+	if nonce == "" || r.Method == http.MethodHead {
+		return nil
+	}
+	expires, err := strconv.ParseInt(query.Get(signedExpires), 10, 64)
+	if err != nil {
+		return ErrURLSignature
+	}
+
+	redeemed, err := (*fm.nonces.Load()).Redeem(nonce, time.Unix(expires, 0))
+	if err != nil {
+		return fmt.Errorf("failed to redeem nonce: %w", err)
+	}
+	if !redeemed {
+		return ErrURLUsed
+	}
+	return nil
+}
+
+// signURL computes the signature of a path and the constraint parameters of query
+func signURL(key []byte, path string, query url.Values) string {
+	mac := hmac.New(sha256.New, key)
+	for _, value := range []string{
+		"sealfile url v1",
+		path,
+		query.Get(signedExpires),
+		query.Get(signedIP),
+		query.Get(signedMethod),
+		query.Get(signedNonce),
+	} {
+		mac.Write([]byte(value))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newSigningKeys returns the configured signing keys, or keys derived from the encryption keys
+func newSigningKeys(config *Config, encryptor *Encryptor, previous []*Encryptor) [][]byte {
+	var keys [][]byte
+	if len(config.SigningKeys) > 0 {
+		for _, key := range config.SigningKeys {
+			keys = append(keys, []byte(key))
+		}
+		return keys
+	}
+	for _, e := range append([]*Encryptor{encryptor}, previous...) {
+		keys = append(keys, e.signingKey())
+	}
+	return keys
+}
+
+// signingKey derives the key used to sign URLs
+func (e *Encryptor) signingKey() []byte {
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte("sealfile url signing"))
+	return mac.Sum(nil)
+}
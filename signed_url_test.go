@@ -0,0 +1,178 @@
+package sealfile
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// signedRequest builds a request for a signed URL
+func signedRequest(t *testing.T, method, signed string) *http.Request {
+	t.Helper()
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewRequest(method, u.RequestURI(), nil)
+}
+
+func TestSignedURL(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	if _, err := fm.SaveDataAsSecureFile([]byte("secret"), "public/docs", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	sf := fm.NewSecureFile(nil, "public/docs", "a.txt")
+
+	signed, err := sf.SignedURL(time.Hour, SignOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.verifySignedURL(signedRequest(t, http.MethodGet, signed)); err != nil {
+		t.Fatalf("valid URL refused: %v", err)
+	}
+
+	// The handler serves the file behind it
+	w := httptest.NewRecorder()
+	fm.Handler().ServeHTTP(w, signedRequest(t, http.MethodGet, signed))
+	if w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Fatalf("handler answered %d: %q", w.Code, w.Body)
+	}
+}
+
+func TestSignedURLTampered(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	sf := fm.NewSecureFile(nil, "public/docs", "a.txt")
+	signed, err := sf.SignedURL(time.Hour, SignOptions{Method: http.MethodGet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(signed)
+
+	tamper := map[string]func(u *url.URL, query url.Values){
+		"path": func(u *url.URL, _ url.Values) { u.Path += "x" },
+		"expiry": func(_ *url.URL, query url.Values) {
+			query.Set(signedExpires, strconv.FormatInt(time.Now().Add(48*time.Hour).Unix(), 10))
+		},
+		"method":    func(_ *url.URL, query url.Values) { query.Set(signedMethod, http.MethodPut) },
+		"signature": func(_ *url.URL, query url.Values) { query.Set(signedSig, query.Get(signedSig)[1:]+"A") },
+		"other key": func(_ *url.URL, query url.Values) {
+			query.Set(signedSig, signURL([]byte("another key"), u.Path, query))
+		},
+	}
+	for name, change := range tamper {
+		altered := *u
+		query := altered.Query()
+		change(&altered, query)
+		altered.RawQuery = query.Encode()
+		if err := fm.verifySignedURL(signedRequest(t, http.MethodGet, altered.String())); !errors.Is(err, ErrURLSignature) {
+			t.Errorf("%s altered: got %v, want ErrURLSignature", name, err)
+		}
+	}
+}
+
+func TestSignedURLExpired(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	u, err := url.Parse(fm.NewSecureFile(nil, "public/docs", "a.txt").GetURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed with the key of the store, but for a time that has passed
+	query := url.Values{}
+	query.Set(signedExpires, strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	query.Set(signedSig, signURL(fm.current().signingKeys[0], u.Path, query))
+	u.RawQuery = query.Encode()
+
+	if err := fm.verifySignedURL(signedRequest(t, http.MethodGet, u.String())); !errors.Is(err, ErrURLExpired) {
+		t.Fatalf("got %v, want ErrURLExpired", err)
+	}
+}
+
+func TestSignedURLMethod(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	sf := fm.NewSecureFile(nil, "public/docs", "a.txt")
+
+	signed, err := sf.SignedURL(time.Hour, SignOptions{Method: http.MethodGet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for method, want := range map[string]error{
+		http.MethodGet:    nil,
+		http.MethodHead:   nil,
+		http.MethodPut:    ErrURLSignature,
+		http.MethodDelete: ErrURLSignature,
+	} {
+		if err := fm.verifySignedURL(signedRequest(t, method, signed)); !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", method, err, want)
+		}
+	}
+
+	signed, err = sf.SignedURL(time.Hour, SignOptions{Method: http.MethodHead})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fm.verifySignedURL(signedRequest(t, http.MethodGet, signed)); !errors.Is(err, ErrURLSignature) {
+		t.Errorf("GET on a HEAD URL: got %v, want ErrURLSignature", err)
+	}
+}
+
+func TestSignedURLOneTime(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	signed, err := fm.NewSecureFile(nil, "public/docs", "a.txt").SignedURL(time.Hour, SignOptions{OneTime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		fm.Handler().ServeHTTP(w, signedRequest(t, method, signed))
+		return w
+	}
+
+	// A request failing to open the file does not use the URL up
+	if w := serve(http.MethodGet); w.Code != http.StatusNotFound {
+		t.Fatalf("GET of a missing file answered %d", w.Code)
+	}
+	if _, err := fm.SaveDataAsSecureFile([]byte("secret"), "public/docs", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := serve(http.MethodHead); w.Code != http.StatusOK {
+		t.Fatalf("HEAD answered %d", w.Code)
+	}
+	if w := serve(http.MethodGet); w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Fatalf("first GET answered %d: %q", w.Code, w.Body)
+	}
+	if w := serve(http.MethodGet); w.Code != http.StatusForbidden {
+		t.Fatalf("second GET answered %d", w.Code)
+	}
+	if err := fm.redeemSignedURL(signedRequest(t, http.MethodGet, signed)); !errors.Is(err, ErrURLUsed) {
+		t.Fatalf("got %v, want ErrURLUsed", err)
+	}
+}
+
+func TestSignedRequestsSkipOnlyAuthorization(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	fm.SetScanner(ScannerFunc(func(context.Context, string, io.Reader) (ScanResult, error) {
+		return ScanResult{Verdict: ScanAllow}, nil
+	}))
+	fm.SetAuthorizer(AuthorizerFunc(func(context.Context, AccessOp, string) error {
+		return errors.New("denied")
+	}))
+
+	signed := fm.signed()
+	if err := signed.authorize(AccessLoad, rootPath(fm, "public/docs/a.txt")); err != nil {
+		t.Fatalf("signed request not authorized: %v", err)
+	}
+	if !signed.scanning() || signed.unchecked {
+		t.Fatal("signed request skips content checks and scans")
+	}
+	if !signed.WithContext(context.Background()).presigned {
+		t.Fatal("derived FileManager lost the signature")
+	}
+}
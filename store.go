@@ -0,0 +1,54 @@
+package sealfile
+
+import "io"
+
+// Store is the file API shared by FileManager and the HTTP client in package client,
+// so that code can work with a local store and a remote one alike
+type Store interface {
+	SaveStream(r io.Reader, path, filename string, metadata map[string]string) error
+	OpenStream(path, filename string) (io.ReadCloser, map[string]string, error)
+	ListFiles(path string) ([]FileEntry, error)
+	DeleteFile(path, filename string) error
+	CopyFileToNewLocation(sourcePath, sourceFilename, destPath, destFilename string, options CopyOptions) error
+	MoveFile(sourcePath, sourceFilename, destPath, destFilename string, options CopyOptions) error
+	CreateMultipleEncryptedFiles(operations []FileOperation, maxConcurrency int) []FileOperation
+	DecryptMultipleFiles(operations []FileOperation, maxConcurrency int) []FileOperation
+	DeleteMultipleFiles(operations []FileOperation, maxConcurrency int) []FileOperation
+	BatchCopyFiles(copyOperations []CopyOperation, maxConcurrency int) []CopyResult
+}
+
+var _ Store = (*FileManager)(nil)
+
+// SaveStream seals everything read from r into a file with the given metadata
+func (fm *FileManager) SaveStream(r io.Reader, path, filename string, metadata map[string]string) error {
+	sf := fm.NewSecureFile(nil, path, filename)
+	sf.Metadata = metadata
+	return sf.SaveEncryptedFrom(r)
+}
+
+// OpenStream opens a file for reading its decrypted content and returns its metadata
+func (fm *FileManager) OpenStream(path, filename string) (io.ReadCloser, map[string]string, error) {
+	sf := fm.NewSecureFile(nil, path, filename)
+	reader, err := sf.OpenDecrypted()
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, sf.Metadata, nil
+}
+
+// DeleteMultipleFiles deletes the files of a list of file operations
+func (fm *FileManager) DeleteMultipleFiles(operations []FileOperation, maxConcurrency int) []FileOperation {
+	fm = fm.pinned()
+	files := make([]*SecureFile, len(operations))
+	for i, op := range operations {
+		files[i] = fm.NewSecureFile(nil, op.Path, op.Filename)
+	}
+
+	errs := NewBatchProcessor(fm, maxConcurrency).DeleteAllFiles(files)
+	results := make([]FileOperation, len(operations))
+	copy(results, operations)
+	for i := range results {
+		results[i].Error = errs[i]
+	}
+	return results
+}
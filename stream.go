@@ -0,0 +1,416 @@
+package sealfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Sealed stream layout:
+//
+//	magic "SEALFS" | version (1) | flags (1) | chunk size (uint32) | nonce prefix (7)
+//	key check (8) | key salt (32, with streamDerivedKey) | metadata length (uint32) | metadata (JSON object)
+//	chunk 0 | chunk 1 | ... | last chunk
+//
+// Every chunk holds up to chunk size bytes of plaintext sealed with AES-GCM, under the key
+// or, with the streamDerivedKey flag, under a key derived from the key and the salt. The nonce is
+// the prefix followed by the chunk counter and a flag marking the last chunk, and the
+// header is authenticated with every chunk, so chunks cannot be reordered, dropped or
+// moved between files and the metadata cannot be altered. The key check identifies the
+// key a stream was sealed with, so a wrong key is told apart from damaged data. Unlike
+// the whole-file format, a stream is never held in memory. The chunk size is recorded
+// for future use, streams with another chunk size than streamChunkSize are refused.
+const (
+	streamMagic       = "SEALFS"
+	streamVersion     = 1
+	streamPrefixSize  = 7
+	streamHeaderSize  = len(streamMagic) + 2 + 4 + streamPrefixSize
+	streamChunkSize   = 64 * 1024
+	streamTagOverhead = 16
+	streamKeyCheck    = 8
+	streamKeySalt     = 32
+	streamMaxMetadata = 64 * 1024
+
+	streamDerivedKey = 1 << 0 // Flag of streams sealed under a key derived with the key salt
+)
+
+// IsSealedStream reports whether data starts with a sealed stream header
+func IsSealedStream(head []byte) bool {
+	return bytes.HasPrefix(head, []byte(streamMagic))
+}
+
+// streamHeader holds the parsed header of a sealed stream
+type streamHeader struct {
+	raw      []byte
+	prefix   []byte
+	keyCheck []byte
+	keySalt  []byte // Salt of the derived key, nil for streams sealed under the key itself
+	metadata map[string]string
+	aead     cipher.AEAD // Cipher of the chunks, set once the key is known
+}
+
+// newStreamHeader creates a header with a random nonce prefix. With a key salt the stream is
+// sealed under a key derived from it, and the nonce prefix is taken from the salt instead.
+func newStreamHeader(keyCheck, keySalt []byte, metadata map[string]string) (*streamHeader, error) {
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	if len(encoded) > streamMaxMetadata {
+		return nil, fmt.Errorf("metadata exceeds %d bytes", streamMaxMetadata)
+	}
+
+	saltSize := 0
+	if keySalt != nil {
+		saltSize = streamKeySalt
+	}
+	raw := make([]byte, streamHeaderSize+streamKeyCheck+saltSize+4+len(encoded))
+	copy(raw, streamMagic)
+	raw[len(streamMagic)] = streamVersion
+	binary.BigEndian.PutUint32(raw[len(streamMagic)+2:], streamChunkSize)
+	copy(raw[streamHeaderSize:], keyCheck)
+	salt := raw[streamHeaderSize+streamKeyCheck : streamHeaderSize+streamKeyCheck+saltSize]
+	binary.BigEndian.PutUint32(raw[streamHeaderSize+streamKeyCheck+saltSize:], uint32(len(encoded)))
+	copy(raw[streamHeaderSize+streamKeyCheck+saltSize+4:], encoded)
+
+	prefix := raw[streamHeaderSize-streamPrefixSize : streamHeaderSize]
+	header := &streamHeader{
+		raw:      raw,
+		prefix:   prefix,
+		keyCheck: raw[streamHeaderSize : streamHeaderSize+streamKeyCheck],
+		metadata: metadata,
+	}
+	if keySalt != nil {
+		raw[len(streamMagic)+1] = streamDerivedKey
+		copy(salt, keySalt)
+		copy(prefix, keySalt)
+		header.keySalt = salt
+	} else if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return header, nil
+}
+
+// readStreamHeader reads and validates a header from r
+func readStreamHeader(r io.Reader) (*streamHeader, error) {
+	raw := make([]byte, streamHeaderSize+streamKeyCheck+4)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, mark(ErrCorrupted, fmt.Errorf("failed to read stream header: %w", err))
+	}
+	if !IsSealedStream(raw) {
+		return nil, mark(ErrUnsupportedFormat, fmt.Errorf("not a sealed stream"))
+	}
+	if version := raw[len(streamMagic)]; version != streamVersion {
+		return nil, mark(ErrUnsupportedFormat, fmt.Errorf("unsupported sealed stream version %d", version))
+	}
+	if chunkSize := binary.BigEndian.Uint32(raw[len(streamMagic)+2:]); chunkSize != streamChunkSize {
+		return nil, mark(ErrUnsupportedFormat, fmt.Errorf("unsupported sealed stream chunk size %d", chunkSize))
+	}
+	flags := raw[len(streamMagic)+1]
+	if flags&^streamDerivedKey != 0 {
+		return nil, mark(ErrUnsupportedFormat, fmt.Errorf("unsupported sealed stream flags %#x", flags))
+	}
+
+	// With a key salt, the bytes read as metadata length above are its start
+	derived := flags&streamDerivedKey != 0
+	if derived {
+		rest := make([]byte, streamKeySalt)
+		if _, err := io.ReadFull(r, rest); err != nil {
+			return nil, mark(ErrCorrupted, fmt.Errorf("failed to read stream header: %w", err))
+		}
+		raw = append(raw, rest...)
+	}
+
+	size := binary.BigEndian.Uint32(raw[len(raw)-4:])
+	if size > streamMaxMetadata {
+		return nil, mark(ErrCorrupted, fmt.Errorf("invalid sealed stream metadata size %d", size))
+	}
+	encoded := make([]byte, size)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return nil, mark(ErrCorrupted, fmt.Errorf("failed to read stream header: %w", err))
+	}
+	// The metadata is only trusted once the first chunk authenticated the header
+	var metadata map[string]string
+	if err := json.Unmarshal(encoded, &metadata); err != nil {
+		return nil, mark(ErrCorrupted, fmt.Errorf("invalid sealed stream metadata: %w", err))
+	}
+	raw = append(raw, encoded...)
+
+	header := &streamHeader{
+		raw:      raw,
+		prefix:   raw[streamHeaderSize-streamPrefixSize : streamHeaderSize],
+		keyCheck: raw[streamHeaderSize : streamHeaderSize+streamKeyCheck],
+		metadata: metadata,
+	}
+	if derived {
+		header.keySalt = raw[streamHeaderSize+streamKeyCheck : streamHeaderSize+streamKeyCheck+streamKeySalt]
+	}
+	return header, nil
+}
+
+// nonce returns the nonce of the chunk with the given counter
+func (h *streamHeader) nonce(counter uint32, last bool) []byte {
+	nonce := make([]byte, streamPrefixSize+5)
+	copy(nonce, h.prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// sealWriter encrypts everything written to it as a sealed stream
+type sealWriter struct {
+	w       io.Writer
+	e       *Encryptor
+	header  *streamHeader
+	buf     []byte
+	counter uint32
+	closed  bool
+}
+
+// NewSealWriter returns a writer that seals data written to it into w as a sealed stream.
+// Close must be called to write the final chunk; it does not close w.
+func (e *Encryptor) NewSealWriter(w io.Writer) (io.WriteCloser, error) {
+	return e.newSealWriter(w, nil, nil)
+}
+
+// NewSealWriterWithMetadata is like NewSealWriter and stores metadata in the authenticated header
+func (e *Encryptor) NewSealWriterWithMetadata(w io.Writer, metadata map[string]string) (io.WriteCloser, error) {
+	return e.newSealWriter(w, nil, metadata)
+}
+
+// SealDeterministic seals data as a sealed stream under a key derived from the key and a MAC
+// of the data, so equal data always seals to equal bytes. This suits version control, where
+// unchanged files must not change, but reveals which sealed files hold equal content.
+// Different data is sealed under different keys, so nonces are never reused under one key.
+func (e *Encryptor) SealDeterministic(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, e.deterministicKey())
+	mac.Write(data)
+
+	var sealed bytes.Buffer
+	sw, err := e.newSealWriter(&sealed, mac.Sum(nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := sw.Close(); err != nil {
+		return nil, err
+	}
+	return sealed.Bytes(), nil
+}
+
+// deterministicKey derives the key of the MAC that deterministic key salts are taken from
+func (e *Encryptor) deterministicKey() []byte {
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte("sealfile deterministic nonce"))
+	return mac.Sum(nil)
+}
+
+// newSealWriter creates a seal writer, sealing under a key derived with keySalt when it is given
+func (e *Encryptor) newSealWriter(w io.Writer, keySalt []byte, metadata map[string]string) (*sealWriter, error) {
+	header, err := newStreamHeader(e.keyCheck, keySalt, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if header.aead, err = e.streamCipher(header); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header.raw); err != nil {
+		return nil, fmt.Errorf("failed to write stream header: %w", err)
+	}
+	return &sealWriter{
+		w:      w,
+		e:      e,
+		header: header,
+		buf:    make([]byte, 0, streamChunkSize),
+	}, nil
+}
+
+// Write buffers p and seals every completed chunk
+func (sw *sealWriter) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, fmt.Errorf("write to closed seal writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data arrives, so the last chunk is always known
+		if len(sw.buf) == streamChunkSize {
+			if err := sw.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(sw.buf[len(sw.buf):streamChunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the remaining data as the last chunk
+func (sw *sealWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+	return sw.flush(true)
+}
+
+// flush seals the buffered chunk and writes it out
+func (sw *sealWriter) flush(last bool) error {
+	if sw.counter == math.MaxUint32 {
+		return fmt.Errorf("sealed stream too large")
+	}
+
+	nonce := sw.header.nonce(sw.counter, last)
+	sealed := sw.header.aead.Seal(nil, nonce, sw.buf, sw.header.raw)
+	if _, err := sw.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write sealed chunk: %w", err)
+	}
+
+	sw.counter++
+	sw.buf = sw.buf[:0]
+	return nil
+}
+
+// OpenReader decrypts a sealed stream chunk by chunk
+type OpenReader struct {
+	r       *bufio.Reader
+	e       *Encryptor
+	header  *streamHeader
+	chunk   []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// NewOpenReader returns a reader that decrypts the sealed stream read from r.
+// The first chunk is opened right away, so a wrong key or a damaged header fails here.
+func (e *Encryptor) NewOpenReader(r io.Reader) (*OpenReader, error) {
+	br := bufio.NewReader(r)
+	header, err := readStreamHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.checkKey(header); err != nil {
+		return nil, err
+	}
+	or := &OpenReader{
+		r:      br,
+		e:      e,
+		header: header,
+		chunk:  make([]byte, streamChunkSize+streamTagOverhead),
+	}
+
+	// Opening the first chunk authenticates the key and the header up front
+	if err := or.next(); err != nil {
+		return nil, err
+	}
+	return or, nil
+}
+
+// Read returns decrypted data, opening the next chunk when needed
+func (or *OpenReader) Read(p []byte) (int, error) {
+	for len(or.plain) == 0 {
+		if or.done {
+			return 0, io.EOF
+		}
+		if err := or.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, or.plain)
+	or.plain = or.plain[n:]
+	return n, nil
+}
+
+// Metadata returns the authenticated metadata stored in the stream header
+func (or *OpenReader) Metadata() map[string]string {
+	return or.header.metadata
+}
+
+// next reads and opens the following chunk
+func (or *OpenReader) next() error {
+	n, err := io.ReadFull(or.r, or.chunk)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return fmt.Errorf("failed to read sealed chunk: %w", err)
+	default:
+		// A full chunk is the last one when nothing follows it
+		if _, peekErr := or.r.Peek(1); peekErr == io.EOF {
+			last = true
+		}
+	}
+
+	if n < streamTagOverhead {
+		return mark(ErrCorrupted, fmt.Errorf("sealed stream truncated"))
+	}
+
+	plain, err := or.e.openChunk(or.header, or.chunk[:n], or.counter, last)
+	if err != nil {
+		return err
+	}
+
+	or.plain = plain
+	or.counter++
+	or.done = last
+	return nil
+}
+
+// checkKey compares the key check of a stream with the key of the encryptor
+// and prepares the cipher of its chunks
+func (e *Encryptor) checkKey(header *streamHeader) error {
+	if !hmac.Equal(header.keyCheck, e.keyCheck) {
+		return mark(ErrWrongKey, mark(ErrAuthenticationFailed, fmt.Errorf("sealed with a different key")))
+	}
+	var err error
+	header.aead, err = e.streamCipher(header)
+	return err
+}
+
+// streamCipher returns the cipher of a stream: the one of the key, or a cipher under the key
+// derived from the key and the salt of the header
+func (e *Encryptor) streamCipher(header *streamHeader) (cipher.AEAD, error) {
+	if header.keySalt == nil {
+		return e.cipherGCM, nil
+	}
+	mac := hmac.New(sha256.New, e.key)
+	mac.Write([]byte("sealfile derived stream key"))
+	mac.Write(header.keySalt)
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return aead, nil
+}
+
+// openChunk decrypts a sealed chunk in place
+func (e *Encryptor) openChunk(header *streamHeader, chunk []byte, counter uint32, last bool) ([]byte, error) {
+	plain, err := header.aead.Open(chunk[:0], header.nonce(counter, last), chunk, header.raw)
+	if err != nil {
+		// With a matching key check the key is right, so the data must be damaged
+		return nil, mark(ErrCorrupted, mark(ErrAuthenticationFailed, fmt.Errorf("failed to decrypt data: %w", err)))
+	}
+	return plain, nil
+}
@@ -0,0 +1,246 @@
+package sealfile
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// sealForTest seals data as a sealed stream with the given metadata
+func sealForTest(t *testing.T, e *Encryptor, data []byte, metadata map[string]string) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	sw, err := e.NewSealWriterWithMetadata(&sealed, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+// openForTest decrypts a sealed stream completely
+func openForTest(e *Encryptor, sealed []byte) ([]byte, error) {
+	or, err := e.NewOpenReader(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(or)
+}
+
+func newTestEncryptor(t *testing.T, key string) *Encryptor {
+	t.Helper()
+	e, err := NewEncryptor(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	e := newTestEncryptor(t, "stream round trip key")
+	sizes := []int{0, 1, streamChunkSize - 1, streamChunkSize, streamChunkSize + 1, 3*streamChunkSize + 17}
+	for _, size := range sizes {
+		data := randomBytes(t, size)
+		sealed := sealForTest(t, e, data, map[string]string{"name": "test"})
+
+		opened, err := openForTest(e, sealed)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(opened, data) {
+			t.Fatalf("size %d: opened data differs", size)
+		}
+
+		sr, err := e.NewSeekReader(bytes.NewReader(sealed), int64(len(sealed)))
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if sr.Size() != int64(size) {
+			t.Fatalf("size %d: seek reader reports %d", size, sr.Size())
+		}
+		if sr.Metadata()["name"] != "test" {
+			t.Fatalf("size %d: metadata %v", size, sr.Metadata())
+		}
+		if size > 10 {
+			offset := int64(size - 10)
+			if _, err := sr.Seek(offset, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			tail, err := io.ReadAll(sr)
+			if err != nil {
+				t.Fatalf("size %d: %v", size, err)
+			}
+			if !bytes.Equal(tail, data[offset:]) {
+				t.Fatalf("size %d: seeked data differs", size)
+			}
+		}
+	}
+}
+
+func TestStreamTamper(t *testing.T) {
+	e := newTestEncryptor(t, "stream tamper key")
+	data := randomBytes(t, 2*streamChunkSize+100)
+	sealed := sealForTest(t, e, data, map[string]string{"owner": "a"})
+	headerLen := streamHeaderSize + streamKeyCheck + 4 + len(`{"owner":"a"}`)
+	sealedChunk := streamChunkSize + streamTagOverhead
+
+	tests := []struct {
+		name   string
+		tamper func([]byte) []byte
+		want   error
+	}{
+		{"flipped chunk byte", func(b []byte) []byte {
+			b[headerLen+sealedChunk+5] ^= 1
+			return b
+		}, ErrCorrupted},
+		{"altered metadata", func(b []byte) []byte {
+			return bytes.Replace(b, []byte(`"a"`), []byte(`"b"`), 1)
+		}, ErrCorrupted},
+		{"truncated last chunk", func(b []byte) []byte {
+			return b[:len(b)-1]
+		}, ErrCorrupted},
+		{"dropped last chunk", func(b []byte) []byte {
+			return b[:headerLen+2*sealedChunk]
+		}, ErrCorrupted},
+		{"swapped chunks", func(b []byte) []byte {
+			swapped := append([]byte(nil), b...)
+			copy(swapped[headerLen:], b[headerLen+sealedChunk:headerLen+2*sealedChunk])
+			copy(swapped[headerLen+sealedChunk:], b[headerLen:headerLen+sealedChunk])
+			return swapped
+		}, ErrCorrupted},
+		{"unknown version", func(b []byte) []byte {
+			b[len(streamMagic)] = streamVersion + 1
+			return b
+		}, ErrUnsupportedFormat},
+		{"huge chunk size", func(b []byte) []byte {
+			binary.BigEndian.PutUint32(b[len(streamMagic)+2:], 1<<31-1)
+			return b
+		}, ErrUnsupportedFormat},
+		{"truncated header", func(b []byte) []byte {
+			return b[:streamHeaderSize]
+		}, ErrCorrupted},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tampered := test.tamper(append([]byte(nil), sealed...))
+			if _, err := openForTest(e, tampered); !errors.Is(err, test.want) {
+				t.Fatalf("open reader: got %v, want %v", err, test.want)
+			}
+
+			sr, err := e.NewSeekReader(bytes.NewReader(tampered), int64(len(tampered)))
+			if err == nil {
+				_, err = io.ReadAll(sr)
+			}
+			if !errors.Is(err, test.want) {
+				t.Fatalf("seek reader: got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestStreamWrongKey(t *testing.T) {
+	sealed := sealForTest(t, newTestEncryptor(t, "the right key"), []byte("secret"), nil)
+
+	_, err := openForTest(newTestEncryptor(t, "the wrong key"), sealed)
+	if !errors.Is(err, ErrWrongKey) || !errors.Is(err, ErrAuthenticationFailed) {
+		t.Fatalf("got %v, want ErrWrongKey", err)
+	}
+	if errors.Is(err, ErrCorrupted) {
+		t.Fatalf("wrong key reported as corruption: %v", err)
+	}
+}
+
+func TestSealDeterministic(t *testing.T) {
+	e := newTestEncryptor(t, "deterministic key")
+	data := randomBytes(t, 2*streamChunkSize+5)
+
+	first, err := e.SealDeterministic(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := e.SealDeterministic(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatal("equal data sealed to different bytes")
+	}
+
+	opened, err := openForTest(e, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, data) {
+		t.Fatal("deterministic stream did not round trip")
+	}
+
+	sr, err := e.NewSeekReader(bytes.NewReader(first), int64(len(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err = io.ReadAll(sr); err != nil || !bytes.Equal(opened, data) {
+		t.Fatalf("deterministic stream did not round trip through the seek reader: %v", err)
+	}
+}
+
+func TestSealDeterministicDerivesKeyPerContent(t *testing.T) {
+	e := newTestEncryptor(t, "deterministic key")
+	a, err := e.SealDeterministic([]byte("content a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := e.SealDeterministic([]byte("content b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ha, err := readStreamHeader(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hb, err := readStreamHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ha.keySalt == nil || bytes.Equal(ha.keySalt, hb.keySalt) {
+		t.Fatal("different content was sealed under the same key")
+	}
+
+	// The chunks are not sealed under the key itself
+	ha.keySalt = nil
+	if err := e.checkKey(ha); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.openChunk(ha, a[len(ha.raw):], 0, true); err == nil {
+		t.Fatal("deterministic stream opened under the underived key")
+	}
+
+	if _, err := openForTest(newTestEncryptor(t, "another key"), a); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("got %v, want ErrWrongKey", err)
+	}
+}
+
+func TestStreamUnknownFlags(t *testing.T) {
+	sealed := sealForTest(t, newTestEncryptor(t, "flags key"), []byte("data"), nil)
+	sealed[len(streamMagic)+1] = 0x80
+	if _, err := readStreamHeader(bytes.NewReader(sealed)); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
@@ -0,0 +1,358 @@
+package sealfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	jpegMagic = []byte{0xff, 0xd8, 0xff}
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+
+	exifPrefix        = []byte("Exif\x00\x00")
+	xmpPrefix         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedPrefix = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	mpfPrefix         = []byte("MPF\x00")
+	pngXMPKeyword     = []byte("XML:com.adobe.xmp\x00")
+)
+
+// errMalformedImage is reported for images whose structure cannot be followed,
+// their metadata cannot be removed reliably
+var errMalformedImage = errors.New("malformed image")
+
+// StripReport describes the metadata removed from an image
+type StripReport struct {
+	Format  string   // "jpeg" or "png", empty when the content is neither and was left untouched
+	Removed []string // Removed blocks in file order: "EXIF", "XMP", "IPTC", "comment", "MPF", "trailing data" or a PNG chunk type
+	Bytes   int64    // Size of the removed blocks
+}
+
+// remove records a removed block
+func (r *StripReport) remove(kind string, size int64) {
+	r.Removed = append(r.Removed, kind)
+	r.Bytes += size
+}
+
+// StripImageMetadata copies a JPEG or PNG image from r to w without its EXIF, XMP and
+// textual metadata. Pixel data is copied as it is, never re-encoded. The EXIF orientation
+// is kept so that photos still display upright. Other content is copied unchanged.
+func StripImageMetadata(w io.Writer, r io.Reader) (*StripReport, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(len(pngMagic))
+
+	var report *StripReport
+	var err error
+	switch {
+	case bytes.HasPrefix(head, jpegMagic):
+		report, err = stripJPEG(w, br)
+	case bytes.HasPrefix(head, pngMagic):
+		report, err = stripPNG(w, br)
+	default:
+		report = &StripReport{}
+		_, err = io.Copy(w, br)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = fmt.Errorf("%w: image is truncated", errMalformedImage)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to strip image metadata: %w", err)
+	}
+	return report, nil
+}
+
+// stripJPEG drops metadata segments before and between the scans. Entropy-coded data is
+// copied as it is, everything after the end of the image, such as the secondary images of
+// an MPF file with their own EXIF data, is dropped.
+func stripJPEG(w io.Writer, br *bufio.Reader) (*StripReport, error) {
+	report := &StripReport{Format: "jpeg"}
+	soi := make([]byte, 2)
+	if _, err := io.ReadFull(br, soi); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(soi); err != nil {
+		return nil, err
+	}
+
+	var marker byte
+	scanned := false // The marker ending a scan was read already
+	for {
+		if !scanned {
+			var err error
+			if marker, err = readJPEGMarker(br); err != nil {
+				return nil, err
+			}
+		}
+		scanned = false
+
+		switch {
+		case marker == 0xd9:
+			if _, err := w.Write([]byte{0xff, marker}); err != nil {
+				return nil, err
+			}
+			n, err := io.Copy(io.Discard, br)
+			if n > 0 {
+				report.remove("trailing data", n)
+			}
+			return report, err
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd7:
+			// Markers without a segment
+			if _, err := w.Write([]byte{0xff, marker}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(br, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if length < 2 {
+			return nil, fmt.Errorf("%w: invalid JPEG segment length", errMalformedImage)
+		}
+		payload := make([]byte, length-2)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return nil, err
+		}
+
+		if marker == 0xda {
+			// Start of scan, the entropy-coded data runs up to the next marker
+			if err := writeJPEGSegment(w, marker, payload); err != nil {
+				return nil, err
+			}
+			var err error
+			if marker, err = copyJPEGScan(w, br); err != nil {
+				return nil, err
+			}
+			scanned = true
+			continue
+		}
+
+		kind := jpegMetadataKind(marker, payload)
+		if kind == "" {
+			if err := writeJPEGSegment(w, marker, payload); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if kind == "EXIF" {
+			if orientation := exifOrientation(payload[len(exifPrefix):]); orientation > 1 {
+				// Images stripped before already hold nothing but the orientation
+				kept := orientationEXIF(orientation)
+				if err := writeJPEGSegment(w, 0xe1, kept); err != nil {
+					return nil, err
+				}
+				if bytes.Equal(payload, kept) {
+					continue
+				}
+			}
+		}
+		report.remove(kind, int64(length)+2)
+	}
+}
+
+// copyJPEGScan copies entropy-coded data with its stuffed bytes and restart markers and
+// returns the marker ending it
+func copyJPEGScan(w io.Writer, br *bufio.Reader) (byte, error) {
+	for {
+		data, err := br.ReadSlice(0xff)
+		if err == bufio.ErrBufferFull {
+			if _, err := w.Write(data); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		// The slice is only valid up to the next read
+		if _, err := w.Write(data[:len(data)-1]); err != nil {
+			return 0, err
+		}
+
+		marker, err := br.ReadByte()
+		for err == nil && marker == 0xff {
+			marker, err = br.ReadByte()
+		}
+		if err != nil {
+			return 0, err
+		}
+		if marker != 0x00 && (marker < 0xd0 || marker > 0xd7) {
+			return marker, nil
+		}
+		if _, err := w.Write([]byte{0xff, marker}); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// readJPEGMarker reads the next marker, skipping fill bytes
+func readJPEGMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, fmt.Errorf("%w: JPEG marker expected", errMalformedImage)
+	}
+	for b == 0xff {
+		if b, err = br.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// writeJPEGSegment writes a marker segment with its length
+func writeJPEGSegment(w io.Writer, marker byte, payload []byte) error {
+	header := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// jpegMetadataKind names the metadata held by a segment, empty for segments that are kept.
+// ICC profiles (APP2) and the JFIF and Adobe segments affect how pixels display and stay.
+func jpegMetadataKind(marker byte, payload []byte) string {
+	switch {
+	case marker == 0xe1 && bytes.HasPrefix(payload, exifPrefix):
+		return "EXIF"
+	case marker == 0xe1 && (bytes.HasPrefix(payload, xmpPrefix) || bytes.HasPrefix(payload, xmpExtendedPrefix)):
+		return "XMP"
+	case marker == 0xed:
+		return "IPTC"
+	case marker == 0xfe:
+		return "comment"
+	case marker == 0xe2 && bytes.HasPrefix(payload, mpfPrefix):
+		// Indexes the secondary images dropped after the end of the image
+		return "MPF"
+	}
+	return ""
+}
+
+// exifOrientation returns the orientation tag of the first IFD of EXIF data, 0 when it has none
+func exifOrientation(tiff []byte) uint16 {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// Tag 0x0112 of type SHORT holds the orientation
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if value := order.Uint16(tiff[entry+8:]); value <= 8 {
+				return value
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationEXIF builds an EXIF segment holding only the orientation tag
+func orientationEXIF(orientation uint16) []byte {
+	payload := append([]byte(nil), exifPrefix...)
+	payload = append(payload, 'M', 'M', 0, 42, 0, 0, 0, 8)  // Big endian TIFF header, first IFD at 8
+	payload = append(payload, 0, 1)                         // One entry
+	payload = append(payload, 0x01, 0x12, 0, 3, 0, 0, 0, 1) // Orientation, SHORT, count 1
+	payload = append(payload, byte(orientation>>8), byte(orientation), 0, 0)
+	return append(payload, 0, 0, 0, 0) // No further IFD
+}
+
+// stripPNG drops the textual and EXIF chunks of a PNG image
+func stripPNG(w io.Writer, br *bufio.Reader) (*StripReport, error) {
+	report := &StripReport{Format: "png"}
+	signature := make([]byte, len(pngMagic))
+	if _, err := io.ReadFull(br, signature); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(signature); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+		if length > 1<<31-1 {
+			return nil, fmt.Errorf("%w: invalid PNG chunk length", errMalformedImage)
+		}
+		chunkType := string(header[4:])
+
+		kind := ""
+		switch chunkType {
+		case "tEXt", "zTXt":
+			kind = chunkType
+		case "iTXt":
+			kind = chunkType
+			if keyword, _ := br.Peek(min(int(length), len(pngXMPKeyword))); bytes.Equal(keyword, pngXMPKeyword) {
+				kind = "XMP"
+			}
+		case "eXIf":
+			kind = "EXIF"
+		}
+
+		// Chunk data is followed by its CRC
+		var err error
+		if kind == "" {
+			if _, err = w.Write(header); err == nil {
+				_, err = io.CopyN(w, br, length+4)
+			}
+		} else {
+			report.remove(kind, length+12)
+			_, err = io.CopyN(io.Discard, br, length+4)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if chunkType == "IEND" {
+			_, err := io.Copy(w, br)
+			return report, err
+		}
+	}
+}
+
+// stripsImage reports whether the metadata of filename is removed before it is sealed
+func (s *configSnapshot) stripsImage(filename string) bool {
+	return s.config.StripImageMetadata && IsImageFile(filename)
+}
+
+// stripWhileReading returns a reader yielding r without image metadata. The report is
+// delivered on the channel once the stripped content was read or the reader closed.
+func stripWhileReading(r io.Reader) (*io.PipeReader, <-chan *StripReport) {
+	pr, pw := io.Pipe()
+	reports := make(chan *StripReport, 1)
+	go func() {
+		report, err := StripImageMetadata(pw, r)
+		_ = pw.CloseWithError(err)
+		reports <- report
+	}()
+	return pr, reports
+}
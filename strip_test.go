@@ -0,0 +1,110 @@
+package sealfile
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"slices"
+	"testing"
+)
+
+// jpegForTest encodes a noisy image as JPEG, so that its scan holds stuffed bytes
+func jpegForTest(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	noise := randomBytes(t, 64*64)
+	for i, value := range noise {
+		img.Set(i%64, i/64, color.RGBA{value, value ^ 0x5a, 255 - value, 255})
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return encoded.Bytes()
+}
+
+// withJPEGSegment inserts a marker segment into a JPEG image at offset
+func withJPEGSegment(image []byte, offset int, marker byte, payload []byte) []byte {
+	var segment bytes.Buffer
+	_ = writeJPEGSegment(&segment, marker, payload)
+	return slices.Concat(image[:offset], segment.Bytes(), image[offset:])
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	photo := jpegForTest(t)
+	afterSOI, beforeEOI := 2, len(photo)-2
+	gps := slices.Concat(exifPrefix, []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x00GPS 52.52N 13.40E"))
+	rotated := append(orientationEXIF(6), "serial 1234"...)
+	xmp := slices.Concat(xmpPrefix, []byte("<x:xmpmeta>author</x:xmpmeta>"))
+	mpf := slices.Concat(mpfPrefix, []byte("MM\x00\x2a\x00\x00\x00\x08"))
+	secondary := withJPEGSegment(photo, afterSOI, 0xe1, gps)
+	graphic := pngForTest(t, 8, 8)
+
+	tests := []struct {
+		name    string
+		input   []byte
+		want    []byte
+		removed []string
+	}{
+		{"JPEG EXIF", withJPEGSegment(photo, afterSOI, 0xe1, gps), photo, []string{"EXIF"}},
+		{"JPEG XMP", withJPEGSegment(photo, afterSOI, 0xe1, xmp), photo, []string{"XMP"}},
+		{"JPEG IPTC", withJPEGSegment(photo, afterSOI, 0xed, []byte("Photoshop 3.0\x00caption")), photo, []string{"IPTC"}},
+		{"JPEG comment", withJPEGSegment(photo, afterSOI, 0xfe, []byte("taken at home")), photo, []string{"comment"}},
+		{"JPEG ICC profile kept", withJPEGSegment(photo, afterSOI, 0xe2, []byte("ICC_PROFILE\x00profile")), withJPEGSegment(photo, afterSOI, 0xe2, []byte("ICC_PROFILE\x00profile")), nil},
+		{"JPEG orientation kept", withJPEGSegment(photo, afterSOI, 0xe1, rotated), withJPEGSegment(photo, afterSOI, 0xe1, orientationEXIF(6)), []string{"EXIF"}},
+		{"JPEG stripped before", withJPEGSegment(photo, afterSOI, 0xe1, orientationEXIF(6)), withJPEGSegment(photo, afterSOI, 0xe1, orientationEXIF(6)), nil},
+		{"JPEG segments after a scan", withJPEGSegment(withJPEGSegment(photo, beforeEOI, 0xfe, []byte("note")), beforeEOI, 0xe1, gps), photo, []string{"EXIF", "comment"}},
+		{"JPEG MPF secondary image", append(withJPEGSegment(photo, afterSOI, 0xe2, mpf), secondary...), photo, []string{"MPF", "trailing data"}},
+		{"PNG tEXt", withPNGChunk(graphic, "tEXt", []byte("Location\x00home")), graphic, []string{"tEXt"}},
+		{"PNG zTXt", withPNGChunk(graphic, "zTXt", []byte("Comment\x00\x00x\x9c")), graphic, []string{"zTXt"}},
+		{"PNG iTXt", withPNGChunk(graphic, "iTXt", []byte("Author\x00\x00\x00\x00\x00someone")), graphic, []string{"iTXt"}},
+		{"PNG XMP", withPNGChunk(graphic, "iTXt", slices.Concat(pngXMPKeyword, []byte("\x00\x00\x00\x00<x:xmpmeta/>"))), graphic, []string{"XMP"}},
+		{"PNG eXIf", withPNGChunk(graphic, "eXIf", gps[len(exifPrefix):]), graphic, []string{"EXIF"}},
+		{"other content", []byte("plain text"), []byte("plain text"), nil},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		report, err := StripImageMetadata(&out, bytes.NewReader(test.input))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !bytes.Equal(out.Bytes(), test.want) {
+			t.Fatalf("%s: stripped to %d bytes, want %d", test.name, out.Len(), len(test.want))
+		}
+		// A rewritten orientation counts as part of the removed EXIF segment
+		if !slices.Equal(report.Removed, test.removed) || report.Bytes < int64(len(test.input)-len(test.want)) {
+			t.Fatalf("%s: removed %v of %d bytes, want %v", test.name, report.Removed, report.Bytes, test.removed)
+		}
+		if report.Format == "jpeg" {
+			if _, err := jpeg.Decode(&out); err != nil {
+				t.Fatalf("%s: stripped image does not decode: %v", test.name, err)
+			}
+		}
+	}
+}
+
+func TestStripMalformedImages(t *testing.T) {
+	photo := jpegForTest(t)
+	graphic := pngForTest(t, 8, 8)
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"JPEG segment length below 2", slices.Concat(photo[:2], []byte{0xff, 0xe1, 0x00, 0x01}, photo[2:])},
+		{"JPEG truncated segment", photo[:20]},
+		{"JPEG truncated scan", photo[:len(photo)-100]},
+		{"JPEG without end of image", photo[:len(photo)-2]},
+		{"JPEG garbage instead of a marker", slices.Concat(photo[:2], []byte{0xff, 0xfe, 0x00, 0x04, 'o', 'k'}, []byte("garbage"))},
+		{"PNG without IEND", graphic[:len(graphic)-12]},
+		{"PNG truncated chunk", graphic[:len(graphic)-20]},
+		{"PNG signature only", graphic[:len(pngMagic)]},
+	}
+	for _, test := range tests {
+		if _, err := StripImageMetadata(&bytes.Buffer{}, bytes.NewReader(test.input)); !errors.Is(err, errMalformedImage) {
+			t.Fatalf("%s: got %v, want errMalformedImage", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package sealfile
+
+import (
+	"container/list"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// TenantResolver returns the configuration of a tenant, typically with its own encryption key.
+// The returned configuration is confined to the root directory of the tenant.
+type TenantResolver func(tenantID string) (*Config, error)
+
+// TenantOptions configures a TenantManager
+type TenantOptions struct {
+	BaseDir    string // Directory holding one root directory per tenant
+	MaxTenants int    // Number of FileManagers kept open, the least recently used one is closed first
+}
+
+// TenantManager hands out one FileManager per tenant. Every tenant is isolated in
+// BaseDir/<tenant id> and its files are sealed with its own key and tenant id.
+// Tenant ids are case-insensitive, they are lower-cased before use.
+type TenantManager struct {
+	resolver   TenantResolver
+	baseDir    string
+	maxTenants int
+
+	mu      sync.Mutex
+	lru     *list.List // Most recently used tenant first
+	tenants map[string]*list.Element
+}
+
+// tenantEntry is a cached FileManager of a tenant
+type tenantEntry struct {
+	id string
+	fm *FileManager
+}
+
+// NewTenantManager creates a TenantManager resolving tenant configurations with resolver
+func NewTenantManager(resolver TenantResolver, options TenantOptions) (*TenantManager, error) {
+	if resolver == nil {
+		return nil, fmt.Errorf("tenant resolver must not be nil")
+	}
+	if options.BaseDir == "" {
+		return nil, fmt.Errorf("tenant base directory is required")
+	}
+	if options.MaxTenants <= 0 {
+		options.MaxTenants = 128
+	}
+
+	baseDir, err := filepath.Abs(options.BaseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tenant base directory: %w", err)
+	}
+
+	return &TenantManager{
+		resolver:   resolver,
+		baseDir:    baseDir,
+		maxTenants: options.MaxTenants,
+		lru:        list.New(),
+		tenants:    make(map[string]*list.Element),
+	}, nil
+}
+
+// Get returns the FileManager of a tenant, creating it on first use
+func (tm *TenantManager) Get(tenantID string) (*FileManager, error) {
+	tenantID = strings.ToLower(tenantID)
+	if err := validateTenantID(tenantID); err != nil {
+		return nil, err
+	}
+
+	tm.mu.Lock()
+	if element, ok := tm.tenants[tenantID]; ok {
+		tm.lru.MoveToFront(element)
+		tm.mu.Unlock()
+		return element.Value.(*tenantEntry).fm, nil
+	}
+	tm.mu.Unlock()
+
+	// Resolve outside of the lock, resolvers may be slow
+	fm, err := tm.open(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	// Another caller may have opened the tenant meanwhile
+	if element, ok := tm.tenants[tenantID]; ok {
+		tm.lru.MoveToFront(element)
+		return element.Value.(*tenantEntry).fm, nil
+	}
+
+	tm.tenants[tenantID] = tm.lru.PushFront(&tenantEntry{id: tenantID, fm: fm})
+	for tm.lru.Len() > tm.maxTenants {
+		oldest := tm.lru.Back()
+		tm.lru.Remove(oldest)
+		delete(tm.tenants, oldest.Value.(*tenantEntry).id)
+	}
+	return fm, nil
+}
+
+// Evict drops the cached FileManager of a tenant, the next Get resolves it again
+func (tm *TenantManager) Evict(tenantID string) {
+	tenantID = strings.ToLower(tenantID)
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if element, ok := tm.tenants[tenantID]; ok {
+		tm.lru.Remove(element)
+		delete(tm.tenants, tenantID)
+	}
+}
+
+// RootDir returns the root directory of a tenant
+func (tm *TenantManager) RootDir(tenantID string) string {
+	return filepath.Join(tm.baseDir, strings.ToLower(tenantID))
+}
+
+// open resolves the configuration of a tenant and confines it to the tenant root
+func (tm *TenantManager) open(tenantID string) (*FileManager, error) {
+	config, err := tm.resolver(tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve tenant %s: %w", tenantID, err)
+	}
+	if config == nil {
+		return nil, fmt.Errorf("failed to resolve tenant %s: no configuration", tenantID)
+	}
+	config = config.clone()
+
+	// A root directory set by the resolver must lie inside the tenant root
+	root := tm.RootDir(tenantID)
+	if config.RootDir != "" {
+		rootDir := config.RootDir
+		if !filepath.IsAbs(rootDir) {
+			rootDir = filepath.Join(root, rootDir)
+		}
+		if !isWithin(root, filepath.Clean(rootDir)) {
+			return nil, fmt.Errorf("%w: root directory of tenant %s", ErrPathEscape, tenantID)
+		}
+		root = filepath.Clean(rootDir)
+	}
+	config.RootDir = root
+	config.TenantID = tenantID
+
+	if err := EnsureDirectory(root); err != nil {
+		return nil, err
+	}
+
+	// Scratch directories hold plaintext and rejected files, they are fixed inside the root
+	for _, dir := range []struct {
+		name string
+		path *string
+	}{
+		{"temp", &config.TempDir},
+		{"quarantine", &config.QuarantineDir},
+	} {
+		if *dir.path == "" {
+			continue
+		}
+		resolved, err := resolveWithin(root, *dir.path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s directory of tenant %s: %w", dir.name, tenantID, err)
+		}
+		*dir.path = resolved
+	}
+
+	fm, err := NewFileManager(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open tenant %s: %w", tenantID, err)
+	}
+	return fm, nil
+}
+
+// validateTenantID accepts identifiers made of lower-case letters, digits, '-' and '_'
+func validateTenantID(tenantID string) error {
+	if tenantID == "" || len(tenantID) > 64 {
+		return fmt.Errorf("%w: %q", ErrInvalidTenant, tenantID)
+	}
+	for _, r := range tenantID {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return fmt.Errorf("%w: %q", ErrInvalidTenant, tenantID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package sealfile
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func newTestTenantManager(t *testing.T, configure func(tenantID string, config *Config)) *TenantManager {
+	t.Helper()
+	tm, err := NewTenantManager(func(tenantID string) (*Config, error) {
+		config := DefaultConfig()
+		config.EncryptionKey = "key of tenant " + tenantID
+		config.PublicDir = "public"
+		if configure != nil {
+			configure(tenantID, config)
+		}
+		return config, nil
+	}, TenantOptions{BaseDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func TestTenantIDsAreLowerCased(t *testing.T) {
+	tm := newTestTenantManager(t, nil)
+	upper, err := tm.Get("Acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := tm.Get("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Fatal("tenant ids differing in case got separate managers")
+	}
+	if id := lower.GetConfig().TenantID; id != "acme" {
+		t.Fatalf("tenant id %q", id)
+	}
+	if _, err := tm.Get("../acme"); !errors.Is(err, ErrInvalidTenant) {
+		t.Fatalf("got %v, want ErrInvalidTenant", err)
+	}
+}
+
+func TestTenantScratchDirectoriesStayInRoot(t *testing.T) {
+	tm := newTestTenantManager(t, func(tenantID string, config *Config) {
+		config.TempDir = "temp"
+		config.QuarantineDir = "quarantine"
+	})
+	fm, err := tm.Get("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := fm.GetConfig()
+	if config.TempDir != filepath.Join(tm.RootDir("acme"), "temp") || config.QuarantineDir != filepath.Join(tm.RootDir("acme"), "quarantine") {
+		t.Fatalf("scratch directories %q and %q", config.TempDir, config.QuarantineDir)
+	}
+
+	for _, dir := range []string{"/tmp", "../other"} {
+		tm := newTestTenantManager(t, func(tenantID string, config *Config) { config.TempDir = dir })
+		if _, err := tm.Get("acme"); !errors.Is(err, ErrPathEscape) {
+			t.Fatalf("temp directory %q: got %v, want ErrPathEscape", dir, err)
+		}
+		tm = newTestTenantManager(t, func(tenantID string, config *Config) { config.QuarantineDir = dir })
+		if _, err := tm.Get("acme"); !errors.Is(err, ErrPathEscape) {
+			t.Fatalf("quarantine directory %q: got %v, want ErrPathEscape", dir, err)
+		}
+	}
+}
+
+func TestTenantRefusesFilesOfOtherOrNoTenant(t *testing.T) {
+	// All tenants share a key, so only the tenant metadata tells their files apart
+	tm := newTestTenantManager(t, func(tenantID string, config *Config) { config.EncryptionKey = "shared key" })
+	acme, err := tm.Get("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tm.Get("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.SaveDataAsSecureFile([]byte("acme data"), "public", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.LoadSecureFileFromDisk("public", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file of another tenant planted into the root of acme
+	if _, err := other.SaveDataAsSecureFile([]byte("other data"), filepath.Join(tm.RootDir("acme"), "public"), "b.txt"); !errors.Is(err, ErrPathEscape) {
+		t.Fatalf("other tenant wrote into acme: %v", err)
+	}
+	config := other.GetConfig()
+	config.RootDir = tm.RootDir("acme")
+	planter, err := NewFileManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := planter.SaveDataAsSecureFile([]byte("other data"), "public", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.LoadSecureFileFromDisk("public", "b.txt"); !errors.Is(err, ErrTenantMismatch) {
+		t.Fatalf("file of another tenant: got %v, want ErrTenantMismatch", err)
+	}
+
+	// A file sealed without any tenant
+	config.TenantID = ""
+	if planter, err = NewFileManager(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := planter.SaveDataAsSecureFile([]byte("no tenant"), "public", "c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.LoadSecureFileFromDisk("public", "c.txt"); !errors.Is(err, ErrTenantMismatch) {
+		t.Fatalf("file without tenant: got %v, want ErrTenantMismatch", err)
+	}
+}
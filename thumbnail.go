@@ -0,0 +1,217 @@
+package sealfile
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Registers GIF decoding for thumbnails
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxThumbnailSize   = 4096     // Largest thumbnail size accepted by Validate
+	maxThumbnailPixels = 64 << 20 // Larger images get no thumbnails, decoding them would take too much memory
+	thumbnailQuality   = 85       // JPEG quality of thumbnails
+)
+
+// Thumbnail loads the thumbnail of the given size sealed next to the file, see Config.ThumbnailSizes.
+// Its Data holds the encoded thumbnail and its metadata the content type. It reports
+// ErrNotFound when there is none, such as for images that did not decode.
+func (sf *SecureFile) Thumbnail(size int) (_ *SecureFile, err error) {
+	defer sf.wrapError("thumbnail", &err)
+	if _, _, err := sf.access(AccessLoad, sf.fm.current()); err != nil {
+		return nil, err
+	}
+
+	thumbnail := sf.thumbnailFile(size)
+	if err := thumbnail.LoadDecrypted(); err != nil {
+		return nil, err
+	}
+	return thumbnail, nil
+}
+
+// GenerateThumbnails seals a thumbnail of every size in Config.ThumbnailSizes next to the file.
+// Saving an image does so already; use it for files saved before sizes were configured.
+// JPEG images get JPEG thumbnails and other images PNG ones; files that are not images
+// of a format the image package decodes, JPEG, PNG and GIF by default, are left alone.
+func (sf *SecureFile) GenerateThumbnails() (err error) {
+	defer sf.wrapError("thumbnail", &err)
+	snapshot := sf.fm.current()
+	if !snapshot.wantsThumbnails(sf.Filename) {
+		return nil
+	}
+	_, fullPath, err := sf.access(AccessLoad, snapshot)
+	if err != nil {
+		return err
+	}
+	if err := sf.fm.authorize(AccessSave, fullPath); err != nil {
+		return err
+	}
+	return sf.writeThumbnails(snapshot, nil)
+}
+
+// wantsThumbnails reports whether saving filename generates thumbnails
+func (s *configSnapshot) wantsThumbnails(filename string) bool {
+	return len(s.config.ThumbnailSizes) > 0 && IsImageFile(filename)
+}
+
+// writeThumbnails seals the thumbnails of the file, decoding data or, when nil, the sealed file.
+// The caller authorized saving the file, its thumbnails are derived from it.
+func (sf *SecureFile) writeThumbnails(snapshot *configSnapshot, data []byte) error {
+	return sf.eachThumbnail(snapshot, data, func(thumbnail *SecureFile) error {
+		if err := thumbnail.SaveEncrypted(); err != nil {
+			return fmt.Errorf("failed to save thumbnail: %w", err)
+		}
+		return nil
+	})
+}
+
+// eachThumbnail renders the thumbnails of the file like writeThumbnails and passes each
+// one, holding its encoded image and content type, to fn instead of saving it
+func (sf *SecureFile) eachThumbnail(snapshot *configSnapshot, data []byte, fn func(*SecureFile) error) error {
+	var r io.Reader = bytes.NewReader(data)
+	if data == nil {
+		source, err := sf.fm.withSnapshot(snapshot).internal().NewSecureFile(nil, sf.Path, sf.Filename).OpenDecrypted()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = source.Close() }()
+		r = source
+	}
+
+	// Check the dimensions before decoding the pixels
+	var head bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil || config.Width*config.Height > maxThumbnailPixels {
+		return nil
+	}
+	img, _, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		return nil
+	}
+
+	// Scaling down from the next larger thumbnail is much faster than from the original
+	sizes := append([]int(nil), snapshot.config.ThumbnailSizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	contentType := thumbnailContentType(sf.Filename)
+	for _, size := range sizes {
+		img = scaleDown(img, size)
+
+		var encoded bytes.Buffer
+		if contentType == "image/jpeg" {
+			err = jpeg.Encode(&encoded, img, &jpeg.Options{Quality: thumbnailQuality})
+		} else {
+			err = png.Encode(&encoded, img)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+
+		thumbnail := sf.fm.withSnapshot(snapshot).internal().NewSecureFile(encoded.Bytes(), sf.Path, thumbnailName(sf.Filename, size))
+		thumbnail.Metadata = map[string]string{MetadataContentType: contentType}
+		if err := fn(thumbnail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeThumbnails deletes the thumbnails of the file, ignoring those that do not exist
+func (sf *SecureFile) removeThumbnails(snapshot *configSnapshot) {
+	for _, size := range snapshot.config.ThumbnailSizes {
+		_ = sf.thumbnailFile(size).Delete()
+	}
+}
+
+// thumbnailPaths returns the locations of the thumbnails of the file at fullPath
+func (s *configSnapshot) thumbnailPaths(fullPath string) []string {
+	paths := make([]string, len(s.config.ThumbnailSizes))
+	for i, size := range s.config.ThumbnailSizes {
+		paths[i] = filepath.Join(filepath.Dir(fullPath), thumbnailName(filepath.Base(fullPath), size))
+	}
+	return paths
+}
+
+// moveThumbnails moves the thumbnails of a moved file along, removing those that cannot be moved
+func (s *configSnapshot) moveThumbnails(sourceFullPath, destFullPath string) {
+	for _, size := range s.config.ThumbnailSizes {
+		source := filepath.Join(filepath.Dir(sourceFullPath), thumbnailName(filepath.Base(sourceFullPath), size))
+		dest := filepath.Join(filepath.Dir(destFullPath), thumbnailName(filepath.Base(destFullPath), size))
+		if err := os.Rename(source, dest); err != nil {
+			_ = os.Remove(source)
+		}
+	}
+}
+
+// thumbnailFile returns the thumbnail of the given size, authorized through the file itself
+func (sf *SecureFile) thumbnailFile(size int) *SecureFile {
+	return sf.fm.internal().NewSecureFile(nil, sf.Path, thumbnailName(sf.Filename, size))
+}
+
+// thumbnailName names the thumbnail of a file. It is hidden, so that it is neither
+// listed nor served on its own.
+func thumbnailName(filename string, size int) string {
+	return "." + filename + ".thumb-" + strconv.Itoa(size)
+}
+
+// isThumbnailName reports whether name belongs to a thumbnail
+func isThumbnailName(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".thumb-")
+}
+
+// thumbnailContentType returns the media type of the thumbnails of an image
+func thumbnailContentType(filename string) string {
+	if fileType, ok := DefaultTypeRegistry.Lookup(filename); ok && fileType.MIMEType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// scaleDown fits img into a square of size pixels by averaging the source pixels
+// covered by each target pixel. Images that already fit are returned as they are.
+func scaleDown(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	targetWidth, targetHeight := size, size
+	if width > height {
+		targetHeight = max(1, height*size/width)
+	} else {
+		targetWidth = max(1, width*size/height)
+	}
+
+	scaled := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0, y1 := y*height/targetHeight, max((y+1)*height/targetHeight, y*height/targetHeight+1)
+		for x := 0; x < targetWidth; x++ {
+			x0, x1 := x*width/targetWidth, max((x+1)*width/targetWidth, x*width/targetWidth+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			scaled.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return scaled
+}
@@ -0,0 +1,175 @@
+package sealfile
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// transaction stages file writes and deletions so a batch can be applied or undone as a unit
+type transaction struct {
+	mu      sync.Mutex
+	entries map[int][]*txEntry // An item may stage several changes, such as a file and its thumbnails
+}
+
+// txEntry is a single staged change of a batch item
+type txEntry struct {
+	index   int // Index of the batch item
+	target  string
+	staged  string // temporary file holding the new content, empty for deletions
+	backup  string // previous content moved aside while committing
+	applied bool
+}
+
+// newTransaction creates an empty transaction
+func newTransaction() *transaction {
+	return &transaction{entries: make(map[int][]*txEntry)}
+}
+
+// stageWrite writes new content for target into a temporary file next to it
+func (tx *transaction) stageWrite(index int, target string, createDir bool, write func(io.Writer) error) error {
+	dir := filepath.Dir(target)
+	if createDir {
+		if err := EnsureDirectory(dir); err != nil {
+			return err
+		}
+	}
+
+	staged, err := os.CreateTemp(dir, "."+filepath.Base(target)+".stage-*")
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+
+	if err := write(staged); err != nil {
+		_ = staged.Close()
+		_ = os.Remove(staged.Name())
+		return err
+	}
+
+	if err := staged.Chmod(0644); err != nil {
+		_ = staged.Close()
+		_ = os.Remove(staged.Name())
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if err := staged.Close(); err != nil {
+		_ = os.Remove(staged.Name())
+		return fmt.Errorf("failed to close staging file: %w", err)
+	}
+
+	tx.add(&txEntry{index: index, target: target, staged: staged.Name()})
+	return nil
+}
+
+// stageDelete records that target should be removed on commit
+func (tx *transaction) stageDelete(index int, target string) error {
+	if _, err := os.Stat(target); err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+	tx.add(&txEntry{index: index, target: target})
+	return nil
+}
+
+// add registers a staged entry
+func (tx *transaction) add(entry *txEntry) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.entries[entry.index] = append(tx.entries[entry.index], entry)
+}
+
+// discard removes the entries staged for an item, so that a retried item starts over
+func (tx *transaction) discard(index int) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.undo(tx.entries[index])
+	delete(tx.entries, index)
+}
+
+// commit applies every staged entry, undoing all of them if one fails.
+// It returns the index of the failing item alongside the error.
+func (tx *transaction) commit() (int, error) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	entries := tx.ordered()
+	for _, entry := range entries {
+		if _, err := os.Lstat(entry.target); err == nil {
+			entry.backup = fmt.Sprintf("%s.bak-%d", entry.target, time.Now().UnixNano())
+			if err := os.Rename(entry.target, entry.backup); err != nil {
+				entry.backup = ""
+				tx.undo(entries)
+				return entry.index, fmt.Errorf("failed to move aside existing file: %w", err)
+			}
+		}
+
+		if entry.staged != "" {
+			if err := os.Rename(entry.staged, entry.target); err != nil {
+				tx.restore(entry)
+				tx.undo(entries)
+				return entry.index, fmt.Errorf("failed to commit staged file: %w", err)
+			}
+		}
+		entry.applied = true
+	}
+
+	// Everything is in place, previous contents are no longer needed
+	for _, entry := range entries {
+		if entry.backup != "" {
+			_ = os.Remove(entry.backup)
+		}
+	}
+	return -1, nil
+}
+
+// rollback discards all staged content without touching the targets
+func (tx *transaction) rollback() {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.undo(tx.ordered())
+}
+
+// undo reverts applied entries in reverse order and removes leftover staging files
+func (tx *transaction) undo(entries []*txEntry) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.applied {
+			if entry.staged != "" {
+				_ = os.Remove(entry.target)
+			}
+			tx.restore(entry)
+			entry.applied = false
+		}
+		if entry.staged != "" {
+			_ = os.Remove(entry.staged)
+		}
+	}
+}
+
+// restore moves the backup of an entry back into place
+func (tx *transaction) restore(entry *txEntry) {
+	if entry.backup == "" {
+		return
+	}
+	if err := os.Rename(entry.backup, entry.target); err == nil {
+		entry.backup = ""
+	}
+}
+
+// ordered returns the staged entries in batch order, those of an item in the order they were staged
+func (tx *transaction) ordered() []*txEntry {
+	indexes := make([]int, 0, len(tx.entries))
+	for index := range tx.entries {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var entries []*txEntry
+	for _, index := range indexes {
+		entries = append(entries, tx.entries[index]...)
+	}
+	return entries
+}
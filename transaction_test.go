@@ -0,0 +1,173 @@
+package sealfile
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// stageForTest stages content for target
+func stageForTest(t *testing.T, tx *transaction, index int, target, content string) {
+	t.Helper()
+	if err := tx.stageWrite(index, target, false, func(w io.Writer) error {
+		_, err := io.WriteString(w, content)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readForTest returns the content of path, or "<missing>"
+func readForTest(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "<missing>"
+	}
+	return string(data)
+}
+
+func TestTransactionCommit(t *testing.T) {
+	dir := t.TempDir()
+	existing, created, deleted := filepath.Join(dir, "existing"), filepath.Join(dir, "created"), filepath.Join(dir, "deleted")
+	for _, path := range []string{existing, deleted} {
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := newTransaction()
+	stageForTest(t, tx, 0, existing, "new")
+	stageForTest(t, tx, 1, created, "created")
+	if err := tx.stageDelete(2, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if readForTest(existing) != "old" || readForTest(created) != "<missing>" || readForTest(deleted) != "old" {
+		t.Fatal("staging changed the targets")
+	}
+
+	if index, err := tx.commit(); err != nil {
+		t.Fatalf("item %d: %v", index, err)
+	}
+	if readForTest(existing) != "new" || readForTest(created) != "created" || readForTest(deleted) != "<missing>" {
+		t.Fatalf("committed %q, %q, %q", readForTest(existing), readForTest(created), readForTest(deleted))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("commit left %d files, want 2", len(entries))
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := newTransaction()
+	stageForTest(t, tx, 0, existing, "new")
+	stageForTest(t, tx, 1, filepath.Join(dir, "created"), "created")
+	tx.rollback()
+
+	if readForTest(existing) != "old" {
+		t.Fatalf("rollback changed the file to %q", readForTest(existing))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("rollback left %d files, want 1", len(entries))
+	}
+}
+
+func TestTransactionCommitFailureRestores(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	for _, path := range []string{first, second} {
+		if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := newTransaction()
+	stageForTest(t, tx, 0, first, "new")
+	stageForTest(t, tx, 1, second, "new")
+	// The staged content of the second item vanishes, so moving it into place fails
+	if err := os.Remove(tx.entries[1][0].staged); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := tx.commit()
+	if err == nil || index != 1 {
+		t.Fatalf("commit reported item %d: %v", index, err)
+	}
+	if readForTest(first) != "old" || readForTest(second) != "old" {
+		t.Fatalf("failed commit left %q, %q", readForTest(first), readForTest(second))
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Fatalf("failed commit left %d files, want 2", len(entries))
+	}
+}
+
+func TestAllOrNothingKeepsFilesOnFailure(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	if _, err := fm.SaveDataAsSecureFile([]byte("old"), "files", "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	bp := NewBatchProcessorWithOptions(fm, BatchOptions{FailurePolicy: AllOrNothing})
+	errs := bp.SaveAllFiles([]*SecureFile{
+		fm.NewSecureFile([]byte("new"), "files", "a.txt"),
+		fm.NewSecureFile([]byte("new"), "files", "b.txt"),
+		fm.NewSecureFile([]byte("outside"), "../outside", "escape.txt"),
+	})
+	if !errors.Is(errs[2], ErrPathEscape) {
+		t.Fatalf("escaping item: %v", errs[2])
+	}
+	for _, err := range errs[:2] {
+		if !errors.Is(err, ErrBatchRolledBack) && !errors.Is(err, ErrBatchAborted) {
+			t.Fatalf("item of a failed batch: %v", err)
+		}
+	}
+
+	loaded, err := fm.LoadSecureFileFromDisk("files", "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.Data) != "old" {
+		t.Fatalf("failed batch changed the file to %q", loaded.Data)
+	}
+	if entries, _ := os.ReadDir(rootPath(fm, "files")); len(entries) != 1 {
+		t.Fatalf("failed batch left %d files, want 1", len(entries))
+	}
+}
+
+func TestTransactionDiscardsStagingOfRetriedItem(t *testing.T) {
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first"), filepath.Join(dir, "second")
+	bp := NewBatchProcessorWithOptions(newTestFileManager(t, nil), BatchOptions{
+		FailurePolicy: AllOrNothing,
+		Retry:         &RetryPolicy{MaxAttempts: 2, Retryable: func(error) bool { return true }},
+	})
+
+	tx := newTransaction()
+	attempted := false
+	errs, attempts := bp.run(1, tx, func(index int) error {
+		// The first attempt stages a file and fails, the retry stages another one
+		if !attempted {
+			attempted = true
+			stageForTest(t, tx, index, first, "first")
+			return errors.New("transient failure")
+		}
+		stageForTest(t, tx, index, second, "second")
+		return nil
+	})
+	if errs[0] != nil || attempts[0] != 2 {
+		t.Fatalf("item failed after %d attempts: %v", attempts[0], errs[0])
+	}
+
+	if index, err := tx.commit(); err != nil {
+		t.Fatalf("item %d: %v", index, err)
+	}
+	if readForTest(first) != "<missing>" || readForTest(second) != "second" {
+		t.Fatalf("committed %q, %q", readForTest(first), readForTest(second))
+	}
+}
@@ -0,0 +1,640 @@
+package sealfile
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TusVersion is the version of the tus resumable upload protocol served by TusHandler
+const TusVersion = "1.0.0"
+
+const (
+	tusDefaultExpiry   = 24 * time.Hour
+	tusDefaultMaxParts = 256
+	tusPurgeInterval   = time.Minute
+	tusInfoFile        = "info.json"
+	tusDir             = "tus"
+)
+
+// TusOptions configures a resumable upload handler
+type TusOptions struct {
+	UploadOptions                    // Destination, size limit and accepted categories of completed uploads
+	Expiry        time.Duration      // Time after the last request until an unfinished upload is removed, 24 hours when zero
+	MaxParts      int                // Parts kept per upload before they are merged into one, 256 when zero
+	OnComplete    func(UploadResult) // Called once an upload is stored, may be nil
+}
+
+// TusHandler serves resumable uploads with the tus 1.0 protocol, see https://tus.io.
+// Received parts are sealed into Config.TempDir as they arrive and joined into the
+// destination file once the upload is complete. It supports the creation, expiration
+// and termination extensions.
+type TusHandler struct {
+	fm        *FileManager
+	options   TusOptions
+	mu        sync.Mutex
+	busy      map[string]bool // Uploads with a request in progress
+	lastPurge time.Time
+}
+
+// tusUpload is the state of an upload, stored next to its parts
+type tusUpload struct {
+	Length   int64         `json:"length"`
+	Offset   int64         `json:"offset"`
+	Parts    int           `json:"parts"`
+	First    int           `json:"first,omitempty"` // Number of the first part, parts are numbered from 1 before a merge
+	Filename string        `json:"filename"`
+	Metadata string        `json:"metadata,omitempty"` // Upload-Metadata as sent by the client
+	Expires  time.Time     `json:"expires"`
+	Result   *UploadResult `json:"result,omitempty"` // Set once the upload is stored
+}
+
+// TusHandler returns a handler for resumable uploads. Mount it on a path ending with
+// a slash, such as "/uploads/"; uploads are created there and live below it.
+func (fm *FileManager) TusHandler(options TusOptions) *TusHandler {
+	if options.MaxSize == 0 {
+		options.MaxSize = DefaultMaxUploadSize
+	}
+	if options.Expiry <= 0 {
+		options.Expiry = tusDefaultExpiry
+	}
+	if options.MaxParts <= 0 {
+		options.MaxParts = tusDefaultMaxParts
+	}
+	return &TusHandler{fm: fm, options: options, busy: make(map[string]bool)}
+}
+
+// ServeHTTP handles a tus request
+func (h *TusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Set("Tus-Resumable", TusVersion)
+
+	if r.Method == http.MethodOptions {
+		header.Set("Tus-Version", TusVersion)
+		header.Set("Tus-Extension", "creation,expiration,termination")
+		if h.options.MaxSize > 0 {
+			header.Set("Tus-Max-Size", strconv.FormatInt(h.options.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != TusVersion {
+		header.Set("Tus-Version", TusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	// Clients unable to send PATCH or DELETE override the method
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" {
+		method = strings.ToUpper(override)
+	}
+
+	fm := h.fm.WithContext(r.Context())
+	if method == http.MethodPost {
+		h.create(w, r, fm)
+		return
+	}
+
+	id := path.Base(r.URL.Path)
+	if !isUploadID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.lock(id) {
+		http.Error(w, "upload is busy", http.StatusLocked)
+		return
+	}
+	defer h.unlock(id)
+
+	switch method {
+	case http.MethodHead:
+		h.head(w, r, fm, id)
+	case http.MethodPatch:
+		h.patch(w, r, fm, id)
+	case http.MethodDelete:
+		h.terminate(w, r, fm, id)
+	default:
+		header.Set("Allow", "OPTIONS, POST, HEAD, PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// create starts a new upload
+func (h *TusHandler) create(w http.ResponseWriter, r *http.Request, fm *FileManager) {
+	h.purgeOccasionally(fm)
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if h.options.MaxSize >= 0 && length > h.options.MaxSize {
+		http.Error(w, fmt.Sprintf("upload exceeds %d bytes", h.options.MaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "invalid Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+	upload := &tusUpload{
+		First:    1,
+		Length:   length,
+		Filename: firstValue(metadata, "filename", "name"),
+		Metadata: r.Header.Get("Upload-Metadata"),
+		Expires:  time.Now().Add(h.options.Expiry),
+	}
+	if upload.Filename == "" {
+		http.Error(w, "missing filename in Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	// Refuse uploads that cannot be stored before any data is sent
+	if _, err := fm.prepareUpload(upload.Filename, h.options.UploadOptions); err != nil {
+		writeUploadError(w, err, nil)
+		return
+	}
+
+	id, err := newUploadID()
+	if err != nil {
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	dir, err := h.uploadDir(fm, id)
+	if err == nil {
+		err = EnsureDirectory(dir)
+	}
+	if err == nil {
+		err = upload.save(dir)
+	}
+	if err == nil && length == 0 {
+		err = h.finish(fm, dir, upload)
+	}
+	if err != nil {
+		writeUploadError(w, err, nil)
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id)
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// head reports the offset of an upload
+func (h *TusHandler) head(w http.ResponseWriter, r *http.Request, fm *FileManager, id string) {
+	_, upload, ok := h.load(w, r, fm, id)
+	if !ok {
+		return
+	}
+
+	header := w.Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	header.Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	header.Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	if upload.Metadata != "" {
+		header.Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch seals the request body as the next part of an upload. A body cut short is kept
+// up to where it broke off, so that the client can resume from there.
+func (h *TusHandler) patch(w http.ResponseWriter, r *http.Request, fm *FileManager, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	dir, upload, ok := h.load(w, r, fm, id)
+	if !ok {
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+
+	if upload.Offset < upload.Length {
+		if upload.Parts >= h.options.MaxParts {
+			if err := h.mergeParts(fm, dir, upload); err != nil {
+				writeUploadError(w, err, nil)
+				return
+			}
+		}
+
+		body := &interruptedReader{r: r.Body}
+		counter := &limitedReader{r: body, limit: upload.Length - upload.Offset}
+		// Parts live in the temporary directory managed by the handler, only the destination is authorized
+		part := fm.internal().NewSecureFile(nil, dir, partName(upload.firstPart()+upload.Parts))
+		if err := part.SaveEncryptedFrom(counter); err != nil {
+			if counter.exceeded {
+				http.Error(w, "upload exceeds Upload-Length", http.StatusRequestEntityTooLarge)
+				return
+			}
+			writeUploadError(w, err, nil)
+			return
+		}
+
+		if counter.read == 0 {
+			_ = part.Delete()
+		} else {
+			upload.Offset += counter.read
+			upload.Parts++
+		}
+		upload.Expires = time.Now().Add(h.options.Expiry)
+		if err := upload.save(dir); err != nil {
+			writeUploadError(w, err, nil)
+			return
+		}
+		if body.err != nil {
+			// The connection is gone, the client resumes from the stored offset
+			return
+		}
+	}
+
+	// Finishing is retried by the next request when it failed
+	if upload.Offset == upload.Length && upload.Result == nil {
+		if err := h.finish(fm, dir, upload); err != nil {
+			// Refused content would be refused again, the upload is discarded
+			if errors.Is(err, ErrContentRejected) || errors.Is(err, ErrScanRejected) {
+				_ = os.RemoveAll(dir)
+			}
+			writeUploadError(w, err, nil)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// terminate removes an upload and its parts
+func (h *TusHandler) terminate(w http.ResponseWriter, r *http.Request, fm *FileManager, id string) {
+	dir, _, ok := h.load(w, r, fm, id)
+	if !ok {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		http.Error(w, "failed to remove upload", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finish joins the parts of a complete upload into the destination file
+func (h *TusHandler) finish(fm *FileManager, dir string, upload *tusUpload) error {
+	parts := upload.openParts(fm, dir)
+	defer func() { _ = parts.Close() }()
+	result, err := fm.storeUpload(parts, upload.Filename, h.options.UploadOptions)
+	if err != nil {
+		return err
+	}
+
+	// Keep the state until it expires, so that clients asking after a lost response see the upload finished
+	upload.Result = result
+	if err := upload.save(dir); err != nil {
+		return err
+	}
+	removeParts(dir, upload.firstPart(), upload.firstPart()+upload.Parts)
+
+	if h.options.OnComplete != nil {
+		h.options.OnComplete(*upload.Result)
+	}
+	return nil
+}
+
+// mergeParts seals the parts of an upload into a single part, so that uploads sent in
+// many small requests keep at most MaxParts files. The merged part takes the next number
+// and the state moves to it before the merged parts are removed; an interrupted merge
+// leaves the upload as it was.
+func (h *TusHandler) mergeParts(fm *FileManager, dir string, upload *tusUpload) error {
+	first, next := upload.firstPart(), upload.firstPart()+upload.Parts
+	parts := upload.openParts(fm, dir)
+	defer func() { _ = parts.Close() }()
+	if err := fm.internal().NewSecureFile(nil, dir, partName(next)).SaveEncryptedFrom(parts); err != nil {
+		return err
+	}
+
+	upload.First, upload.Parts = next, 1
+	if err := upload.save(dir); err != nil {
+		return err
+	}
+	removeParts(dir, first, next)
+	return nil
+}
+
+// load reads the state of an upload and answers the request when it does not exist or expired
+func (h *TusHandler) load(w http.ResponseWriter, r *http.Request, fm *FileManager, id string) (string, *tusUpload, bool) {
+	dir, err := h.uploadDir(fm, id)
+	if err != nil {
+		http.NotFound(w, r)
+		return "", nil, false
+	}
+
+	upload, err := loadUpload(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return "", nil, false
+	}
+	if err != nil {
+		http.Error(w, "failed to read upload", http.StatusInternalServerError)
+		return "", nil, false
+	}
+	if time.Now().After(upload.Expires) {
+		_ = os.RemoveAll(dir)
+		http.NotFound(w, r)
+		return "", nil, false
+	}
+	return dir, upload, true
+}
+
+// PurgeExpired removes uploads that expired and reports how many were removed.
+// Expired uploads are also removed while new uploads are created; without new
+// uploads they stay until PurgeExpired runs, see PurgeEvery.
+func (h *TusHandler) PurgeExpired() (int, error) {
+	return h.purge(h.fm.pinned())
+}
+
+// PurgeEvery runs PurgeExpired at every interval until the returned function is called.
+// A failed purge is reported to onError when given.
+func (h *TusHandler) PurgeEvery(interval time.Duration, onError func(error)) func() {
+	if interval <= 0 {
+		interval = tusPurgeInterval
+	}
+
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if _, err := h.PurgeExpired(); err != nil && onError != nil {
+				onError(fmt.Errorf("failed to purge uploads: %w", err))
+			}
+		}
+	}()
+
+	var stop sync.Once
+	return func() {
+		stop.Do(func() { close(done) })
+	}
+}
+
+// purgeOccasionally removes expired uploads at most once per purge interval
+func (h *TusHandler) purgeOccasionally(fm *FileManager) {
+	h.mu.Lock()
+	due := time.Since(h.lastPurge) >= tusPurgeInterval
+	if due {
+		h.lastPurge = time.Now()
+	}
+	h.mu.Unlock()
+
+	if due {
+		_, _ = h.purge(fm)
+	}
+}
+
+// purge removes the expired uploads that no request is using
+func (h *TusHandler) purge(fm *FileManager) (int, error) {
+	snapshot := fm.current()
+	root, err := snapshot.resolveDir(filepath.Join(snapshot.config.TempDir, tusDir))
+	if err != nil {
+		return 0, err
+	}
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to list uploads: %w", err)
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		id := entry.Name()
+		if !entry.IsDir() || !isUploadID(id) || !h.lock(id) {
+			continue
+		}
+
+		dir := filepath.Join(root, id)
+		var expires time.Time
+		if upload, err := loadUpload(dir); err == nil {
+			expires = upload.Expires
+		} else if info, err := entry.Info(); err == nil {
+			// Uploads whose state was never written expire from their creation
+			expires = info.ModTime().Add(h.options.Expiry)
+		}
+		if !expires.IsZero() && time.Now().After(expires) {
+			if err := os.RemoveAll(dir); err == nil {
+				removed++
+			}
+		}
+		h.unlock(id)
+	}
+	return removed, nil
+}
+
+// lock marks an upload busy, it fails when another request is using it
+func (h *TusHandler) lock(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busy[id] {
+		return false
+	}
+	h.busy[id] = true
+	return true
+}
+
+// unlock releases an upload
+func (h *TusHandler) unlock(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.busy, id)
+}
+
+// uploadDir returns the directory holding the state and parts of an upload
+func (h *TusHandler) uploadDir(fm *FileManager, id string) (string, error) {
+	snapshot := fm.current()
+	return snapshot.resolveDir(filepath.Join(snapshot.config.TempDir, tusDir, id))
+}
+
+// save writes the state of an upload
+func (u *tusUpload) save(dir string) error {
+	return writeFileAtomic(filepath.Join(dir, tusInfoFile), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(u)
+	})
+}
+
+// firstPart returns the number of the first part of an upload
+func (u *tusUpload) firstPart() int {
+	if u.First < 1 {
+		return 1
+	}
+	return u.First
+}
+
+// openParts returns a reader of the parts of an upload in order
+func (u *tusUpload) openParts(fm *FileManager, dir string) *partsReader {
+	return &partsReader{fm: fm.internal(), dir: dir, next: u.firstPart(), end: u.firstPart() + u.Parts}
+}
+
+// removeParts removes the parts numbered from first up to end, exclusive
+func removeParts(dir string, first, end int) {
+	for i := first; i < end; i++ {
+		_ = os.Remove(filepath.Join(dir, partName(i)))
+	}
+}
+
+// loadUpload reads the state of an upload
+func loadUpload(dir string) (*tusUpload, error) {
+	data, err := os.ReadFile(filepath.Join(dir, tusInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	var upload tusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("invalid upload state: %w", err)
+	}
+	return &upload, nil
+}
+
+// partsReader reads the parts of an upload one after another, only one part is open at a time
+type partsReader struct {
+	fm      *FileManager
+	dir     string
+	next    int // Number of the part opened next
+	end     int // Number after the last part
+	current io.ReadCloser
+}
+
+// Read reads from the current part, opening the next one at the end of it
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if p.next >= p.end {
+				return 0, io.EOF
+			}
+			// Parts sealed before a key rotation open with a previous key
+			_, reader, err := p.fm.openWithAnyKey(p.dir, partName(p.next))
+			if err != nil {
+				return 0, err
+			}
+			p.current = reader
+			p.next++
+		}
+
+		n, err := p.current.Read(b)
+		if err == io.EOF {
+			err = p.current.Close()
+			p.current = nil
+			if n > 0 || err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Close closes the open part
+func (p *partsReader) Close() error {
+	if p.current == nil {
+		return nil
+	}
+	err := p.current.Close()
+	p.current = nil
+	return err
+}
+
+// interruptedReader ends the data at the first read error and records the error
+type interruptedReader struct {
+	r   io.Reader
+	err error
+}
+
+// Read reads from the underlying reader, reporting io.EOF instead of errors
+func (r *interruptedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header, comma separated keys with base64 values
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+		case 1:
+			metadata[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			metadata[fields[0]] = string(value)
+		default:
+			return nil, fmt.Errorf("invalid metadata pair %q", pair)
+		}
+	}
+	return metadata, nil
+}
+
+// firstValue returns the value of the first key present in metadata
+func firstValue(metadata map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if value := metadata[key]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// newUploadID returns a random upload identifier
+func newUploadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// isUploadID reports whether id has the form of an upload identifier
+func isUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// partName returns the file name of the sealed part with the given number
+func partName(number int) string {
+	return fmt.Sprintf("part-%06d", number)
+}
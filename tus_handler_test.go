@@ -0,0 +1,95 @@
+package sealfile
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tusRequest sends a tus request to h
+func tusRequest(h http.Handler, method, target string, header map[string]string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Tus-Resumable", TusVersion)
+	for key, value := range header {
+		r.Header.Set(key, value)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// createTusUpload starts an upload of length bytes and returns its location
+func createTusUpload(t *testing.T, h http.Handler, filename string, length int) string {
+	t.Helper()
+	w := tusRequest(h, http.MethodPost, "/uploads/", map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	}, "")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create answered %d: %s", w.Code, w.Body)
+	}
+	return w.Header().Get("Location")
+}
+
+func TestTusMergesParts(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	h := fm.TusHandler(TusOptions{UploadOptions: UploadOptions{Dir: "uploads"}, MaxParts: 3})
+
+	content := "sent in many small requests"
+	location := createTusUpload(t, h, "small.txt", len(content))
+	for offset := 0; offset < len(content); offset += 2 {
+		end := min(offset+2, len(content))
+		w := tusRequest(h, http.MethodPatch, location, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}, content[offset:end])
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("patch at %d answered %d: %s", offset, w.Code, w.Body)
+		}
+
+		if end < len(content) {
+			parts, err := filepath.Glob(filepath.Join(rootPath(fm, "temp/tus"), filepath.Base(location), "part-*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(parts) > 3 {
+				t.Fatalf("upload keeps %d parts", len(parts))
+			}
+		}
+	}
+
+	loaded, err := fm.LoadSecureFileFromDisk(filepath.Join(fm.current().config.PublicDir, "uploads"), "small.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.Data) != content {
+		t.Fatalf("stored %q, want %q", loaded.Data, content)
+	}
+}
+
+func TestTusPurgeEvery(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	h := fm.TusHandler(TusOptions{UploadOptions: UploadOptions{Dir: "uploads"}, Expiry: 10 * time.Millisecond})
+	location := createTusUpload(t, h, "left.txt", 10)
+	dir := filepath.Join(rootPath(fm, "temp/tus"), filepath.Base(location))
+
+	stop := h.PurgeEvery(5*time.Millisecond, func(err error) { t.Error(err) })
+	defer stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired upload was not purged")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,313 @@
+package sealfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileCategory names a group of file types
+type FileCategory string
+
+// File categories of the built-in types
+const (
+	CategoryImage      FileCategory = "image"
+	CategoryVideo      FileCategory = "video"
+	CategoryAudio      FileCategory = "audio"
+	CategoryDocument   FileCategory = "document"
+	CategoryArchive    FileCategory = "archive"
+	CategoryExecutable FileCategory = "executable"
+)
+
+// matches reports whether a filename belongs to the category by its extension
+func (c FileCategory) matches(filename string) bool {
+	fileType, ok := DefaultTypeRegistry.Lookup(filename)
+	return ok && fileType.Category == c
+}
+
+// detects reports whether a file belongs to the category by its name and content
+func (c FileCategory) detects(filename string, head []byte) bool {
+	fileType, ok := DefaultTypeRegistry.Detect(filename, head)
+	return ok && fileType.Category == c
+}
+
+// Signature identifies content by its bytes at an offset. Mask, when set, selects the
+// bits of Magic that must match, so that a 0x00 mask byte skips a byte.
+type Signature struct {
+	Offset int
+	Magic  []byte
+	Mask   []byte
+}
+
+// matches reports whether head holds the signature
+func (s Signature) matches(head []byte) bool {
+	if len(head) < s.Offset+len(s.Magic) {
+		return false
+	}
+	for i, b := range s.Magic {
+		mask := byte(0xff)
+		if s.Mask != nil {
+			mask = s.Mask[i]
+		}
+		if head[s.Offset+i]&mask != b&mask {
+			return false
+		}
+	}
+	return true
+}
+
+// FileType describes a file type known to a TypeRegistry
+type FileType struct {
+	Name       string       // Short name, such as "jpeg"
+	Category   FileCategory // Category the type belongs to
+	MIMEType   string       // Media type, such as "image/jpeg"
+	Extensions []string     // Extensions with their leading dot, such as ".jpg"
+	Signatures []Signature  // Magic bytes of the content, any one identifies the type; none for text formats
+}
+
+// Matches reports whether head, the start of some content, holds a signature of the type
+func (t FileType) Matches(head []byte) bool {
+	for _, signature := range t.Signatures {
+		if signature.matches(head) {
+			return true
+		}
+	}
+	return false
+}
+
+// TypeRegistry maps extensions, media types and magic bytes onto file types.
+// It is safe for concurrent use.
+type TypeRegistry struct {
+	mu     sync.RWMutex
+	types  []FileType
+	byExt  map[string]int // Index into types
+	byMIME map[string]int
+}
+
+// DefaultTypeRegistry holds the built-in types and backs IsImageFile, IsImageContent and
+// their siblings. Types registered with it are recognized throughout the package.
+var DefaultTypeRegistry = NewTypeRegistry(builtinTypes()...)
+
+// NewTypeRegistry creates a registry of the given types, see Register
+func NewTypeRegistry(types ...FileType) *TypeRegistry {
+	r := &TypeRegistry{byExt: make(map[string]int), byMIME: make(map[string]int)}
+	for _, fileType := range types {
+		if err := r.Register(fileType); err != nil {
+			panic(err)
+		}
+	}
+	return r
+}
+
+// Register adds a file type. Its extensions and media type take precedence over those
+// of types registered before. Content matching signatures of several types is detected
+// as the type registered first.
+func (r *TypeRegistry) Register(fileType FileType) error {
+	if fileType.Name == "" || fileType.Category == "" {
+		return fmt.Errorf("invalid file type %q: name and category are required", fileType.Name)
+	}
+	for _, signature := range fileType.Signatures {
+		if len(signature.Magic) == 0 || signature.Offset < 0 || signature.Mask != nil && len(signature.Mask) != len(signature.Magic) {
+			return fmt.Errorf("invalid file type %q: invalid signature", fileType.Name)
+		}
+	}
+
+	fileType.MIMEType = strings.ToLower(fileType.MIMEType)
+	extensions := make([]string, len(fileType.Extensions))
+	for i, ext := range fileType.Extensions {
+		extensions[i] = normalizeExtension(ext)
+	}
+	fileType.Extensions = extensions
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	index := len(r.types)
+	r.types = append(r.types, fileType)
+	for _, ext := range fileType.Extensions {
+		r.byExt[ext] = index
+	}
+	if fileType.MIMEType != "" {
+		r.byMIME[fileType.MIMEType] = index
+	}
+	return nil
+}
+
+// Lookup returns the type registered for the extension of name
+func (r *TypeRegistry) Lookup(name string) (FileType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	index, ok := r.byExt[normalizeExtension(filepath.Ext(name))]
+	if !ok {
+		return FileType{}, false
+	}
+	return r.types[index], true
+}
+
+// LookupMIME returns the type registered for a media type, parameters are ignored
+func (r *TypeRegistry) LookupMIME(mimeType string) (FileType, bool) {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	index, ok := r.byMIME[strings.ToLower(strings.TrimSpace(mimeType))]
+	if !ok {
+		return FileType{}, false
+	}
+	return r.types[index], true
+}
+
+// Sniff returns the type whose signature head holds. The first 512 bytes of the
+// content are enough for every built-in type.
+func (r *TypeRegistry) Sniff(head []byte) (FileType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, fileType := range r.types {
+		if fileType.Matches(head) {
+			return fileType, true
+		}
+	}
+	return FileType{}, false
+}
+
+// Detect returns the type of a file from its name and the start of its content.
+// The type of the extension is kept when the content agrees with it or cannot tell;
+// content identifying another type wins, so renamed files are recognized. Without
+// content, pass a nil head to detect by name only.
+func (r *TypeRegistry) Detect(name string, head []byte) (FileType, bool) {
+	byName, named := r.Lookup(name)
+	if named && byName.Matches(head) {
+		return byName, true
+	}
+	if byContent, ok := r.Sniff(head); ok {
+		return byContent, true
+	}
+	return byName, named
+}
+
+// knows reports whether a type or a category is registered under name
+func (r *TypeRegistry) knows(name string) bool {
+	name = strings.ToLower(name)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, fileType := range r.types {
+		if strings.ToLower(fileType.Name) == name || strings.ToLower(string(fileType.Category)) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeExtension returns an extension in lower case with its leading dot
+func normalizeExtension(ext string) string {
+	ext = strings.ToLower(ext)
+	if ext != "" && !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	return ext
+}
+
+// magic returns a signature of bytes at an offset
+func magic(offset int, b string) Signature {
+	return Signature{Offset: offset, Magic: []byte(b)}
+}
+
+// riff returns the signature of a RIFF container of the given form, such as "WEBP"
+func riff(form string) Signature {
+	return Signature{
+		Magic: []byte("RIFF\x00\x00\x00\x00" + form),
+		Mask:  []byte("\xff\xff\xff\xff\x00\x00\x00\x00\xff\xff\xff\xff"),
+	}
+}
+
+// mpegAudioFrame matches the frame sync of an MPEG audio frame of layer III. The layer
+// bits keep AAC ADTS headers, whose layer is always 00, from matching.
+var mpegAudioFrame = Signature{Magic: []byte("\xff\xe2"), Mask: []byte("\xff\xe6")}
+
+// ftyp returns the signatures of ISO base media files with one of the given major brands
+func ftyp(brands ...string) []Signature {
+	signatures := make([]Signature, len(brands))
+	for i, brand := range brands {
+		signatures[i] = magic(4, "ftyp"+brand)
+	}
+	return signatures
+}
+
+// builtinTypes returns the types of DefaultTypeRegistry. Generic containers come before
+// the formats built on them, so that content alone is detected as the container.
+func builtinTypes() []FileType {
+	ole := []Signature{magic(0, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")}
+	zip := []Signature{magic(0, "PK\x03\x04"), magic(0, "PK\x05\x06")}
+	asf := []Signature{magic(0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11")}
+	ebml := []Signature{magic(0, "\x1a\x45\xdf\xa3")}
+	ogg := []Signature{magic(0, "OggS")}
+
+	return []FileType{
+		// Images
+		{Name: "jpeg", Category: CategoryImage, MIMEType: "image/jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe", ".jfif"}, Signatures: []Signature{magic(0, "\xff\xd8\xff")}},
+		{Name: "png", Category: CategoryImage, MIMEType: "image/png", Extensions: []string{".png"}, Signatures: []Signature{magic(0, "\x89PNG\r\n\x1a\n")}},
+		{Name: "gif", Category: CategoryImage, MIMEType: "image/gif", Extensions: []string{".gif"}, Signatures: []Signature{magic(0, "GIF87a"), magic(0, "GIF89a")}},
+		{Name: "bmp", Category: CategoryImage, MIMEType: "image/bmp", Extensions: []string{".bmp"}, Signatures: []Signature{magic(0, "BM")}},
+		{Name: "webp", Category: CategoryImage, MIMEType: "image/webp", Extensions: []string{".webp"}, Signatures: []Signature{riff("WEBP")}},
+		{Name: "tiff", Category: CategoryImage, MIMEType: "image/tiff", Extensions: []string{".tif", ".tiff"}, Signatures: []Signature{magic(0, "II*\x00"), magic(0, "MM\x00*")}},
+		{Name: "heic", Category: CategoryImage, MIMEType: "image/heic", Extensions: []string{".heic", ".heif"}, Signatures: ftyp("heic", "heix", "hevc", "mif1", "msf1")},
+		{Name: "avif", Category: CategoryImage, MIMEType: "image/avif", Extensions: []string{".avif"}, Signatures: ftyp("avif", "avis")},
+		{Name: "ico", Category: CategoryImage, MIMEType: "image/x-icon", Extensions: []string{".ico"}, Signatures: []Signature{magic(0, "\x00\x00\x01\x00")}},
+		{Name: "svg", Category: CategoryImage, MIMEType: "image/svg+xml", Extensions: []string{".svg"}},
+
+		// Video
+		{Name: "mp4", Category: CategoryVideo, MIMEType: "video/mp4", Extensions: []string{".mp4", ".m4v"}, Signatures: ftyp("isom", "iso2", "mp41", "mp42", "avc1", "M4V ", "dash")},
+		{Name: "mov", Category: CategoryVideo, MIMEType: "video/quicktime", Extensions: []string{".mov"}, Signatures: ftyp("qt  ")},
+		{Name: "avi", Category: CategoryVideo, MIMEType: "video/x-msvideo", Extensions: []string{".avi"}, Signatures: []Signature{riff("AVI ")}},
+		{Name: "mkv", Category: CategoryVideo, MIMEType: "video/x-matroska", Extensions: []string{".mkv"}, Signatures: ebml},
+		{Name: "webm", Category: CategoryVideo, MIMEType: "video/webm", Extensions: []string{".webm"}, Signatures: ebml},
+		{Name: "flv", Category: CategoryVideo, MIMEType: "video/x-flv", Extensions: []string{".flv"}, Signatures: []Signature{magic(0, "FLV\x01")}},
+		{Name: "wmv", Category: CategoryVideo, MIMEType: "video/x-ms-wmv", Extensions: []string{".wmv"}, Signatures: asf},
+
+		// Audio
+		{Name: "mp3", Category: CategoryAudio, MIMEType: "audio/mpeg", Extensions: []string{".mp3"}, Signatures: []Signature{magic(0, "ID3"), mpegAudioFrame}},
+		{Name: "wav", Category: CategoryAudio, MIMEType: "audio/wav", Extensions: []string{".wav"}, Signatures: []Signature{riff("WAVE")}},
+		{Name: "flac", Category: CategoryAudio, MIMEType: "audio/flac", Extensions: []string{".flac"}, Signatures: []Signature{magic(0, "fLaC")}},
+		{Name: "aac", Category: CategoryAudio, MIMEType: "audio/aac", Extensions: []string{".aac"}, Signatures: []Signature{magic(0, "\xff\xf1"), magic(0, "\xff\xf9")}},
+		{Name: "ogg", Category: CategoryAudio, MIMEType: "audio/ogg", Extensions: []string{".ogg", ".oga"}, Signatures: ogg},
+		{Name: "opus", Category: CategoryAudio, MIMEType: "audio/opus", Extensions: []string{".opus"}, Signatures: ogg},
+		{Name: "wma", Category: CategoryAudio, MIMEType: "audio/x-ms-wma", Extensions: []string{".wma"}, Signatures: asf},
+		{Name: "m4a", Category: CategoryAudio, MIMEType: "audio/mp4", Extensions: []string{".m4a"}, Signatures: ftyp("M4A ")},
+
+		// Documents
+		{Name: "pdf", Category: CategoryDocument, MIMEType: "application/pdf", Extensions: []string{".pdf"}, Signatures: []Signature{magic(0, "%PDF-")}},
+		{Name: "rtf", Category: CategoryDocument, MIMEType: "application/rtf", Extensions: []string{".rtf"}, Signatures: []Signature{magic(0, "{\\rtf")}},
+		{Name: "doc", Category: CategoryDocument, MIMEType: "application/msword", Extensions: []string{".doc"}, Signatures: ole},
+		{Name: "xls", Category: CategoryDocument, MIMEType: "application/vnd.ms-excel", Extensions: []string{".xls"}, Signatures: ole},
+		{Name: "ppt", Category: CategoryDocument, MIMEType: "application/vnd.ms-powerpoint", Extensions: []string{".ppt"}, Signatures: ole},
+		{Name: "txt", Category: CategoryDocument, MIMEType: "text/plain", Extensions: []string{".txt"}},
+		{Name: "csv", Category: CategoryDocument, MIMEType: "text/csv", Extensions: []string{".csv"}},
+		{Name: "markdown", Category: CategoryDocument, MIMEType: "text/markdown", Extensions: []string{".md", ".markdown"}},
+
+		// Archives, then the document formats stored as ZIP archives
+		{Name: "zip", Category: CategoryArchive, MIMEType: "application/zip", Extensions: []string{".zip"}, Signatures: zip},
+		{Name: "gzip", Category: CategoryArchive, MIMEType: "application/gzip", Extensions: []string{".gz", ".tgz"}, Signatures: []Signature{magic(0, "\x1f\x8b")}},
+		{Name: "tar", Category: CategoryArchive, MIMEType: "application/x-tar", Extensions: []string{".tar"}, Signatures: []Signature{magic(257, "ustar")}},
+		{Name: "7z", Category: CategoryArchive, MIMEType: "application/x-7z-compressed", Extensions: []string{".7z"}, Signatures: []Signature{magic(0, "7z\xbc\xaf\x27\x1c")}},
+		{Name: "rar", Category: CategoryArchive, MIMEType: "application/vnd.rar", Extensions: []string{".rar"}, Signatures: []Signature{magic(0, "Rar!\x1a\x07")}},
+		{Name: "docx", Category: CategoryDocument, MIMEType: "application/vnd.openxmlformats-officedocument.wordprocessingml.document", Extensions: []string{".docx"}, Signatures: zip},
+		{Name: "xlsx", Category: CategoryDocument, MIMEType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Extensions: []string{".xlsx"}, Signatures: zip},
+		{Name: "pptx", Category: CategoryDocument, MIMEType: "application/vnd.openxmlformats-officedocument.presentationml.presentation", Extensions: []string{".pptx"}, Signatures: zip},
+		{Name: "odt", Category: CategoryDocument, MIMEType: "application/vnd.oasis.opendocument.text", Extensions: []string{".odt"}, Signatures: zip},
+		{Name: "ods", Category: CategoryDocument, MIMEType: "application/vnd.oasis.opendocument.spreadsheet", Extensions: []string{".ods"}, Signatures: zip},
+		{Name: "odp", Category: CategoryDocument, MIMEType: "application/vnd.oasis.opendocument.presentation", Extensions: []string{".odp"}, Signatures: zip},
+		{Name: "pages", Category: CategoryDocument, MIMEType: "application/vnd.apple.pages", Extensions: []string{".pages"}, Signatures: zip},
+
+		// Executables
+		{Name: "exe", Category: CategoryExecutable, MIMEType: "application/vnd.microsoft.portable-executable", Extensions: []string{".exe", ".dll", ".scr"}, Signatures: []Signature{magic(0, "MZ")}},
+		{Name: "elf", Category: CategoryExecutable, MIMEType: "application/x-elf", Extensions: []string{".so", ".elf"}, Signatures: []Signature{magic(0, "\x7fELF")}},
+		{Name: "mach-o", Category: CategoryExecutable, MIMEType: "application/x-mach-binary", Extensions: []string{".dylib"}, Signatures: []Signature{
+			magic(0, "\xfe\xed\xfa\xce"), magic(0, "\xfe\xed\xfa\xcf"), magic(0, "\xce\xfa\xed\xfe"), magic(0, "\xcf\xfa\xed\xfe"),
+		}},
+		{Name: "script", Category: CategoryExecutable, MIMEType: "text/x-shellscript", Extensions: []string{".sh"}, Signatures: []Signature{magic(0, "#!")}},
+	}
+}
@@ -0,0 +1,193 @@
+package sealfile
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+
+	tests := []struct {
+		name     string
+		filename string
+		head     []byte
+		want     string // Name of the detected type, empty when none is
+	}{
+		{"agreeing content", "photo.jpg", []byte("\xff\xd8\xff\xe0"), "jpeg"},
+		{"name only", "photo.jpg", nil, "jpeg"},
+		{"extension case", "SONG.MP3", nil, "mp3"},
+		{"text without signature", "notes.txt", []byte("plain words"), "txt"},
+		{"renamed program", "photo.jpg", []byte("MZ\x90\x00"), "exe"},
+		{"renamed image", "report.pdf", []byte("\x89PNG\r\n\x1a\n"), "png"},
+		{"format on a container", "report.docx", []byte("PK\x03\x04"), "docx"},
+		{"shared signature", "clip.webm", []byte("\x1a\x45\xdf\xa3"), "webm"},
+		{"signature at an offset", "backup.bin", tar, "tar"},
+		{"unknown extension", "data.bin", []byte("GIF89a"), "gif"},
+		{"unknown name and content", "data.bin", []byte("nothing"), ""},
+		{"no extension", "README", nil, ""},
+	}
+	for _, test := range tests {
+		fileType, ok := DefaultTypeRegistry.Detect(test.filename, test.head)
+		if ok != (test.want != "") || fileType.Name != test.want {
+			t.Errorf("%s: detected %q, %v, want %q", test.name, fileType.Name, ok, test.want)
+		}
+	}
+}
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want string // Name of the sniffed type, empty when none is
+	}{
+		{"RIFF WebP", "RIFF\x24\x10\x00\x00WEBPVP8 ", "webp"},
+		{"RIFF WAVE", "RIFF\xff\xff\xff\xffWAVEfmt ", "wav"},
+		{"RIFF AVI", "RIFF\x00\x00\x00\x00AVI LIST", "avi"},
+		{"RIFF of another form", "RIFF\x24\x10\x00\x00CDDA", ""},
+		{"RIFX WebP", "RIFX\x24\x10\x00\x00WEBPVP8 ", ""},
+		{"MPEG-1 layer III frame", "\xff\xfb\x90\x64", "mp3"},
+		{"MPEG-2 layer III frame", "\xff\xf3\x40\xc4", "mp3"},
+		{"MPEG-2.5 layer III frame", "\xff\xe3\x18\xc4", "mp3"},
+		{"ID3 tag", "ID3\x04\x00", "mp3"},
+		{"AAC ADTS", "\xff\xf1\x50\x80", "aac"},
+		{"MPEG-2 AAC ADTS", "\xff\xf9\x50\x80", "aac"},
+		{"no frame sync", "\xff\x1b\x90\x64", ""},
+		{"ISO base media brand", "\x00\x00\x00\x18ftypheic", "heic"},
+		{"container before its formats", "PK\x03\x04\x14\x00", "zip"},
+		{"head shorter than the signature", "\x89PN", ""},
+		{"empty head", "", ""},
+	}
+	for _, test := range tests {
+		fileType, ok := DefaultTypeRegistry.Sniff([]byte(test.head))
+		if ok != (test.want != "") || fileType.Name != test.want {
+			t.Errorf("%s: sniffed %q, %v, want %q", test.name, fileType.Name, ok, test.want)
+		}
+	}
+}
+
+func TestRegisterPrecedence(t *testing.T) {
+	r := NewTypeRegistry(builtinTypes()...)
+	err := r.Register(FileType{
+		Name:       "camera-jpeg",
+		Category:   CategoryImage,
+		MIMEType:   "Image/JPEG",
+		Extensions: []string{"JPG"},
+		Signatures: []Signature{magic(0, "\xff\xd8\xff")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Later extensions and media types win, signatures go to the type registered first
+	if fileType, _ := r.Lookup("photo.jpg"); fileType.Name != "camera-jpeg" {
+		t.Fatalf("looked up %q by extension", fileType.Name)
+	}
+	if fileType, _ := r.LookupMIME("image/jpeg; q=0.9"); fileType.Name != "camera-jpeg" {
+		t.Fatalf("looked up %q by media type", fileType.Name)
+	}
+	if fileType, _ := r.Lookup("photo.jpeg"); fileType.Name != "jpeg" {
+		t.Fatalf("looked up %q by another extension", fileType.Name)
+	}
+	if fileType, _ := r.Sniff([]byte("\xff\xd8\xff\xe0")); fileType.Name != "jpeg" {
+		t.Fatalf("sniffed %q", fileType.Name)
+	}
+	if fileType, _ := r.Detect("photo.jpg", []byte("\xff\xd8\xff\xe0")); fileType.Name != "camera-jpeg" {
+		t.Fatalf("detected %q", fileType.Name)
+	}
+	if fileType, _ := DefaultTypeRegistry.Lookup("photo.jpg"); fileType.Name != "jpeg" {
+		t.Fatal("a separate registry changed DefaultTypeRegistry")
+	}
+
+	invalid := []FileType{
+		{Category: CategoryImage},
+		{Name: "nameless-category"},
+		{Name: "empty", Category: CategoryImage, Signatures: []Signature{{}}},
+		{Name: "negative", Category: CategoryImage, Signatures: []Signature{{Offset: -1, Magic: []byte("x")}}},
+		{Name: "mask", Category: CategoryImage, Signatures: []Signature{{Magic: []byte("xy"), Mask: []byte{0xff}}}},
+	}
+	for _, fileType := range invalid {
+		if err := r.Register(fileType); err == nil {
+			t.Errorf("registered invalid type %+v", fileType)
+		}
+	}
+}
+
+func TestConcurrentRegistration(t *testing.T) {
+	r := NewTypeRegistry(builtinTypes()...)
+	var wg sync.WaitGroup
+	for worker := range 8 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := range 50 {
+				name := fmt.Sprintf("type-%d-%02d", worker, i)
+				err := r.Register(FileType{
+					Name:       name,
+					Category:   CategoryDocument,
+					MIMEType:   "application/x-" + name,
+					Extensions: []string{"." + name},
+					Signatures: []Signature{magic(0, name)},
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if fileType, ok := r.Detect("photo.png", []byte("\x89PNG\r\n\x1a\n")); !ok || fileType.Name != "png" {
+					t.Errorf("detected %q while registering", fileType.Name)
+				}
+				r.Sniff([]byte("type-0-00"))
+				r.LookupMIME("application/x-type-0-00")
+			}
+		}()
+	}
+	wg.Wait()
+
+	for worker := range 8 {
+		for i := range 50 {
+			name := fmt.Sprintf("type-%d-%02d", worker, i)
+			byName, _ := r.Lookup("file." + name)
+			byContent, _ := r.Sniff([]byte(name))
+			byMIME, _ := r.LookupMIME("application/x-" + name)
+			if byName.Name != name || byContent.Name != name || byMIME.Name != name {
+				t.Fatalf("%s: looked up %q, sniffed %q, by media type %q", name, byName.Name, byContent.Name, byMIME.Name)
+			}
+		}
+	}
+}
+
+func TestIsContent(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	tests := []struct {
+		name  string
+		check func(string, []byte) bool
+		file  string
+		head  []byte
+		want  bool
+	}{
+		{"image by extension", IsImageContent, "photo.png", nil, true},
+		{"image renamed to text", IsImageContent, "notes.txt", png, true},
+		{"program renamed to image", IsImageContent, "photo.png", []byte("MZ\x90\x00"), false},
+		{"video by content", IsVideoContent, "clip.bin", []byte("\x00\x00\x00\x18ftypisom"), true},
+		{"audio by content", IsAudioContent, "track", []byte("ID3\x04\x00"), true},
+		{"document by content", IsDocumentContent, "upload", []byte("%PDF-1.7"), true},
+		{"image is no document", IsDocumentContent, "report.pdf", png, false},
+	}
+	for _, test := range tests {
+		if got := test.check(test.file, test.head); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// The extension helpers go by name only
+	if IsImageFile("notes.txt") || !IsImageFile(strings.ToUpper("photo.png")) {
+		t.Fatal("IsImageFile does not go by extension")
+	}
+}
@@ -0,0 +1,314 @@
+package sealfile
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxUploadSize is the largest file accepted when UploadOptions.MaxSize is zero
+const DefaultMaxUploadSize = 32 << 20
+
+// DefaultMaxUploadFiles is the number of files accepted per request when UploadOptions.MaxFiles is zero
+const DefaultMaxUploadFiles = 16
+
+// uploadFormOverhead is the room left for form fields and part headers in the default request size limit
+const uploadFormOverhead = 1 << 20
+
+// UploadOptions configures an upload handler
+type UploadOptions struct {
+	Dir        string         // Directory receiving uploads, relative paths are taken from PublicDir
+	MaxSize    int64          // Largest accepted file in bytes, DefaultMaxUploadSize when zero and unlimited when negative
+	MaxFiles   int            // Most files accepted per multipart request, DefaultMaxUploadFiles when zero
+	Categories []FileCategory // Accepted categories, every file when empty
+	FieldName  string         // Form field holding the files of multipart requests, "file" by default
+	Overwrite  bool           // Replace existing files instead of refusing the upload
+
+	// MaxRequestSize limits the whole request body in bytes. When zero it is MaxFiles times
+	// MaxSize plus 1 MiB for form fields, unlimited when MaxSize is; negative for no limit.
+	MaxRequestSize int64
+}
+
+// UploadResult describes a stored upload in the response
+type UploadResult struct {
+	Name     string            `json:"name"`
+	Path     string            `json:"path"`
+	URL      string            `json:"url"`
+	Size     int64             `json:"size"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Stripped []string          `json:"stripped,omitempty"` // Image metadata removed before sealing
+}
+
+// uploadError is a failed upload with the HTTP status to report
+type uploadError struct {
+	status  int
+	message string
+}
+
+// Error returns the message
+func (e *uploadError) Error() string {
+	return e.message
+}
+
+// UploadHandler returns an http.Handler sealing uploaded files into options.Dir.
+// It accepts multipart/form-data POST requests, storing every file of the form field,
+// and raw POST or PUT bodies named by the "filename" query parameter or the
+// Content-Disposition header. Uploads are streamed into sealed storage and a file that
+// fails is not stored. The response is a JSON object listing the stored files, errors
+// also list the files of the form stored before the failure. The media type stored with
+// a file is detected from its name and content, the type sent by the client is ignored.
+func (fm *FileManager) UploadHandler(options UploadOptions) http.Handler {
+	if options.MaxSize == 0 {
+		options.MaxSize = DefaultMaxUploadSize
+	}
+	if options.MaxFiles <= 0 {
+		options.MaxFiles = DefaultMaxUploadFiles
+	}
+	if options.FieldName == "" {
+		options.FieldName = "file"
+	}
+	if options.MaxRequestSize == 0 {
+		options.MaxRequestSize = -1
+		if options.MaxSize > 0 && options.MaxSize <= (math.MaxInt64-uploadFormOverhead)/int64(options.MaxFiles) {
+			options.MaxRequestSize = options.MaxSize*int64(options.MaxFiles) + uploadFormOverhead
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		if options.MaxRequestSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, options.MaxRequestSize)
+		}
+
+		fm := fm.WithContext(r.Context())
+		var results []UploadResult
+		var err error
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if r.Method == http.MethodPost && mediaType == "multipart/form-data" {
+			results, err = fm.receiveMultipart(r, options)
+		} else {
+			var result *UploadResult
+			if result, err = fm.receiveBody(r, options); err == nil {
+				results = []UploadResult{*result}
+			}
+		}
+		if err != nil {
+			writeUploadError(w, err, results)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string][]UploadResult{"files": results})
+	})
+}
+
+// receiveMultipart stores the files of a multipart form one part at a time
+func (fm *FileManager) receiveMultipart(r *http.Request, options UploadOptions) ([]UploadResult, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, &uploadError{http.StatusBadRequest, "invalid multipart request"}
+	}
+
+	var results []UploadResult
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if requestTooLarge(err) {
+				return results, err
+			}
+			return results, &uploadError{http.StatusBadRequest, "invalid multipart request"}
+		}
+		// Other fields are skipped without reading them into memory
+		if part.FormName() != options.FieldName || part.FileName() == "" {
+			continue
+		}
+		if len(results) == options.MaxFiles {
+			return results, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("more than %d files", options.MaxFiles)}
+		}
+
+		result, err := fm.storeUpload(part, part.FileName(), options)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, *result)
+	}
+
+	if len(results) == 0 {
+		return nil, &uploadError{http.StatusBadRequest, fmt.Sprintf("no file in form field %q", options.FieldName)}
+	}
+	return results, nil
+}
+
+// receiveBody stores the request body as one file
+func (fm *FileManager) receiveBody(r *http.Request, options UploadOptions) (*UploadResult, error) {
+	filename := r.URL.Query().Get("filename")
+	if filename == "" {
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+	}
+	if filename == "" {
+		return nil, &uploadError{http.StatusBadRequest, "missing filename"}
+	}
+	return fm.storeUpload(r.Body, filename, options)
+}
+
+// storeUpload checks an uploaded file and streams it into sealed storage
+func (fm *FileManager) storeUpload(r io.Reader, filename string, options UploadOptions) (*UploadResult, error) {
+	sf, err := fm.prepareUpload(filename, options)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := &limitedReader{r: r, limit: options.MaxSize}
+	content := bufio.NewReaderSize(counter, sniffLen)
+	// A short or failed peek still leaves the error to the save below
+	head, _ := content.Peek(sniffLen)
+	if fileType, ok := DefaultTypeRegistry.Detect(sf.Filename, head); ok && fileType.MIMEType != "" {
+		sf.Metadata = map[string]string{MetadataContentType: fileType.MIMEType}
+	}
+
+	// Without Overwrite the file is created atomically, an upload racing for the name fails
+	if options.Overwrite {
+		err = sf.SaveEncryptedFrom(content)
+	} else {
+		err = sf.SaveEncryptedFromIfRevision(content, "")
+	}
+	if err != nil {
+		switch {
+		case counter.exceeded:
+			return nil, &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("file %s exceeds %d bytes", sf.Filename, options.MaxSize)}
+		case errors.Is(err, ErrRevisionMismatch):
+			return nil, &uploadError{http.StatusConflict, fmt.Sprintf("file %s already exists", sf.Filename)}
+		}
+		return nil, err
+	}
+	return newUploadResult(sf, counter.read), nil
+}
+
+// prepareUpload checks the name of an upload and returns the file it is stored in
+func (fm *FileManager) prepareUpload(filename string, options UploadOptions) (*SecureFile, error) {
+	// Clients may send full paths, only the last element names the file
+	name := SanitizeFilename(filepath.Base(strings.ReplaceAll(filename, "\\", "/")))
+
+	if len(options.Categories) > 0 {
+		allowed := false
+		for _, category := range options.Categories {
+			if category.matches(name) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, &uploadError{http.StatusUnsupportedMediaType, fmt.Sprintf("file type of %s is not accepted", name)}
+		}
+	}
+
+	dir := options.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(fm.current().config.PublicDir, dir)
+	}
+	sf := fm.NewSecureFile(nil, dir, name)
+	// Refuses early what is known to conflict, storeUpload makes sure no file is replaced
+	exists, err := sf.checkSave()
+	if err != nil {
+		return nil, err
+	}
+	if exists && !options.Overwrite {
+		return nil, &uploadError{http.StatusConflict, fmt.Sprintf("file %s already exists", name)}
+	}
+	return sf, nil
+}
+
+// newUploadResult describes a stored upload
+func newUploadResult(sf *SecureFile, size int64) *UploadResult {
+	result := &UploadResult{
+		Name:     sf.Filename,
+		Path:     sf.GetFullPath(),
+		URL:      sf.GetURL(),
+		Size:     size,
+		Metadata: sf.Metadata,
+	}
+	if sf.Stripped != nil {
+		result.Stripped = sf.Stripped.Removed
+	}
+	return result
+}
+
+// limitedReader counts what is read and fails once more than limit bytes were read
+type limitedReader struct {
+	r        io.Reader
+	limit    int64 // Negative for no limit
+	read     int64
+	exceeded bool
+}
+
+// Read reads from the underlying reader
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.limit >= 0 && l.read > l.limit {
+		l.exceeded = true
+		return n, fmt.Errorf("upload exceeds %d bytes", l.limit)
+	}
+	return n, err
+}
+
+// writeUploadError reports a failed upload as JSON, listing the files stored before it failed
+func writeUploadError(w http.ResponseWriter, err error, stored []UploadResult) {
+	status, message := http.StatusInternalServerError, "failed to store upload"
+	var uploadErr *uploadError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &uploadErr):
+		status, message = uploadErr.status, uploadErr.message
+	case errors.As(err, &tooLarge):
+		status, message = http.StatusRequestEntityTooLarge, fmt.Sprintf("request exceeds %d bytes", tooLarge.Limit)
+	case errors.Is(err, ErrPathEscape):
+		status, message = http.StatusForbidden, "upload directory outside the store"
+	case errors.Is(err, ErrScanRejected):
+		status, message = http.StatusUnprocessableEntity, "file rejected by scanner"
+	case errors.Is(err, ErrContentRejected):
+		status, message = http.StatusUnsupportedMediaType, "file content not accepted"
+	case errors.Is(err, errMalformedImage):
+		status, message = http.StatusUnprocessableEntity, "image is malformed"
+	case errors.Is(err, ErrPermissionDenied):
+		status, message = http.StatusForbidden, "upload not permitted"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(struct {
+		Error string         `json:"error"`
+		Files []UploadResult `json:"files,omitempty"`
+	}{message, stored})
+}
+
+// requestTooLarge reports whether err stems from a request body beyond its limit
+func requestTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// writeJSONError writes an error response as a JSON object
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
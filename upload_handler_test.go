@@ -0,0 +1,173 @@
+package sealfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngHead is the start of a PNG file
+var pngHead = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+
+// uploadForTest posts files as a multipart form to an upload handler
+func uploadForTest(t *testing.T, handler http.Handler, contentType string, files map[string][]byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, data := range files {
+		header := make(map[string][]string)
+		header["Content-Disposition"] = []string{fmt.Sprintf(`form-data; name="file"; filename=%q`, name)}
+		header["Content-Type"] = []string{contentType}
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write(data)
+	}
+	_ = form.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+func TestUploadIgnoresClientContentType(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	handler := fm.UploadHandler(UploadOptions{Dir: "uploads"})
+
+	w := uploadForTest(t, handler, "text/html", map[string][]byte{"photo.png": pngHead})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload answered %d: %s", w.Code, w.Body)
+	}
+	var response struct{ Files []UploadResult }
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Files[0].Metadata[MetadataContentType]; got != "image/png" {
+		t.Fatalf("stored content type %q, want image/png", got)
+	}
+}
+
+func TestHandlerServesActiveContentAsAttachment(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+	handler := fm.UploadHandler(UploadOptions{Dir: "uploads"})
+	page := []byte("<html><script>alert(1)</script></html>")
+
+	w := uploadForTest(t, handler, "image/png", map[string][]byte{"page.html": page})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("upload answered %d: %s", w.Code, w.Body)
+	}
+	var response struct{ Files []UploadResult }
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	link, err := url.Parse(response.Files[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = httptest.NewRecorder()
+	fm.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, link.Path, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("handler answered %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.HasPrefix(got, "attachment") {
+		t.Fatalf("HTML served with Content-Disposition %q", got)
+	}
+	if got := w.Header().Get("Content-Security-Policy"); got != "sandbox" {
+		t.Fatalf("HTML served with Content-Security-Policy %q", got)
+	}
+}
+
+func TestUploadLimits(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+
+	handler := fm.UploadHandler(UploadOptions{Dir: "uploads", MaxFiles: 2})
+	w := uploadForTest(t, handler, "text/plain", map[string][]byte{"a.txt": []byte("a"), "b.txt": []byte("b"), "c.txt": []byte("c")})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("three files with MaxFiles 2 answered %d", w.Code)
+	}
+
+	handler = fm.UploadHandler(UploadOptions{Dir: "limited", MaxRequestSize: 1024})
+	w = uploadForTest(t, handler, "text/plain", map[string][]byte{"big.txt": bytes.Repeat([]byte("x"), 4096)})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("request beyond MaxRequestSize answered %d: %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(rootPath(fm, "public/limited/big.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("file of a refused request was stored: %v", err)
+	}
+}
+
+func TestUploadDoesNotReplaceFileCreatedMeanwhile(t *testing.T) {
+	fm := newTestFileManager(t, nil)
+
+	// The file appears after the early existence check, while the upload is sealed
+	r := &createWhileReading{r: strings.NewReader("mine"), create: func() {
+		if _, err := fm.SaveDataAsSecureFile([]byte("theirs"), filepath.Join(fm.current().config.PublicDir, "uploads"), "a.txt"); err != nil {
+			t.Error(err)
+		}
+	}}
+	_, err := fm.storeUpload(r, "a.txt", UploadOptions{Dir: "uploads", MaxSize: -1})
+	var uploadErr *uploadError
+	if !errors.As(err, &uploadErr) || uploadErr.status != http.StatusConflict {
+		t.Fatalf("got %v, want a conflict", err)
+	}
+
+	loaded, err := fm.LoadSecureFileFromDisk(filepath.Join(fm.current().config.PublicDir, "uploads"), "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(loaded.Data) != "theirs" {
+		t.Fatalf("upload replaced the file with %q", loaded.Data)
+	}
+}
+
+// createWhileReading runs create on the first read
+type createWhileReading struct {
+	r      *strings.Reader
+	create func()
+	done   bool
+}
+
+// Read reads from the underlying reader
+func (c *createWhileReading) Read(p []byte) (int, error) {
+	if !c.done {
+		c.done = true
+		c.create()
+	}
+	return c.r.Read(p)
+}
+
+func TestCommitExclusive(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "file")
+	if err := os.WriteFile(target, []byte("existing"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := writeFileRouted(target, true, func(w io.Writer) (string, error) {
+		_, err := w.Write([]byte("new"))
+		return target, err
+	})
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Fatalf("got %v, want ErrAlreadyExists", err)
+	}
+	if data, _ := os.ReadFile(target); string(data) != "existing" {
+		t.Fatalf("exclusive write replaced the file with %q", data)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("temporary file left behind: %d entries", len(entries))
+	}
+}
@@ -1,65 +1,58 @@
 package sealfile
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
 // File type detection functions
 
-// IsImageFile checks if a file is an image based on extension
+// IsImageFile checks if a file is an image based on extension only, see IsImageContent
 func IsImageFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	imageExts := []string{".jpg", ".jpeg", ".png", ".gif", ".bmp", ".webp", ".tiff", ".svg"}
-
-	for _, imgExt := range imageExts {
-		if ext == imgExt {
-			return true
-		}
-	}
-	return false
+	return CategoryImage.matches(filename)
 }
 
-// IsVideoFile checks if a file is a video based on extension
+// IsVideoFile checks if a file is a video based on extension only, see IsVideoContent
 func IsVideoFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	videoExts := []string{".mp4", ".mov", ".avi", ".mkv", ".webm", ".flv", ".wmv", ".m4v"}
-
-	for _, vidExt := range videoExts {
-		if ext == vidExt {
-			return true
-		}
-	}
-	return false
+	return CategoryVideo.matches(filename)
 }
 
-// IsAudioFile checks if a file is an audio file based on extension
+// IsAudioFile checks if a file is an audio file based on extension only, see IsAudioContent
 func IsAudioFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	audioExts := []string{".mp3", ".wav", ".flac", ".aac", ".ogg", ".wma", ".m4a"}
-
-	for _, audExt := range audioExts {
-		if ext == audExt {
-			return true
-		}
-	}
-	return false
+	return CategoryAudio.matches(filename)
 }
 
-// IsDocumentFile checks if a file is a document based on extension
+// IsDocumentFile checks if a file is a document based on extension only, see IsDocumentContent
 func IsDocumentFile(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	docExts := []string{".pdf", ".doc", ".docx", ".txt", ".rtf", ".odt", ".pages"}
+	return CategoryDocument.matches(filename)
+}
 
-	for _, docExt := range docExts {
-		if ext == docExt {
-			return true
-		}
-	}
-	return false
+// IsImageContent checks if a file is an image based on its name and the start of its
+// content, so that renamed files are recognized, see TypeRegistry.Detect
+func IsImageContent(filename string, head []byte) bool {
+	return CategoryImage.detects(filename, head)
+}
+
+// IsVideoContent checks if a file is a video based on its name and the start of its content
+func IsVideoContent(filename string, head []byte) bool {
+	return CategoryVideo.detects(filename, head)
+}
+
+// IsAudioContent checks if a file is an audio file based on its name and the start of its content
+func IsAudioContent(filename string, head []byte) bool {
+	return CategoryAudio.detects(filename, head)
+}
+
+// IsDocumentContent checks if a file is a document based on its name and the start of its content
+func IsDocumentContent(filename string, head []byte) bool {
+	return CategoryDocument.detects(filename, head)
 }
 
 // File manipulation functions
@@ -107,6 +100,99 @@ func CreateTempFile(dir, filename string, data []byte) (*os.File, error) {
 	return file, nil
 }
 
+// writeFileAtomic writes a file through a temporary file in the same directory,
+// so readers never observe partially written content
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	return writeFileRouted(path, false, func(w io.Writer) (string, error) {
+		return path, write(w)
+	})
+}
+
+// writeFileRouted is writeFileAtomic for writes deciding where the file goes once it is
+// written: write returns the path the file is moved to, normally path itself. With
+// exclusive, a file moved to path never replaces an existing one, see commitExclusive.
+func writeFileRouted(path string, exclusive bool, write func(io.Writer) (string, error)) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	target, err := write(temp)
+	if err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return err
+	}
+
+	if err := temp.Chmod(0644); err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	if err := temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if exclusive && target == path {
+		return commitExclusive(temp.Name(), target)
+	}
+	if err := os.Rename(temp.Name(), target); err != nil {
+		defer func() { _ = os.Remove(temp.Name()) }()
+		if target == path || !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("failed to write file: %w", err)
+		}
+		// The target may live on another file system
+		return writeFileAtomic(target, func(w io.Writer) error {
+			source, err := os.Open(temp.Name())
+			if err != nil {
+				return err
+			}
+			defer func() { _ = source.Close() }()
+			_, err = io.Copy(w, source)
+			return err
+		})
+	}
+	return nil
+}
+
+// commitExclusive moves a written temporary file to target unless target exists, returning
+// ErrAlreadyExists then, see renameExclusive. The temporary file is removed in any case.
+func commitExclusive(temp, target string) error {
+	defer func() { _ = os.Remove(temp) }()
+
+	if err := renameExclusive(temp, target); err != nil && !errors.Is(err, ErrAlreadyExists) {
+		return fmt.Errorf("failed to write file: %w", err)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// renameExclusive moves source to target unless target exists, returning ErrAlreadyExists
+// then and leaving source in place. Creating a hard link fails atomically when the target
+// exists, the source is removed once linked; on file systems without hard links the target
+// is checked right before the rename instead. Linking across file systems fails with EXDEV.
+func renameExclusive(source, target string) error {
+	err := os.Link(source, target)
+	if err == nil {
+		return os.Remove(source)
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, target)
+	}
+	if errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, target)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Rename(source, target)
+}
+
 // GetFileNameWithoutExtension returns filename without extension
 func GetFileNameWithoutExtension(filename string) string {
 	return strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
@@ -148,6 +234,29 @@ func EnsureDirectory(path string) error {
 	return nil
 }
 
+// checkWritableDirectory checks without side effects that files could be created in path.
+// A missing directory is accepted when it would be created.
+func checkWritableDirectory(path string, create bool) error {
+	for dir := path; ; dir = filepath.Dir(dir) {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("not a directory: %s", dir)
+			}
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to get directory info: %w", err)
+		}
+		if !create {
+			return fmt.Errorf("directory does not exist: %w", err)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return fmt.Errorf("directory does not exist: %w", err)
+		}
+	}
+}
+
 // GetFileSize returns the size of a file in bytes
 func GetFileSize(filepath string) (int64, error) {
 	info, err := os.Stat(filepath)